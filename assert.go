@@ -0,0 +1,80 @@
+package wadjit
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// ByteAssertion checks received bytes, returning an *AssertionError on mismatch.
+type ByteAssertion func(data []byte) error
+
+// AssertionError is the error of a failed ByteAssertion.
+type AssertionError struct {
+	Expected string
+	Got      []byte
+}
+
+// maxAssertionErrorData is the number of received bytes included in an AssertionError message.
+const maxAssertionErrorData = 64
+
+// Error returns a string representation of the failed assertion.
+func (e *AssertionError) Error() string {
+	got := e.Got
+	suffix := ""
+	if len(got) > maxAssertionErrorData {
+		got = got[:maxAssertionErrorData]
+		suffix = "..."
+	}
+	return fmt.Sprintf("assertion failed: expected %s, got %q%s", e.Expected, got, suffix)
+}
+
+// ExpectEqual asserts that the received bytes equal b.
+func ExpectEqual(b []byte) ByteAssertion {
+	return func(data []byte) error {
+		if !bytes.Equal(data, b) {
+			return &AssertionError{Expected: fmt.Sprintf("%q", b), Got: data}
+		}
+		return nil
+	}
+}
+
+// ExpectPrefix asserts that the received bytes start with prefix.
+func ExpectPrefix(prefix []byte) ByteAssertion {
+	return func(data []byte) error {
+		if !bytes.HasPrefix(data, prefix) {
+			return &AssertionError{Expected: fmt.Sprintf("prefix %q", prefix), Got: data}
+		}
+		return nil
+	}
+}
+
+// ExpectContains asserts that the received bytes contain sub.
+func ExpectContains(sub []byte) ByteAssertion {
+	return func(data []byte) error {
+		if !bytes.Contains(data, sub) {
+			return &AssertionError{Expected: fmt.Sprintf("to contain %q", sub), Got: data}
+		}
+		return nil
+	}
+}
+
+// ExpectMatch asserts that the received bytes match the regular expression.
+func ExpectMatch(re *regexp.Regexp) ByteAssertion {
+	return func(data []byte) error {
+		if !re.Match(data) {
+			return &AssertionError{Expected: fmt.Sprintf("to match %q", re.String()), Got: data}
+		}
+		return nil
+	}
+}
+
+// checkAssertions runs the assertions on data, returning the first failure.
+func checkAssertions(assertions []ByteAssertion, data []byte) error {
+	for _, assertion := range assertions {
+		if err := assertion(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package wadjit
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteAssertions(t *testing.T) {
+	data := []byte("220 wadjit ready")
+
+	assert.NoError(t, ExpectEqual([]byte("220 wadjit ready"))(data))
+	assert.Error(t, ExpectEqual([]byte("220"))(data))
+	assert.NoError(t, ExpectPrefix([]byte("220 "))(data))
+	assert.Error(t, ExpectPrefix([]byte("421 "))(data))
+	assert.NoError(t, ExpectContains([]byte("wadjit"))(data))
+	assert.Error(t, ExpectContains([]byte("ESMTP"))(data))
+	assert.NoError(t, ExpectMatch(regexp.MustCompile(`^\d{3} `))(data))
+	assert.Error(t, ExpectMatch(regexp.MustCompile(`^5\d\d`))(data))
+
+	err := checkAssertions([]ByteAssertion{ExpectPrefix([]byte("220")), ExpectContains([]byte("ESMTP"))}, data)
+	var assertionErr *AssertionError
+	require.True(t, errors.As(err, &assertionErr))
+	assert.Equal(t, `assertion failed: expected to contain "ESMTP", got "220 wadjit ready"`, err.Error())
+
+	// Long data is truncated in the error message
+	err = ExpectPrefix([]byte("x"))([]byte(strings.Repeat("a", 100)))
+	assert.Contains(t, err.Error(), strings.Repeat("a", maxAssertionErrorData)+`"...`)
+}
@@ -0,0 +1,362 @@
+package wadjit
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator authenticates the requests of a task, by setting headers on each request before it
+// is sent. For WSEndpoints, it is applied to the upgrade request when dialing.
+type Authenticator interface {
+	// Authenticate authenticates the request, with the body it is sent with.
+	Authenticate(ctx context.Context, req *http.Request, body []byte) error
+}
+
+// tokenInvalidator is an Authenticator with a cached token, invalidated when a request is answered
+// with 401 Unauthorized.
+type tokenInvalidator interface {
+	invalidate()
+}
+
+// BasicAuth authenticates requests with HTTP basic authentication.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Authenticate sets the Authorization header of the request.
+func (a *BasicAuth) Authenticate(_ context.Context, req *http.Request, _ []byte) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// BearerToken authenticates requests with a static bearer token.
+type BearerToken struct {
+	Token string
+}
+
+// Authenticate sets the Authorization header of the request.
+func (a *BearerToken) Authenticate(_ context.Context, req *http.Request, _ []byte) error {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// defaultTokenExpiryMargin is the time before its expiry an OAuth2 token is refreshed, when no
+// ExpiryMargin is set.
+const defaultTokenExpiryMargin = 10 * time.Second
+
+// OAuth2ClientCredentials authenticates requests with a bearer token obtained with the OAuth2
+// client credentials grant, RFC 6749 section 4.4. The token is cached until it is about to expire,
+// or until a request is answered with 401 Unauthorized.
+type OAuth2ClientCredentials struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL *url.URL
+	// ClientID and ClientSecret authenticate the client with HTTP basic authentication.
+	ClientID     string
+	ClientSecret string
+	// Scopes are the scopes requested, if any.
+	Scopes []string
+	// ExpiryMargin is the time before its expiry a token is refreshed. Defaults to 10 seconds.
+	ExpiryMargin time.Duration
+	// Client makes the token requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time // zero if the token does not expire
+}
+
+// oauth2TokenResponse is the successful response of a token endpoint, RFC 6749 section 5.1.
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Authenticate sets the Authorization header of the request, fetching a new token if the cached
+// one is missing or about to expire.
+func (a *OAuth2ClientCredentials) Authenticate(ctx context.Context, req *http.Request, _ []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	margin := a.ExpiryMargin
+	if margin == 0 {
+		margin = defaultTokenExpiryMargin
+	}
+	if a.token == "" || (!a.expiry.IsZero() && time.Now().Add(margin).After(a.expiry)) {
+		if err := a.fetchToken(ctx); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// invalidate drops the cached token, for the next request to fetch a new one.
+func (a *OAuth2ClientCredentials) invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+}
+
+// fetchToken requests a new token from the token endpoint. Note: the caller must hold the lock.
+func (a *OAuth2ClientCredentials) fetchToken(ctx context.Context) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.TokenURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request failed: %s: %s", resp.Status, body)
+	}
+
+	var token oauth2TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return errors.New("token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return fmt.Errorf("unsupported token type %q", token.TokenType)
+	}
+	a.token = token.AccessToken
+	a.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		a.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return nil
+}
+
+// validate checks that the OAuth2ClientCredentials is ready for use.
+func (a *OAuth2ClientCredentials) validate() error {
+	if a.TokenURL == nil {
+		return errors.New("TokenURL is nil")
+	}
+	if a.ClientID == "" {
+		return errors.New("ClientID is empty")
+	}
+	return nil
+}
+
+// HMACSigner authenticates requests by signing them with HMAC-SHA256 over a shared secret. The
+// signed string is the method, the request URI, the Unix time of signing and the hex SHA-256 hash
+// of the body, joined by newlines. The time is sent in TimestampHeader, and the hex signature in
+// SignatureHeader, prefixed by "KeyID:" when KeyID is set.
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+	// SignatureHeader is the header of the signature. Defaults to X-Signature.
+	SignatureHeader string
+	// TimestampHeader is the header of the time of signing. Defaults to X-Timestamp.
+	TimestampHeader string
+
+	// now returns the time of signing, time.Now when nil.
+	now func() time.Time
+}
+
+// Authenticate signs the request, setting the signature and timestamp headers.
+func (s *HMACSigner) Authenticate(_ context.Context, req *http.Request, body []byte) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	bodyHash := sha256.Sum256(body)
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.URL.RequestURI(),
+		timestamp,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(s.Secret, stringToSign))
+	if s.KeyID != "" {
+		signature = s.KeyID + ":" + signature
+	}
+	req.Header.Set(cmp.Or(s.SignatureHeader, "X-Signature"), signature)
+	req.Header.Set(cmp.Or(s.TimestampHeader, "X-Timestamp"), timestamp)
+	return nil
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with the given key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// SigV4Signer authenticates requests with AWS Signature Version 4, signing the host, the
+// X-Amz-Date header, the session token when set, and the hash of the body.
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is sent in the X-Amz-Security-Token header when set, for temporary credentials.
+	SessionToken string
+	Region       string
+	Service      string
+
+	// now returns the time of signing, time.Now when nil.
+	now func() time.Time
+}
+
+// Authenticate signs the request, setting the X-Amz-Date and Authorization headers.
+func (s *SigV4Signer) Authenticate(_ context.Context, req *http.Request, body []byte) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// 1. Canonical request
+	headers := map[string]string{"host": host, "x-amz-date": amzDate}
+	if s.SessionToken != "" {
+		headers["x-amz-security-token"] = s.SessionToken
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	// 2. String to sign
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	// 3. Signature, with the key derived for the date, region and service
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature,
+	))
+	return nil
+}
+
+// canonicalURI returns the path of u with each segment URI-encoded, once for S3 and twice for
+// other services.
+func (s *SigV4Signer) canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	segments := strings.Split(u.Path, "/")
+	for i := range segments {
+		segments[i] = uriEncode(segments[i])
+		if s.Service != "s3" {
+			segments[i] = uriEncode(segments[i])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query of u with URI-encoded keys and values, sorted by encoded key,
+// then by encoded value.
+func canonicalQuery(u *url.URL) string {
+	var params [][2]string
+	for key, values := range u.Query() {
+		for _, value := range values {
+			params = append(params, [2]string{uriEncode(key), uriEncode(value)})
+		}
+	}
+	slices.SortFunc(params, func(a, b [2]string) int {
+		return cmp.Or(strings.Compare(a[0], b[0]), strings.Compare(a[1], b[1]))
+	})
+	pairs := make([]string, len(params))
+	for i, param := range params {
+		pairs[i] = param[0] + "=" + param[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes all bytes of s but the unreserved characters of RFC 3986.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// validateAuth checks that an Authenticator is ready for use, if it is of a type with
+// requirements.
+func validateAuth(a Authenticator) error {
+	switch a := a.(type) {
+	case *OAuth2ClientCredentials:
+		return a.validate()
+	case *HMACSigner:
+		if len(a.Secret) == 0 {
+			return errors.New("HMAC secret is empty")
+		}
+	case *SigV4Signer:
+		if a.AccessKeyID == "" || a.SecretAccessKey == "" || a.Region == "" || a.Service == "" {
+			return errors.New("SigV4 signer requires AccessKeyID, SecretAccessKey, Region and Service")
+		}
+	}
+	return nil
+}
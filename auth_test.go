@@ -0,0 +1,354 @@
+package wadjit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTokenServer is an OAuth2 token endpoint issuing numbered tokens, along with an API accepting
+// only the latest token issued.
+type testTokenServer struct {
+	*httptest.Server
+	expiresIn int
+
+	mu      sync.Mutex
+	issued  int
+	current string
+}
+
+func newTestTokenServer(t *testing.T, expiresIn int) *testTokenServer {
+	t.Helper()
+	s := &testTokenServer{expiresIn: expiresIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/token":
+			clientID, clientSecret, ok := r.BasicAuth()
+			if !ok || clientID != "client" || clientSecret != "secret" ||
+				r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "read write" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+				return
+			}
+			s.issued++
+			s.current = "token-" + strconv.Itoa(s.issued)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"access_token": s.current,
+				"token_type":   "Bearer",
+				"expires_in":   s.expiresIn,
+			})
+		default:
+			if r.Header.Get("Authorization") != "Bearer "+s.current {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// revoke makes the API refuse the latest token issued.
+func (s *testTokenServer) revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = "revoked"
+}
+
+func (s *testTokenServer) tokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// executeAuth executes a request to u authenticated with auth, returning its status code.
+func executeAuth(t *testing.T, u string, auth Authenticator) int {
+	t.Helper()
+	parsed, err := url.Parse(u)
+	require.NoError(t, err)
+	ep := NewHTTPEndpoint(parsed, http.MethodGet, WithAuth(auth))
+	require.NoError(t, ep.Validate())
+	respCh := make(chan WatcherResponse, 1)
+	require.NoError(t, ep.Initialize("wid", respCh))
+	require.NoError(t, ep.Task().Execute())
+	resp := <-respCh
+	require.NoError(t, resp.Err)
+	return resp.Metadata().StatusCode
+}
+
+func TestStaticAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		auth Authenticator
+		want string
+	}{
+		{auth: &BasicAuth{Username: "user", Password: "pass"}, want: "Basic dXNlcjpwYXNz"},
+		{auth: &BearerToken{Token: "abc"}, want: "Bearer abc"},
+	} {
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithAuth(tc.auth), WithReadFast())
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		require.NoError(t, ep.Task().Execute())
+		resp := <-respCh
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, string(data))
+	}
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	newAuth := func(server *testTokenServer) *OAuth2ClientCredentials {
+		tokenURL, err := url.Parse(server.URL + "/token")
+		require.NoError(t, err)
+		return &OAuth2ClientCredentials{
+			TokenURL:     tokenURL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read", "write"},
+		}
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		server := newTestTokenServer(t, 3600)
+		auth := newAuth(server)
+		for range 3 {
+			assert.Equal(t, http.StatusOK, executeAuth(t, server.URL+"/api", auth))
+		}
+		assert.Equal(t, 1, server.tokensIssued())
+	})
+
+	t.Run("refreshed before expiry", func(t *testing.T) {
+		// Tokens expire within the default margin, and are refreshed for every request
+		server := newTestTokenServer(t, 5)
+		auth := newAuth(server)
+		for range 2 {
+			assert.Equal(t, http.StatusOK, executeAuth(t, server.URL+"/api", auth))
+		}
+		assert.Equal(t, 2, server.tokensIssued())
+	})
+
+	t.Run("refreshed after refusal", func(t *testing.T) {
+		server := newTestTokenServer(t, 0)
+		auth := newAuth(server)
+		assert.Equal(t, http.StatusOK, executeAuth(t, server.URL+"/api", auth))
+		server.revoke()
+		assert.Equal(t, http.StatusUnauthorized, executeAuth(t, server.URL+"/api", auth))
+		assert.Equal(t, http.StatusOK, executeAuth(t, server.URL+"/api", auth))
+		assert.Equal(t, 2, server.tokensIssued())
+	})
+
+	t.Run("token request failure", func(t *testing.T) {
+		server := newTestTokenServer(t, 0)
+		auth := newAuth(server)
+		auth.ClientSecret = "wrong"
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		assert.ErrorContains(t, auth.Authenticate(context.Background(), req, nil), "invalid_client")
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		assert.Error(t, validateAuth(&OAuth2ClientCredentials{ClientID: "client"}))
+		assert.Error(t, validateAuth(&OAuth2ClientCredentials{TokenURL: &url.URL{}}))
+	})
+}
+
+func TestHMACSigner(t *testing.T) {
+	secret := []byte("shared-secret")
+	signedAt := time.Unix(1700000000, 0)
+	signer := &HMACSigner{KeyID: "key-1", Secret: secret, now: func() time.Time { return signedAt }}
+
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/api/items?b=2&a=1", nil)
+	require.NoError(t, err)
+	body := []byte(`{"name":"item"}`)
+	require.NoError(t, signer.Authenticate(context.Background(), req, body))
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("POST\n/api/items?b=2&a=1\n1700000000\n" + hex.EncodeToString(bodyHash[:])))
+	assert.Equal(t, "key-1:"+hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Signature"))
+	assert.Equal(t, "1700000000", req.Header.Get("X-Timestamp"))
+
+	signer = &HMACSigner{Secret: secret, SignatureHeader: "X-Sig", TimestampHeader: "X-Time"}
+	require.NoError(t, signer.Authenticate(context.Background(), req, body))
+	assert.Len(t, req.Header.Get("X-Sig"), 64)
+	assert.NotEmpty(t, req.Header.Get("X-Time"))
+
+	assert.Error(t, validateAuth(&HMACSigner{}), "expected error for empty secret")
+}
+
+func TestSigV4Signer(t *testing.T) {
+	// The get-vanilla, get-vanilla-query-order-key-case, get-vanilla-query-order-value,
+	// get-vanilla-utf8-query and get-vanilla-query-unreserved cases of the AWS Signature Version 4
+	// test suite
+	signer := &SigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		now:             func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	cases := []struct {
+		url       string
+		signature string
+	}{
+		{
+			url:       "https://example.amazonaws.com/",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			url:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			url:       "https://example.amazonaws.com/?Param1=value2&Param1=Value1",
+			signature: "eedbc4e291e521cf13422ffca22be7d2eb8146eecf653089df300a15b2382bd1",
+		},
+		{
+			url:       "https://example.amazonaws.com/?%E1%88%B4=bar",
+			signature: "2cdec8eed098649ff3a119c94853b13c643bcf08f8b0a1d91e12c9027818dd04",
+		},
+		{
+			url: "https://example.amazonaws.com/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz" +
+				"=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signature: "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+		require.NoError(t, err)
+		require.NoError(t, signer.Authenticate(context.Background(), req, nil))
+
+		assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+		assert.Equal(t,
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+				"SignedHeaders=host;x-amz-date, Signature="+tc.signature,
+			req.Header.Get("Authorization"),
+		)
+	}
+
+	signer.SessionToken = "session"
+	req, err := http.NewRequest(http.MethodGet, cases[0].url, nil)
+	require.NoError(t, err)
+	require.NoError(t, signer.Authenticate(context.Background(), req, nil))
+	assert.Equal(t, "session", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+
+	assert.Error(t, validateAuth(&SigV4Signer{AccessKeyID: "AKIDEXAMPLE"}))
+}
+
+func TestSigV4SignerCanonicalRequest(t *testing.T) {
+	u, err := url.Parse("https://example.amazonaws.com/a%20b/%C3%A9?a-b=1&a=2&a=1&b=%C3%A9")
+	require.NoError(t, err)
+
+	// The path is encoded from its decoded form, once for S3 and twice for other services
+	assert.Equal(t, "/a%2520b/%25C3%25A9", (&SigV4Signer{Service: "service"}).canonicalURI(u))
+	assert.Equal(t, "/a%20b/%C3%A9", (&SigV4Signer{Service: "s3"}).canonicalURI(u))
+	assert.Equal(t, "/", (&SigV4Signer{Service: "service"}).canonicalURI(&url.URL{}))
+
+	// Sorted by key, then by value, rather than by the joined pairs
+	assert.Equal(t, "a=1&a=2&a-b=1&b=%C3%A9", canonicalQuery(u))
+}
+
+func TestWSEndpointAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		echoHandler(w, r)
+	}))
+	defer server.Close()
+	wsURL, err := url.Parse("ws" + server.URL[len("http"):] + "/ws")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		auth    Authenticator
+		wantErr bool
+	}{
+		{auth: &BearerToken{Token: "abc"}},
+		{auth: &BearerToken{Token: "wrong"}, wantErr: true},
+	} {
+		endpoint := NewWSEndpoint(wsURL, nil, OneHitText, []byte("hello"), "an-id")
+		endpoint.Auth = tc.auth
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+
+		err := endpoint.Task().Execute()
+		resp := <-responseChan
+		if tc.wantErr {
+			assert.Error(t, err)
+			assert.Error(t, resp.Err)
+			continue
+		}
+		require.NoError(t, err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	}
+}
+
+func TestWSEndpointAuthRefreshedAfterRefusal(t *testing.T) {
+	tokens := newTestTokenServer(t, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens.mu.Lock()
+		current := tokens.current
+		tokens.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+current {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		echoHandler(w, r)
+	}))
+	defer server.Close()
+	wsURL, err := url.Parse("ws" + server.URL[len("http"):] + "/ws")
+	require.NoError(t, err)
+	tokenURL, err := url.Parse(tokens.URL + "/token")
+	require.NoError(t, err)
+	auth := &OAuth2ClientCredentials{
+		TokenURL:     tokenURL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	}
+
+	execute := func() error {
+		endpoint := NewWSEndpoint(wsURL, nil, OneHitText, []byte("hello"), "an-id")
+		endpoint.Auth = auth
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		defer endpoint.Close()
+		_ = endpoint.Task().Execute()
+		return (<-responseChan).Err
+	}
+
+	require.NoError(t, execute())
+	tokens.revoke()
+	assert.Error(t, execute(), "expected the revoked token to be refused")
+	require.NoError(t, execute())
+	assert.Equal(t, 2, tokens.tokensIssued())
+}
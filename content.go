@@ -0,0 +1,288 @@
+package wadjit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ContentHashConfig enables the hashing of HTTP response bodies, for changes in content between
+// the responses of a task to be detected. Bodies are hashed as they are read, by the task
+// execution or from the response's Reader, and the hash is compared to that of the last body read
+// to its end.
+type ContentHashConfig struct {
+	// NewHash returns the hash function of the bodies, SHA-256 when nil.
+	NewHash func() hash.Hash
+	// DiffMaxSize is the maximum size in bytes of text bodies for which a unified diff with the
+	// previous body is made when the content changes. No diff is made when 0.
+	DiffMaxSize int
+}
+
+// maxDiffCells bounds the size of the table compared by unifiedDiff, past the lines common to the
+// start and end of both texts.
+const maxDiffCells = 1 << 20
+
+// validate checks that the ContentHashConfig is ready for use.
+func (c *ContentHashConfig) validate() error {
+	if c.DiffMaxSize < 0 {
+		return errors.New("DiffMaxSize is negative")
+	}
+	return nil
+}
+
+// newHash returns a new hash of the configured function.
+func (c *ContentHashConfig) newHash() hash.Hash {
+	if c.NewHash != nil {
+		return c.NewHash()
+	}
+	return sha256.New()
+}
+
+// contentState holds the hash of the last body read by a task, and the body itself when kept for
+// diffs.
+type contentState struct {
+	config *ContentHashConfig
+
+	mu   sync.Mutex
+	seen bool
+	hash []byte
+	body []byte // nil unless a small text body
+}
+
+// contentResult is the outcome of hashing a response body.
+type contentResult struct {
+	hash    string
+	changed bool
+	diff    string
+}
+
+// update records the hash, and body if kept, of a body read to its end, and compares it to the
+// previous one.
+func (s *contentState) update(sum, body []byte) contentResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := contentResult{hash: hex.EncodeToString(sum)}
+	if s.seen && !bytes.Equal(s.hash, sum) {
+		result.changed = true
+		if s.body != nil && body != nil {
+			result.diff = unifiedDiff(string(s.body), string(body))
+		}
+	}
+	s.seen = true
+	s.hash = sum
+	s.body = nil
+	if body != nil && isText(body) {
+		s.body = body
+	}
+	return result
+}
+
+// hashingBody hashes a response body as it is read, and records the hash in the task's
+// contentState once the body is read to its end.
+type hashingBody struct {
+	rc    io.ReadCloser
+	state *contentState
+	hash  hash.Hash
+	buf   []byte // the body, nil once it exceeds the maximum diff size
+	keep  bool
+
+	mu     sync.Mutex
+	done   bool
+	result contentResult
+}
+
+// newHashingBody wraps rc, hashing it for state.
+func newHashingBody(rc io.ReadCloser, state *contentState) *hashingBody {
+	return &hashingBody{
+		rc:    rc,
+		state: state,
+		hash:  state.config.newHash(),
+		buf:   []byte{},
+		keep:  state.config.DiffMaxSize > 0,
+	}
+}
+
+// Read reads from the underlying body, hashing the bytes read.
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		b.hash.Write(p[:n])
+		if b.keep && b.buf != nil {
+			if len(b.buf)+n > b.state.config.DiffMaxSize {
+				b.buf = nil
+			} else {
+				b.buf = append(b.buf, p[:n]...)
+			}
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		b.finish()
+	}
+	return n, err
+}
+
+// finish records the hash of the body read, once.
+func (b *hashingBody) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return
+	}
+	var body []byte
+	if b.keep {
+		body = b.buf
+	}
+	b.result = b.state.update(b.hash.Sum(nil), body)
+	b.done = true
+}
+
+// Close closes the underlying body.
+func (b *hashingBody) Close() error {
+	return b.rc.Close()
+}
+
+// contentResult returns the result of hashing the body, and false if it has not been read to its
+// end.
+func (b *hashingBody) contentResult() (contentResult, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.result, b.done
+}
+
+// isText reports whether b looks like text, valid UTF-8 without NUL bytes.
+func isText(b []byte) bool {
+	return utf8.Valid(b) && bytes.IndexByte(b, 0) == -1
+}
+
+// unifiedDiff returns the unified diff of the lines of a and b, with three lines of context.
+// Returns an empty string if they are equal, or too different to be compared cheaply.
+func unifiedDiff(a, b string) string {
+	x, y := splitLines(a), splitLines(b)
+
+	// Only the lines between those common to the start and end of both are compared
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	mx, my := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+	if len(mx) == 0 && len(my) == 0 {
+		return ""
+	}
+	if (len(mx)+1)*(len(my)+1) > maxDiffCells {
+		return ""
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of mx[i:] and my[j:]
+	lcs := make([][]int, len(mx)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(my)+1)
+	}
+	for i := len(mx) - 1; i >= 0; i-- {
+		for j := len(my) - 1; j >= 0; j-- {
+			if mx[i] == my[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// Walk the table into edits, i and j being the lines of a and b before each edit
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	edits := make([]edit, 0, len(x)+len(my))
+	for k := range prefix {
+		edits = append(edits, edit{op: ' ', line: x[k], i: k, j: k})
+	}
+	i, j := 0, 0
+	for i < len(mx) || j < len(my) {
+		switch {
+		case i < len(mx) && j < len(my) && mx[i] == my[j]:
+			edits = append(edits, edit{op: ' ', line: mx[i], i: prefix + i, j: prefix + j})
+			i++
+			j++
+		case i < len(mx) && (j == len(my) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{op: '-', line: mx[i], i: prefix + i, j: prefix + j})
+			i++
+		default:
+			edits = append(edits, edit{op: '+', line: my[j], i: prefix + i, j: prefix + j})
+			j++
+		}
+	}
+	for k := range suffix {
+		edits = append(edits, edit{op: ' ', line: x[len(x)-suffix+k], i: len(x) - suffix + k, j: len(y) - suffix + k})
+	}
+
+	const context = 3
+	var sb strings.Builder
+	sb.WriteString("--- previous\n+++ current\n")
+	for start := 0; start < len(edits); {
+		// Find the next change, and extend the hunk while changes are within the context
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for k := first + 1; k < len(edits) && k <= last+2*context; k++ {
+			if edits[k].op != ' ' {
+				last = k
+			}
+		}
+		from, to := max(first-context, 0), min(last+context+1, len(edits))
+
+		countA, countB := 0, 0
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
+		}
+		// An empty range starts at the line before it
+		startA, startB := edits[from].i+1, edits[from].j+1
+		if countA == 0 {
+			startA--
+		}
+		if countB == 0 {
+			startB--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", startA, countA, startB, countB)
+		for _, e := range edits[from:to] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+// splitLines splits s into lines, keeping their line feeds.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,155 @@
+package wadjit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	b := "one\ntwo\nthree\nfour\nfive\nsix\nseven\nEIGHT\nnine\nten\neleven"
+	want := "--- previous\n+++ current\n" +
+		"@@ -5,6 +5,7 @@\n" +
+		" five\n six\n seven\n-eight\n+EIGHT\n nine\n ten\n+eleven\n\\ No newline at end of file\n"
+	assert.Equal(t, want, unifiedDiff(a, b))
+
+	// Changes apart are in separate hunks
+	a = "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	b = "new\na\nb\nc\nd\ne\nf\ng\nh\ni\n"
+	want = "--- previous\n+++ current\n" +
+		"@@ -1,3 +1,4 @@\n+new\n a\n b\n c\n" +
+		"@@ -7,4 +8,3 @@\n g\n h\n i\n-j\n"
+	assert.Equal(t, want, unifiedDiff(a, b))
+
+	// An empty range starts at the line before it
+	assert.Equal(t, "--- previous\n+++ current\n@@ -0,0 +1,1 @@\n+x\n", unifiedDiff("", "x\n"))
+
+	assert.Empty(t, unifiedDiff(a, a))
+}
+
+// contentServer serves the body set, which can be changed between requests.
+type contentServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	body string
+}
+
+func (s *contentServer) setBody(body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body = body
+}
+
+func newContentServer(t *testing.T, body string) *contentServer {
+	t.Helper()
+	s := &contentServer{body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, _ = w.Write([]byte(s.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestHTTPEndpointContentHash(t *testing.T) {
+	sum := func(body string) string {
+		h := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(h[:])
+	}
+
+	t.Run("read by the task", func(t *testing.T) {
+		server := newContentServer(t, "status: ok\n")
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithReadFast(), WithContentHash(&ContentHashConfig{DiffMaxSize: 1024}))
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+
+		execute := func() TaskResponseMetadata {
+			require.NoError(t, ep.Task().Execute())
+			resp := <-respCh
+			require.NoError(t, resp.Err)
+			return resp.Metadata()
+		}
+
+		md := execute()
+		assert.Equal(t, sum("status: ok\n"), md.ContentHash)
+		assert.False(t, md.ContentChanged, "expected the first body not to be a change")
+
+		md = execute()
+		assert.False(t, md.ContentChanged)
+
+		server.setBody("status: degraded\n")
+		md = execute()
+		assert.Equal(t, sum("status: degraded\n"), md.ContentHash)
+		assert.True(t, md.ContentChanged)
+		assert.Equal(t, "--- previous\n+++ current\n@@ -1,1 +1,1 @@\n-status: ok\n+status: degraded\n", md.ContentDiff)
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		server := newContentServer(t, "v1")
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithContentHash(&ContentHashConfig{}))
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+
+		for i, body := range []string{"v1", "v2"} {
+			server.setBody(body)
+			require.NoError(t, ep.Task().Execute())
+			resp := <-respCh
+			require.NoError(t, resp.Err)
+			assert.Empty(t, resp.Metadata().ContentHash, "expected no hash before the body is read")
+
+			reader, err := resp.Reader()
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			assert.Equal(t, body, string(data))
+
+			md := resp.Metadata()
+			assert.Equal(t, sum(body), md.ContentHash)
+			assert.Equal(t, i > 0, md.ContentChanged)
+			assert.Empty(t, md.ContentDiff, "expected no diff without a maximum diff size")
+		}
+	})
+
+	t.Run("body too large to diff", func(t *testing.T) {
+		server := newContentServer(t, strings.Repeat("a", 100))
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithReadFast(), WithContentHash(&ContentHashConfig{DiffMaxSize: 10}))
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+
+		require.NoError(t, ep.Task().Execute())
+		<-respCh
+		server.setBody(strings.Repeat("b", 100))
+		require.NoError(t, ep.Task().Execute())
+		md := (<-respCh).Metadata()
+		assert.True(t, md.ContentChanged)
+		assert.Empty(t, md.ContentDiff)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		u, err := url.Parse("http://example.com")
+		require.NoError(t, err)
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithContentHash(&ContentHashConfig{DiffMaxSize: -1}))
+		assert.Error(t, ep.Validate())
+	})
+}
@@ -0,0 +1,291 @@
+package wadjit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// DNSRecordType is the type of a DNS resource record.
+type DNSRecordType uint16
+
+const (
+	DNSTypeA     DNSRecordType = 1
+	DNSTypeCNAME DNSRecordType = 5
+	DNSTypeMX    DNSRecordType = 15
+	DNSTypeTXT   DNSRecordType = 16
+	DNSTypeAAAA  DNSRecordType = 28
+	DNSTypeSRV   DNSRecordType = 33
+)
+
+// String returns the name of the record type, or its number for unsupported types.
+func (t DNSRecordType) String() string {
+	switch t {
+	case DNSTypeA:
+		return "A"
+	case DNSTypeCNAME:
+		return "CNAME"
+	case DNSTypeMX:
+		return "MX"
+	case DNSTypeTXT:
+		return "TXT"
+	case DNSTypeAAAA:
+		return "AAAA"
+	case DNSTypeSRV:
+		return "SRV"
+	default:
+		return "TYPE" + strconv.Itoa(int(t))
+	}
+}
+
+// supported checks if the record type is one that can be queried for.
+func (t DNSRecordType) supported() bool {
+	switch t {
+	case DNSTypeA, DNSTypeCNAME, DNSTypeMX, DNSTypeTXT, DNSTypeAAAA, DNSTypeSRV:
+		return true
+	}
+	return false
+}
+
+// DNSRecord is a resource record of a DNS answer. Value is the record data in presentation
+// format: an IP address for A and AAAA, a fully qualified name for CNAME, "preference exchange"
+// for MX, the concatenated strings for TXT and "priority weight port target" for SRV.
+type DNSRecord struct {
+	Name  string
+	Type  DNSRecordType
+	TTL   uint32
+	Value string
+}
+
+// dnsRCodeNames are the names of the DNS response codes defined in RFC 1035.
+var dnsRCodeNames = map[int]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// dnsRCodeName returns the name of a DNS response code.
+func dnsRCodeName(rcode int) string {
+	if name, ok := dnsRCodeNames[rcode]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(rcode)
+}
+
+// dnsClassINET is the Internet class, the only class queried for.
+const dnsClassINET = 1
+
+// dnsHeaderLen is the length of a DNS message header.
+const dnsHeaderLen = 12
+
+// dnsMessage is the decoded part of a DNS response relevant for monitoring.
+type dnsMessage struct {
+	id        uint16
+	truncated bool
+	rcode     int
+	answers   []DNSRecord
+}
+
+// fqdn returns the name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// appendDNSName appends the name in wire format, without compression.
+func appendDNSName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for label := range strings.SplitSeq(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// buildDNSQuery builds a recursive query for the name and record type.
+func buildDNSQuery(id uint16, name string, qtype DNSRecordType) ([]byte, error) {
+	b := make([]byte, dnsHeaderLen, 512)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], 1<<8) // RD, recursion desired
+	binary.BigEndian.PutUint16(b[4:], 1)    // QDCOUNT
+
+	b, err := appendDNSName(b, name)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(qtype))
+	b = binary.BigEndian.AppendUint16(b, dnsClassINET)
+	return b, nil
+}
+
+// parseDNSResponse decodes the header and answer section of a DNS response.
+func parseDNSResponse(msg []byte) (dnsMessage, error) {
+	if len(msg) < dnsHeaderLen {
+		return dnsMessage{}, errors.New("DNS message too short")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&(1<<15) == 0 {
+		return dnsMessage{}, errors.New("DNS message is not a response")
+	}
+	m := dnsMessage{
+		id:        binary.BigEndian.Uint16(msg[0:]),
+		truncated: flags&(1<<9) != 0,
+		rcode:     int(flags & 0xf),
+	}
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := dnsHeaderLen
+	for range qdCount {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return dnsMessage{}, fmt.Errorf("failed to read question: %w", err)
+		}
+		off = next + 4 // QTYPE and QCLASS
+	}
+
+	for range anCount {
+		record, next, err := readDNSRecord(msg, off)
+		if err != nil {
+			return dnsMessage{}, fmt.Errorf("failed to read answer: %w", err)
+		}
+		off = next
+		if record != nil {
+			m.answers = append(m.answers, *record)
+		}
+	}
+
+	return m, nil
+}
+
+// readDNSRecord reads the resource record at off. Returns a nil record for unsupported types and
+// classes, which are skipped.
+func readDNSRecord(msg []byte, off int) (*DNSRecord, int, error) {
+	name, off, err := readDNSName(msg, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off+10 > len(msg) {
+		return nil, 0, errors.New("record header out of bounds")
+	}
+	rtype := DNSRecordType(binary.BigEndian.Uint16(msg[off:]))
+	class := binary.BigEndian.Uint16(msg[off+2:])
+	ttl := binary.BigEndian.Uint32(msg[off+4:])
+	rdLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	rdStart := off + 10
+	rdEnd := rdStart + rdLen
+	if rdEnd > len(msg) {
+		return nil, 0, errors.New("record data out of bounds")
+	}
+	if class != dnsClassINET || !rtype.supported() {
+		return nil, rdEnd, nil
+	}
+
+	value, err := readDNSRecordData(msg, rtype, rdStart, rdEnd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid %s record: %w", rtype, err)
+	}
+	return &DNSRecord{Name: name, Type: rtype, TTL: ttl, Value: value}, rdEnd, nil
+}
+
+// readDNSRecordData reads the record data between start and end in presentation format.
+func readDNSRecordData(msg []byte, rtype DNSRecordType, start, end int) (string, error) {
+	rdata := msg[start:end]
+	switch rtype {
+	case DNSTypeA, DNSTypeAAAA:
+		addr, ok := netip.AddrFromSlice(rdata)
+		if !ok || (rtype == DNSTypeA) != addr.Is4() {
+			return "", errors.New("invalid address length")
+		}
+		return addr.String(), nil
+	case DNSTypeCNAME:
+		name, _, err := readDNSName(msg, start)
+		return name, err
+	case DNSTypeMX:
+		if len(rdata) < 3 {
+			return "", errors.New("data too short")
+		}
+		exchange, _, err := readDNSName(msg, start+2)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(rdata), exchange), nil
+	case DNSTypeTXT:
+		var sb strings.Builder
+		for i := 0; i < len(rdata); {
+			n := int(rdata[i])
+			if i+1+n > len(rdata) {
+				return "", errors.New("string out of bounds")
+			}
+			sb.Write(rdata[i+1 : i+1+n])
+			i += 1 + n
+		}
+		return sb.String(), nil
+	case DNSTypeSRV:
+		if len(rdata) < 7 {
+			return "", errors.New("data too short")
+		}
+		target, _, err := readDNSName(msg, start+6)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %s",
+			binary.BigEndian.Uint16(rdata), binary.BigEndian.Uint16(rdata[2:]),
+			binary.BigEndian.Uint16(rdata[4:]), target), nil
+	}
+	return "", fmt.Errorf("unsupported record type %s", rtype)
+}
+
+// readDNSName reads the possibly compressed name at off, returning it fully qualified along with
+// the offset following the name.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	// Each pointer must point backwards, which bounds the number of jumps
+	for ptrLimit := off; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("name out of bounds")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("name pointer out of bounds")
+			}
+			ptr := int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			if ptr >= ptrLimit {
+				return "", 0, errors.New("invalid name pointer")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off, ptrLimit = ptr, ptr
+		case n&0xc0 != 0:
+			return "", 0, errors.New("invalid label type")
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("label out of bounds")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package wadjit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildDNSQuery(t *testing.T) {
+	query, err := buildDNSQuery(0x1234, "wadjit.test.", DNSTypeAAAA)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0,
+		6, 'w', 'a', 'd', 'j', 'i', 't', 4, 't', 'e', 's', 't', 0,
+		0, 28, 0, 1,
+	}, query)
+
+	_, err = buildDNSQuery(1, "a."+string(make([]byte, 64))+".test", DNSTypeA)
+	assert.Error(t, err, "expected error for label longer than 63 bytes")
+}
+
+func TestParseDNSResponse(t *testing.T) {
+	header := []byte{0x12, 0x34, 0x81, 0x80, 0, 0, 0, 1, 0, 0, 0, 0}
+
+	_, err := parseDNSResponse(header[:6])
+	assert.Error(t, err, "expected error for short message")
+
+	query, err := buildDNSQuery(1, "wadjit.test", DNSTypeA)
+	require.NoError(t, err)
+	_, err = parseDNSResponse(query)
+	assert.Error(t, err, "expected error for a query")
+
+	// An answer whose name points to itself
+	loop := append(append([]byte{}, header...), 0xc0, 12)
+	_, err = parseDNSResponse(loop)
+	assert.Error(t, err, "expected error for name pointer loop")
+
+	// An answer with record data beyond the message
+	short := append(append([]byte{}, header...), 0, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0)
+	_, err = parseDNSResponse(short)
+	assert.Error(t, err, "expected error for truncated record data")
+
+	// An unsupported record type is skipped
+	skipped := append(append([]byte{}, header...), 0, 0, 99, 0, 1, 0, 0, 0, 60, 0, 1, 0)
+	msg, err := parseDNSResponse(skipped)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0x1234), msg.id)
+	assert.Empty(t, msg.answers)
+}
+
+func TestDNSRecordTypeString(t *testing.T) {
+	assert.Equal(t, "A", DNSTypeA.String())
+	assert.Equal(t, "SRV", DNSTypeSRV.String())
+	assert.Equal(t, "TYPE99", DNSRecordType(99).String())
+	assert.Equal(t, "NXDOMAIN", dnsRCodeName(3))
+	assert.Equal(t, "RCODE9", dnsRCodeName(9))
+}
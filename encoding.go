@@ -0,0 +1,89 @@
+package wadjit
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// ContentDecoder returns a reader of the decoded content of r, for a content encoding.
+type ContentDecoder func(r io.Reader) (io.ReadCloser, error)
+
+// Content encodings that can be requested by an HTTPEndpoint. Only gzip and deflate have built-in
+// decoders, br and zstd can only be requested with a decoder supplied in HTTPEndpoint.Decoders.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+)
+
+// builtinDecoders are the decoders of the content encodings supported by the standard library.
+var builtinDecoders = map[string]ContentDecoder{
+	EncodingGzip: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	// HTTP's deflate is the zlib format, RFC 9110 section 8.4.1.2
+	EncodingDeflate: zlib.NewReader,
+}
+
+// contentDecoder returns the decoder of a Content-Encoding header value, from the decoders given
+// or the built-in ones. Returns nil if the content is not encoded, is encoded more than once, or
+// has no decoder.
+func contentDecoder(contentEncoding string, decoders map[string]ContentDecoder) ContentDecoder {
+	encoding := strings.ToLower(strings.TrimSpace(contentEncoding))
+	if encoding == "" || encoding == "identity" || strings.Contains(encoding, ",") {
+		return nil
+	}
+	if decoder, ok := decoders[encoding]; ok {
+		return decoder
+	}
+	return builtinDecoders[encoding]
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	rc io.ReadCloser
+	n  atomic.Int64
+}
+
+// Read reads from the underlying reader, counting the bytes read.
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Close closes the underlying reader.
+func (c *countingReadCloser) Close() error {
+	return c.rc.Close()
+}
+
+// decodingReadCloser decodes the content of the underlying reader, creating the decoder on the
+// first read so that nothing is read before the content is.
+type decodingReadCloser struct {
+	rc        io.ReadCloser
+	newReader ContentDecoder
+
+	decoder io.ReadCloser
+	err     error
+}
+
+// Read reads decoded content.
+func (d *decodingReadCloser) Read(p []byte) (int, error) {
+	if d.decoder == nil && d.err == nil {
+		d.decoder, d.err = d.newReader(d.rc)
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.decoder.Read(p)
+}
+
+// Close closes the decoder, if created, and the underlying reader.
+func (d *decodingReadCloser) Close() error {
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+	return d.rc.Close()
+}
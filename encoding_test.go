@@ -0,0 +1,187 @@
+package wadjit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reverseDecoder is a ContentDecoder of the test encoding "x-reverse", the reversed content.
+func reverseDecoder(r io.Reader) (io.ReadCloser, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(data)
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// encodingHandler serves the content in the first encoding of the Accept-Encoding header it knows,
+// or unencoded.
+func encodingHandler(content []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for encoding := range strings.SplitSeq(r.Header.Get("Accept-Encoding"), ",") {
+			encoding = strings.TrimSpace(encoding)
+			var buf bytes.Buffer
+			switch encoding {
+			case EncodingGzip:
+				zw := gzip.NewWriter(&buf)
+				_, _ = zw.Write(content)
+				_ = zw.Close()
+			case EncodingDeflate:
+				zw := zlib.NewWriter(&buf)
+				_, _ = zw.Write(content)
+				_ = zw.Close()
+			case EncodingBrotli:
+				// Not real brotli, the content is only to be passed through undecoded
+				buf.WriteString("not really brotli")
+			case "x-reverse":
+				buf.Write(content)
+				slices.Reverse(buf.Bytes())
+			default:
+				continue
+			}
+			w.Header().Set("Content-Encoding", encoding)
+			_, _ = w.Write(buf.Bytes())
+			return
+		}
+		_, _ = w.Write(content)
+	}
+}
+
+func TestHTTPEndpointContentEncoding(t *testing.T) {
+	content := bytes.Repeat([]byte("compressible "), 1000)
+	server := httptest.NewServer(encodingHandler(content))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	execute := func(t *testing.T, opts ...HTTPEndpointOption) WatcherResponse {
+		t.Helper()
+		ep := NewHTTPEndpoint(u, http.MethodGet, append(opts, WithReadFast())...)
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		require.NoError(t, ep.Task().Execute())
+		resp := <-respCh
+		require.NoError(t, resp.Err)
+		return resp
+	}
+
+	cases := []struct {
+		name       string
+		opts       []HTTPEndpointOption
+		encoding   string
+		compressed bool
+	}{
+		{
+			name:       "gzip",
+			opts:       []HTTPEndpointOption{WithAcceptEncoding(EncodingGzip)},
+			encoding:   EncodingGzip,
+			compressed: true,
+		},
+		{
+			name:       "deflate",
+			opts:       []HTTPEndpointOption{WithAcceptEncoding(EncodingDeflate, EncodingGzip)},
+			encoding:   EncodingDeflate,
+			compressed: true,
+		},
+		{
+			name:     "custom decoder",
+			opts:     []HTTPEndpointOption{WithAcceptEncoding("x-reverse"), WithDecoder("x-reverse", reverseDecoder)},
+			encoding: "x-reverse",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := execute(t, tc.opts...)
+			data, err := resp.Data()
+			require.NoError(t, err)
+			assert.Equal(t, content, data)
+
+			md := resp.Metadata()
+			assert.Equal(t, tc.encoding, md.ContentEncoding)
+			assert.True(t, md.Decoded)
+			assert.Equal(t, int64(len(content)), md.DecodedSize)
+			if tc.compressed {
+				assert.Less(t, md.TransferredSize, md.DecodedSize)
+			} else {
+				assert.Equal(t, md.TransferredSize, md.DecodedSize)
+			}
+		})
+	}
+
+	t.Run("without decoder", func(t *testing.T) {
+		// The server sends an encoding that was not requested, passed through undecoded
+		brServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Encoding", EncodingBrotli)
+			_, _ = w.Write([]byte("not really brotli"))
+		}))
+		defer brServer.Close()
+		brURL, err := url.Parse(brServer.URL)
+		require.NoError(t, err)
+
+		ep := NewHTTPEndpoint(brURL, http.MethodGet, WithAcceptEncoding(EncodingGzip), WithReadFast())
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		require.NoError(t, ep.Task().Execute())
+		resp := <-respCh
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "not really brotli", string(data))
+
+		md := resp.Metadata()
+		assert.Equal(t, EncodingBrotli, md.ContentEncoding)
+		assert.False(t, md.Decoded)
+		assert.Equal(t, md.TransferredSize, md.DecodedSize)
+	})
+
+	t.Run("unencoded", func(t *testing.T) {
+		resp := execute(t, WithAcceptEncoding())
+		md := resp.Metadata()
+		assert.Empty(t, md.ContentEncoding)
+		assert.Equal(t, int64(len(content)), md.TransferredSize)
+		assert.Equal(t, int64(len(content)), md.DecodedSize)
+	})
+
+	t.Run("transport gzip", func(t *testing.T) {
+		resp := execute(t)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+
+		md := resp.Metadata()
+		assert.Equal(t, EncodingGzip, md.ContentEncoding)
+		assert.True(t, md.Decoded)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		assert.Error(t, NewHTTPEndpoint(u, http.MethodGet, WithAcceptEncoding("gzip, br")).Validate())
+		assert.Error(t, NewHTTPEndpoint(u, http.MethodGet, WithAcceptEncoding(EncodingBrotli)).Validate())
+		assert.Error(t, NewHTTPEndpoint(u, http.MethodGet, WithAcceptEncoding(EncodingGzip, EncodingZstd)).Validate())
+		assert.NoError(t, NewHTTPEndpoint(u, http.MethodGet, WithAcceptEncoding(EncodingZstd),
+			WithDecoder(EncodingZstd, reverseDecoder)).Validate())
+		assert.NoError(t, NewHTTPEndpoint(u, http.MethodGet, WithAcceptEncoding(EncodingDeflate, "identity")).Validate())
+	})
+}
+
+func TestContentDecoder(t *testing.T) {
+	assert.Nil(t, contentDecoder("", nil))
+	assert.Nil(t, contentDecoder("identity", nil))
+	assert.Nil(t, contentDecoder("gzip, br", nil), "expected no decoder for multiple encodings")
+	assert.Nil(t, contentDecoder(EncodingZstd, nil))
+	assert.NotNil(t, contentDecoder(" GZIP ", nil))
+	assert.NotNil(t, contentDecoder(EncodingZstd, map[string]ContentDecoder{EncodingZstd: reverseDecoder}))
+}
@@ -0,0 +1,109 @@
+package wadjit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Extractor extracts a value from a response, returning an error if the value is not found.
+type Extractor func(resp TaskResponse) (string, error)
+
+// ExtractJSON extracts the value at path from a JSON body. The path is in a dot notation, the
+// subset of JSONPath made of a dot separated list of object keys and [n] array indices, optionally
+// prefixed by "$.", e.g. "result.items[0].id". Wildcards, slices, filters, recursive descent and
+// bracketed keys are not supported, nor are JMESPath expressions. Strings are extracted as-is, and
+// other values as JSON.
+func ExtractJSON(path string) Extractor {
+	return func(resp TaskResponse) (string, error) {
+		data, err := resp.Data()
+		if err != nil {
+			return "", err
+		}
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return "", fmt.Errorf("failed to decode JSON body: %w", err)
+		}
+		v, err = dotPathValue(v, path)
+		if err != nil {
+			return "", err
+		}
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+// ExtractHeader extracts the first value of the response header with the given name.
+func ExtractHeader(name string) Extractor {
+	return func(resp TaskResponse) (string, error) {
+		values := resp.Metadata().Headers.Values(name)
+		if len(values) == 0 {
+			return "", fmt.Errorf("header %q not found", name)
+		}
+		return values[0], nil
+	}
+}
+
+// ExtractRegex extracts the first match of re in the body, or the first submatch if re has a
+// capturing group.
+func ExtractRegex(re *regexp.Regexp) Extractor {
+	return func(resp TaskResponse) (string, error) {
+		data, err := resp.Data()
+		if err != nil {
+			return "", err
+		}
+		match := re.FindSubmatch(data)
+		if match == nil {
+			return "", fmt.Errorf("no match for %s", re)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+	}
+}
+
+// dotPathValue returns the value at the dot notation path in v, a value decoded from JSON, see
+// ExtractJSON.
+func dotPathValue(v any, path string) (any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return v, nil
+	}
+	for segment := range strings.SplitSeq(path, ".") {
+		key, indices, _ := strings.Cut(segment, "[")
+		if key != "" {
+			object, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%q is not an object", key)
+			}
+			if v, ok = object[key]; !ok {
+				return nil, fmt.Errorf("key %q not found", key)
+			}
+		}
+		if indices == "" {
+			continue
+		}
+		for index := range strings.SplitSeq(strings.TrimSuffix(indices, "]"), "][") {
+			i, err := strconv.Atoi(index)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q in path %q", index, path)
+			}
+			array, ok := v.([]any)
+			if !ok {
+				return nil, errors.New("indexed value is not an array")
+			}
+			if i < 0 || i >= len(array) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			v = array[i]
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,62 @@
+package wadjit
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// headerTaskResponse is a MockTaskResponse with headers in its metadata.
+type headerTaskResponse struct {
+	MockTaskResponse
+	header http.Header
+}
+
+func (h *headerTaskResponse) Metadata() TaskResponseMetadata {
+	return TaskResponseMetadata{Headers: h.header}
+}
+
+func TestExtractors(t *testing.T) {
+	resp := &headerTaskResponse{
+		MockTaskResponse: MockTaskResponse{
+			data: []byte(`{"result":{"token":"abc","items":[{"id":1},{"id":2,"tags":["a","b"]}],"ok":true}}`),
+		},
+		header: http.Header{"X-Session": {"s1", "s2"}},
+	}
+
+	cases := []struct {
+		name      string
+		extractor Extractor
+		want      string
+		wantErr   bool
+	}{
+		{name: "JSON string", extractor: ExtractJSON("result.token"), want: "abc"},
+		{name: "JSON root prefix", extractor: ExtractJSON("$.result.token"), want: "abc"},
+		{name: "JSON number", extractor: ExtractJSON("result.items[1].id"), want: "2"},
+		{name: "JSON nested index", extractor: ExtractJSON("result.items[1].tags[0]"), want: "a"},
+		{name: "JSON bool", extractor: ExtractJSON("result.ok"), want: "true"},
+		{name: "JSON object", extractor: ExtractJSON("result.items[0]"), want: `{"id":1}`},
+		{name: "JSON missing key", extractor: ExtractJSON("result.missing"), wantErr: true},
+		{name: "JSON index out of range", extractor: ExtractJSON("result.items[2]"), wantErr: true},
+		{name: "JSON index of object", extractor: ExtractJSON("result[0]"), wantErr: true},
+		{name: "header", extractor: ExtractHeader("x-session"), want: "s1"},
+		{name: "missing header", extractor: ExtractHeader("X-Missing"), wantErr: true},
+		{name: "regex", extractor: ExtractRegex(regexp.MustCompile(`"token":"\w+"`)), want: `"token":"abc"`},
+		{name: "regex group", extractor: ExtractRegex(regexp.MustCompile(`"token":"(\w+)"`)), want: "abc"},
+		{name: "regex no match", extractor: ExtractRegex(regexp.MustCompile(`nope`)), wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.extractor(resp)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
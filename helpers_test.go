@@ -2,6 +2,7 @@ package wadjit
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -202,7 +203,8 @@ func echoHandler(w http.ResponseWriter, r *http.Request) {
 // jsonRPCServer creates a test server that responds to JSON-RPC requests.
 // The server echoes back the entire message sent to it under the "result" key, and the request ID under
 // the "id" key. If the payload is not a valid JSON-RPC request, the server will respond with a parse
-// error as per the JSON-RPC 2.0 specification.
+// error as per the JSON-RPC 2.0 specification. Requests with the method "fail" get an error response
+// instead, and batches are answered element by element.
 func jsonRPCServer() *httptest.Server {
 	// Create a test server with a custom handler
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -222,6 +224,12 @@ func jsonRPCServer() *httptest.Server {
 				if err != nil {
 					return
 				}
+				if respBytes, ok := jsonRPCBatchResponse(message); ok {
+					if err := conn.WriteMessage(mt, respBytes); err != nil {
+						return
+					}
+					continue
+				}
 				// Parse the message as a JSON-RPC request
 				var req jsonrpc.Request
 				err = req.UnmarshalJSON(message)
@@ -248,6 +256,10 @@ func jsonRPCServer() *httptest.Server {
 					Error:   nil,
 					Result:  message,
 				}
+				if req.Method == "fail" {
+					resp.Result = nil
+					resp.Error = &jsonrpc.Error{Code: -32000, Message: "failure"}
+				}
 				respBytes, _ := resp.MarshalJSON()
 				err = conn.WriteMessage(mt, respBytes)
 				if err != nil {
@@ -272,6 +284,13 @@ func jsonRPCServer() *httptest.Server {
 				return
 			}
 
+			if respBytes, ok := jsonRPCBatchResponse(payload); ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write(respBytes)
+				return
+			}
+
 			// Parse the payload as a JSON-RPC request
 			var req jsonrpc.Request
 			err = req.UnmarshalJSON(payload)
@@ -298,6 +317,10 @@ func jsonRPCServer() *httptest.Server {
 				Error:   nil,
 				Result:  payload,
 			}
+			if req.Method == "fail" {
+				resp.Result = nil
+				resp.Error = &jsonrpc.Error{Code: -32000, Message: "failure"}
+			}
 			respBytes, _ := resp.MarshalJSON()
 
 			w.Header().Set("Content-Type", "application/json")
@@ -310,6 +333,32 @@ func jsonRPCServer() *httptest.Server {
 	return server
 }
 
+// jsonRPCBatchResponse builds the response to a JSON-RPC batch request, echoing each element's
+// request as its result, except for requests with the method "fail" which get an error. Returns
+// false if the message is not a batch.
+func jsonRPCBatchResponse(message []byte) ([]byte, bool) {
+	var batch []json.RawMessage
+	if len(message) == 0 || message[0] != '[' || json.Unmarshal(message, &batch) != nil {
+		return nil, false
+	}
+	var responses []json.RawMessage
+	for _, element := range batch {
+		var req jsonrpc.Request
+		if err := req.UnmarshalJSON(element); err != nil || req.ID == nil {
+			continue // Skip invalid requests and notifications
+		}
+		resp := jsonrpc.Response{JSONRPC: "2.0", ID: req.ID, Result: element}
+		if req.Method == "fail" {
+			resp.Result = nil
+			resp.Error = &jsonrpc.Error{Code: -32000, Message: "failure"}
+		}
+		respBytes, _ := resp.MarshalJSON()
+		responses = append(responses, respBytes)
+	}
+	respBytes, _ := json.Marshal(responses)
+	return respBytes, true
+}
+
 // jsonRPCServerWithServerDisconnect creates a test server that responds to a single
 // JSON-RPC request and then closes the WebSocket connection from the server side.
 func jsonRPCServerWithServerDisconnect() *httptest.Server {
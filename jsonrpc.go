@@ -0,0 +1,130 @@
+package wadjit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jkbrsn/go-jsonrpc"
+)
+
+// JSONRPCError is the error object of a JSON-RPC response. Implements the error interface.
+type JSONRPCError struct {
+	Code    int
+	Message string
+	Data    any
+}
+
+// Error returns a string representation of the JSON-RPC error.
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// JSONRPCResult is the decoded outcome of a single JSON-RPC request, i.e. a response or one
+// element of a batch response. Exactly one of Result and Error is set.
+type JSONRPCResult struct {
+	ID     any
+	Result json.RawMessage
+	Error  *JSONRPCError
+}
+
+// decodeJSONRPCResults decodes a JSON-RPC response, or batch response, into one result per element.
+func decodeJSONRPCResults(msg []byte) ([]JSONRPCResult, error) {
+	elements := [][]byte{msg}
+	if isJSONArray(msg) {
+		var err error
+		elements, err = splitJSONArray(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]JSONRPCResult, 0, len(elements))
+	for _, element := range elements {
+		resp, err := jsonrpc.DecodeResponse(element)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing jsonrpc.Response from bytes: %w", err)
+		}
+		result := JSONRPCResult{ID: resp.ID, Result: resp.Result}
+		if resp.Error != nil {
+			result.Error = &JSONRPCError{
+				Code:    resp.Error.Code,
+				Message: resp.Error.Message,
+				Data:    resp.Error.Data,
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// isJSONArray checks if the first non-whitespace character of msg opens a JSON array, which for
+// JSON-RPC means that msg is a batch.
+func isJSONArray(msg []byte) bool {
+	trimmed := bytes.TrimLeft(msg, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// splitJSONArray splits a JSON array into its raw elements. An empty array is an error, since
+// it is not a valid JSON-RPC batch.
+func splitJSONArray(msg []byte) ([][]byte, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON-RPC batch: %w", err)
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty JSON-RPC batch")
+	}
+	elements := make([][]byte, len(raw))
+	for i := range raw {
+		elements[i] = raw[i]
+	}
+	return elements, nil
+}
+
+// joinJSONArray joins raw elements into a JSON array.
+func joinJSONArray(elements [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, element := range elements {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(element)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// validateJSONRPCPayload checks that msg is a JSON-RPC request, or a batch of requests.
+func validateJSONRPCPayload(msg []byte) error {
+	if len(msg) == 0 {
+		return errors.New("empty JSON-RPC message")
+	}
+	elements := [][]byte{msg}
+	if isJSONArray(msg) {
+		var err error
+		elements, err = splitJSONArray(msg)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range elements {
+		req := &jsonrpc.Request{}
+		if err := req.UnmarshalJSON(elements[i]); err != nil {
+			return fmt.Errorf("failed to unmarshal JSON-RPC message at index %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// jsonRPCResultsErr returns the error object of a single JSON-RPC response, decoded from msg, as
+// an error value, or nil if there is none. Batches never return an error, their errors are found
+// per element in the results.
+func jsonRPCResultsErr(msg []byte, results []JSONRPCResult) error {
+	if !isJSONArray(msg) && len(results) == 1 && results[0].Error != nil {
+		return results[0].Error
+	}
+	return nil
+}
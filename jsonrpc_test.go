@@ -0,0 +1,37 @@
+package wadjit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeJSONRPCResults(t *testing.T) {
+	t.Run("single", func(t *testing.T) {
+		results, err := decodeJSONRPCResults([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.EqualValues(t, 1, results[0].ID)
+		assert.JSONEq(t, `"0x10"`, string(results[0].Result))
+		assert.Nil(t, results[0].Error)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		results, err := decodeJSONRPCResults([]byte(` [{"jsonrpc":"2.0","id":1,"result":true},` +
+			`{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"Method not found"}}]`))
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Nil(t, results[0].Error)
+		require.NotNil(t, results[1].Error)
+		assert.Equal(t, -32601, results[1].Error.Code)
+		assert.Equal(t, "JSON-RPC error -32601: Method not found", results[1].Error.Error())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := decodeJSONRPCResults([]byte(`[]`))
+		assert.Error(t, err)
+		_, err = decodeJSONRPCResults([]byte(`not json`))
+		assert.Error(t, err)
+	})
+}
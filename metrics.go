@@ -0,0 +1,22 @@
+package wadjit
+
+// MetricsExporter exports metrics of the responses received by a Wadjit, e.g. to Prometheus or
+// OpenTelemetry. Implementations must be safe for concurrent use.
+type MetricsExporter interface {
+	// SetGauge sets the gauge of the given name and labels to value.
+	SetGauge(name string, labels map[string]string, value float64)
+}
+
+// exportValues sets a gauge for each number extracted from a response, named after the value and
+// labelled with the IDs of the watcher and task of the response.
+func exportValues(exporter MetricsExporter, resp WatcherResponse) {
+	for name, value := range resp.Values {
+		if value.Kind != ValueKindNumber {
+			continue
+		}
+		exporter.SetGauge(name, map[string]string{
+			"watcher_id": resp.WatcherID,
+			"task_id":    resp.TaskID,
+		}, value.Number)
+	}
+}
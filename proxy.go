@@ -0,0 +1,321 @@
+package wadjit
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ProxyConfig routes the connections of a task through a proxy, tunneling them with HTTP CONNECT or
+// SOCKS5. Proxy settings from the environment are ignored for tasks with a ProxyConfig.
+type ProxyConfig struct {
+	// URL is the proxy, http:// for HTTP CONNECT, socks5:// for SOCKS5 with the target resolved
+	// locally, or socks5h:// for SOCKS5 with the target resolved by the proxy. Credentials in the
+	// URL's user info are used unless Username is set. The port defaults to 80 for HTTP and 1080
+	// for SOCKS5.
+	URL *url.URL
+	// Username and Password authenticate with the proxy, using basic authentication for HTTP
+	// CONNECT and username/password authentication for SOCKS5. Credentials, set here or in the URL,
+	// are limited to 255 bytes each.
+	Username string
+	Password string
+	// Header holds additional headers sent with CONNECT requests.
+	Header http.Header
+}
+
+// proxyTimesKey is the context key of the timestamps the proxy handshake is recorded in.
+type proxyTimesKey struct{}
+
+// withProxyTimes returns a context recording the proxy handshake of connections dialed with it in
+// times.
+func withProxyTimes(ctx context.Context, times *requestTimestamps) context.Context {
+	return context.WithValue(ctx, proxyTimesKey{}, times)
+}
+
+// validate checks that the ProxyConfig is ready for use.
+func (p *ProxyConfig) validate() error {
+	if p.URL == nil {
+		return errors.New("proxy URL is nil")
+	}
+	switch p.URL.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q", p.URL.Scheme)
+	}
+	if p.URL.Hostname() == "" {
+		return errors.New("proxy URL has no host")
+	}
+	// SOCKS5 encodes the lengths of the credentials, from the fields or the URL, in a byte
+	if username, password, _ := p.credentials(); len(username) > 255 || len(password) > 255 {
+		return errors.New("proxy credentials longer than 255 bytes")
+	}
+	return nil
+}
+
+// address returns the host and port of the proxy.
+func (p *ProxyConfig) address() string {
+	port := p.URL.Port()
+	if port == "" {
+		port = "1080"
+		if p.URL.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(p.URL.Hostname(), port)
+}
+
+// credentials returns the username and password to authenticate with, and whether any are set.
+func (p *ProxyConfig) credentials() (string, string, bool) {
+	if p.Username != "" {
+		return p.Username, p.Password, true
+	}
+	if p.URL.User != nil {
+		password, _ := p.URL.User.Password()
+		return p.URL.User.Username(), password, true
+	}
+	return "", "", false
+}
+
+// dialContext returns a dial function that connects to the proxy and opens a tunnel to the address
+// asked for, or to the literal address of the TransportControl if non-nil. The TCP connect phase
+// is that of the connection to the proxy, and the tunnel is recorded as the proxy phase in the
+// timestamps of the context, see withProxyTimes.
+func (p *ProxyConfig) dialContext(tc *TransportControl) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		if tc != nil {
+			addr = tc.AddrPort.String()
+		}
+
+		// TODO: move timeout to configuration
+		d := &net.Dialer{Timeout: 5 * time.Second}
+		conn, err := d.DialContext(ctx, "tcp", p.address())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+		}
+
+		times, _ := ctx.Value(proxyTimesKey{}).(*requestTimestamps)
+		if times != nil {
+			times.proxyStart = time.Now()
+		}
+		// TODO: move timeout to configuration
+		deadline := time.Now().Add(5 * time.Second)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if p.URL.Scheme == "http" {
+			err = p.connectHTTP(conn, addr)
+		} else {
+			err = p.connectSOCKS5(ctx, conn, addr)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if times != nil {
+			times.proxyDone = time.Now()
+		}
+		return conn, nil
+	}
+}
+
+// connectHTTP opens a tunnel to addr with an HTTP CONNECT request.
+func (p *ProxyConfig) connectHTTP(conn net.Conn, addr string) error {
+	header := make(http.Header)
+	for key, values := range p.Header {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: header,
+	}
+	if username, password, ok := p.credentials(); ok {
+		request.SetBasicAuth(username, password)
+		// SetBasicAuth sets the header for origin servers, move it to the proxy's header
+		request.Header.Set("Proxy-Authorization", request.Header.Get("Authorization"))
+		request.Header.Del("Authorization")
+	}
+	if err := request.Write(conn); err != nil {
+		return fmt.Errorf("failed to write CONNECT request: %w", err)
+	}
+
+	// The server speaks only after the client in the tunneled protocols, so nothing beyond the
+	// response is buffered
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		return fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy CONNECT failed: %s", response.Status)
+	}
+	return nil
+}
+
+// socks5Replies are the descriptions of the SOCKS5 reply codes defined in RFC 1928.
+var socks5Replies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5UserPass     = 2
+	socks5NoAcceptable = 0xff
+	socks5Connect      = 1
+	socks5AddrIPv4     = 1
+	socks5AddrDomain   = 3
+	socks5AddrIPv6     = 4
+)
+
+// connectSOCKS5 opens a tunnel to addr with a SOCKS5 CONNECT command, authenticating with username
+// and password if credentials are set.
+func (p *ProxyConfig) connectSOCKS5(ctx context.Context, conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %w", err)
+	}
+
+	// 1. Negotiate the authentication method
+	username, password, hasCredentials := p.credentials()
+	method := byte(socks5NoAuth)
+	if hasCredentials {
+		method = socks5UserPass
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return fmt.Errorf("failed to write SOCKS5 greeting: %w", err)
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 greeting: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	if reply[1] != method {
+		return errors.New("SOCKS5 proxy accepted no authentication method offered")
+	}
+
+	// 2. Authenticate, RFC 1929
+	if method == socks5UserPass {
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 credentials longer than 255 bytes")
+		}
+		auth := []byte{1, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return fmt.Errorf("failed to write SOCKS5 authentication: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return fmt.Errorf("failed to read SOCKS5 authentication: %w", err)
+		}
+		if reply[0] != 1 {
+			return fmt.Errorf("unexpected SOCKS5 authentication version %d", reply[0])
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+
+	// 3. Connect, resolving the host locally unless the proxy is to resolve it
+	request := []byte{socks5Version, socks5Connect, 0}
+	ip, err := netip.ParseAddr(host)
+	if err != nil && p.URL.Scheme == "socks5" {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %q: %w", host, err)
+		}
+		if len(addrs) == 0 {
+			return fmt.Errorf("no addresses found for %q", host)
+		}
+		ip = addrs[0]
+	}
+	switch {
+	case ip.Is4() || ip.Is4In6():
+		request = append(request, socks5AddrIPv4)
+		request = append(request, ip.Unmap().AsSlice()...)
+	case ip.Is6():
+		request = append(request, socks5AddrIPv6)
+		request = append(request, ip.AsSlice()...)
+	default:
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long", host)
+		}
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("failed to write SOCKS5 request: %w", err)
+	}
+
+	// 4. Read the reply, discarding the bound address
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 reply: %w", err)
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", header[0])
+	}
+	if header[1] != 0 {
+		reason, ok := socks5Replies[header[1]]
+		if !ok {
+			reason = "reply " + strconv.Itoa(int(header[1]))
+		}
+		return fmt.Errorf("SOCKS5 connect failed: %s", reason)
+	}
+	var boundLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		boundLen = 4
+	case socks5AddrIPv6:
+		boundLen = 16
+	case socks5AddrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return fmt.Errorf("failed to read SOCKS5 reply: %w", err)
+		}
+		boundLen = int(l[0])
+	default:
+		return fmt.Errorf("unexpected SOCKS5 address type %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, boundLen+2)); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 reply: %w", err)
+	}
+	return nil
+}
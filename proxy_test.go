@@ -0,0 +1,361 @@
+package wadjit
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testProxy is an HTTP CONNECT or SOCKS5 proxy requiring the given credentials, if any. The
+// targets of the tunnels opened are sent on targets.
+type testProxy struct {
+	listener net.Listener
+	username string
+	password string
+	targets  chan string
+}
+
+// newTestProxy starts a proxy speaking HTTP CONNECT, or SOCKS5 if socks is set.
+func newTestProxy(t *testing.T, socks bool, username, password string) *testProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &testProxy{
+		listener: listener,
+		username: username,
+		password: password,
+		targets:  make(chan string, 10),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var target string
+				if socks {
+					target = p.handshakeSOCKS5(conn)
+				} else {
+					target = p.handshakeHTTP(conn)
+				}
+				if target == "" {
+					return
+				}
+				p.targets <- target
+				p.pipe(conn, target)
+			}()
+		}
+	}()
+
+	return p
+}
+
+// url returns the URL of the proxy with the given scheme.
+func (p *testProxy) url(scheme string) *url.URL {
+	return &url.URL{Scheme: scheme, Host: p.listener.Addr().String()}
+}
+
+// handshakeHTTP reads a CONNECT request and returns its target, or an empty string if refused.
+func (p *testProxy) handshakeHTTP(conn net.Conn) string {
+	request, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || request.Method != http.MethodConnect {
+		return ""
+	}
+	if p.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.username + ":" + p.password))
+		if request.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+			_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return ""
+		}
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return request.Host
+}
+
+// handshakeSOCKS5 negotiates a SOCKS5 CONNECT and returns its target, or an empty string if
+// refused.
+func (p *testProxy) handshakeSOCKS5(conn net.Conn) string {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return ""
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return ""
+	}
+	method := byte(socks5NoAuth)
+	if p.username != "" {
+		method = socks5UserPass
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return ""
+	}
+
+	if method == socks5UserPass {
+		reader := bufio.NewReader(conn)
+		readString := func() string {
+			l, _ := reader.ReadByte()
+			b := make([]byte, l)
+			_, _ = io.ReadFull(reader, b)
+			return string(b)
+		}
+		_, _ = reader.ReadByte() // Version
+		username, password := readString(), readString()
+		if username != p.username || password != p.password {
+			_, _ = conn.Write([]byte{1, 1})
+			return ""
+		}
+		if _, err := conn.Write([]byte{1, 0}); err != nil {
+			return ""
+		}
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return ""
+	}
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		addr := make([]byte, 4)
+		if header[3] == socks5AddrIPv6 {
+			addr = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return ""
+		}
+		host = net.IP(addr).String()
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return ""
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return ""
+		}
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return ""
+	}
+	if _, err := conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0}); err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+}
+
+// pipe copies data between the client connection and the target until either side closes.
+func (p *testProxy) pipe(conn net.Conn, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go func() { _, _ = io.Copy(upstream, conn) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func TestProxyConfigValidate(t *testing.T) {
+	assert.Error(t, (&ProxyConfig{}).validate(), "expected error for nil URL")
+	assert.Error(t, (&ProxyConfig{URL: &url.URL{Scheme: "ftp", Host: "proxy:21"}}).validate())
+	assert.Error(t, (&ProxyConfig{URL: &url.URL{Scheme: "http"}}).validate())
+	assert.NoError(t, (&ProxyConfig{URL: &url.URL{Scheme: "socks5h", Host: "proxy"}}).validate())
+	long := strings.Repeat("x", 256)
+	assert.Error(t, (&ProxyConfig{URL: &url.URL{Scheme: "socks5", Host: "proxy"}, Username: long}).validate())
+	assert.Error(t, (&ProxyConfig{URL: &url.URL{Scheme: "socks5", Host: "proxy", User: url.User(long)}}).validate())
+	assert.Error(t, (&ProxyConfig{
+		URL: &url.URL{Scheme: "socks5", Host: "proxy", User: url.UserPassword("user", long)},
+	}).validate())
+
+	assert.Equal(t, "proxy:80", (&ProxyConfig{URL: &url.URL{Scheme: "http", Host: "proxy"}}).address())
+	assert.Equal(t, "proxy:1080", (&ProxyConfig{URL: &url.URL{Scheme: "socks5", Host: "proxy"}}).address())
+}
+
+func TestConnectSOCKS5ReplyVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		buf := make([]byte, 64)
+		if _, err := server.Read(buf); err != nil {
+			return
+		}
+		_, _ = server.Write([]byte{socks5Version, socks5NoAuth})
+		if _, err := server.Read(buf); err != nil {
+			return
+		}
+		// A SOCKS4 reply to the connect request
+		_, _ = server.Write([]byte{0, 0x5a, 0, 0})
+	}()
+
+	p := &ProxyConfig{URL: &url.URL{Scheme: "socks5h", Host: "proxy"}}
+	err := p.connectSOCKS5(context.Background(), client, "target.test:80")
+	assert.ErrorContains(t, err, "unexpected SOCKS version 0")
+}
+
+func TestConnectSOCKS5AuthVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		buf := make([]byte, 64)
+		if _, err := server.Read(buf); err != nil {
+			return
+		}
+		_, _ = server.Write([]byte{socks5Version, socks5UserPass})
+		if _, err := server.Read(buf); err != nil {
+			return
+		}
+		// The version of the reply is that of the SOCKS protocol, not of RFC 1929
+		_, _ = server.Write([]byte{socks5Version, 0})
+	}()
+
+	p := &ProxyConfig{URL: &url.URL{Scheme: "socks5h", Host: "proxy"}, Username: "user", Password: "pass"}
+	err := p.connectSOCKS5(context.Background(), client, "target.test:80")
+	assert.ErrorContains(t, err, "unexpected SOCKS5 authentication version 5")
+}
+
+func TestHTTPEndpointProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(echoHandler))
+	defer tlsServer.Close()
+
+	connectProxy := newTestProxy(t, false, "user", "secret")
+	socksProxy := newTestProxy(t, true, "user", "secret")
+
+	authURL := connectProxy.url("http")
+	authURL.User = url.UserPassword("user", "secret")
+	localhostURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	localhostURL.Host = net.JoinHostPort("localhost", localhostURL.Port())
+
+	cases := []struct {
+		name       string
+		serverURL  string
+		proxy      *ProxyConfig
+		wantTarget string
+	}{
+		{
+			name:      "CONNECT with URL credentials",
+			serverURL: server.URL,
+			proxy:     &ProxyConfig{URL: authURL},
+		},
+		{
+			name:      "CONNECT to TLS server",
+			serverURL: tlsServer.URL,
+			proxy:     &ProxyConfig{URL: connectProxy.url("http"), Username: "user", Password: "secret"},
+		},
+		{
+			name:      "SOCKS5",
+			serverURL: server.URL,
+			proxy:     &ProxyConfig{URL: socksProxy.url("socks5"), Username: "user", Password: "secret"},
+		},
+		{
+			name:       "SOCKS5 with proxy resolution",
+			serverURL:  localhostURL.String(),
+			proxy:      &ProxyConfig{URL: socksProxy.url("socks5h"), Username: "user", Password: "secret"},
+			wantTarget: localhostURL.Host,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.serverURL)
+			require.NoError(t, err)
+			ep := NewHTTPEndpoint(u, http.MethodPost, WithPayload([]byte("hello")), WithProxy(tc.proxy))
+			if u.Scheme == "https" {
+				ep.TLS = &TLSConfig{SkipVerify: true}
+			}
+			require.NoError(t, ep.Validate())
+			respCh := make(chan WatcherResponse, 1)
+			require.NoError(t, ep.Initialize("wid", respCh))
+
+			require.NoError(t, ep.Task().Execute())
+			resp := <-respCh
+			require.NoError(t, resp.Err)
+			data, err := resp.Data()
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+
+			md := resp.Metadata()
+			require.NotNil(t, md.TimeData.TCPConnect)
+			require.NotNil(t, md.TimeData.ProxyConnect)
+			assert.Positive(t, *md.TimeData.ProxyConnect)
+
+			wantTarget := tc.wantTarget
+			if wantTarget == "" {
+				wantTarget = u.Host
+			}
+			proxy := connectProxy
+			if tc.proxy.URL.Scheme != "http" {
+				proxy = socksProxy
+			}
+			assert.Equal(t, wantTarget, <-proxy.targets)
+		})
+	}
+
+	t.Run("authentication failure", func(t *testing.T) {
+		for _, proxy := range []*ProxyConfig{
+			{URL: connectProxy.url("http"), Username: "user", Password: "wrong"},
+			{URL: socksProxy.url("socks5"), Username: "user", Password: "wrong"},
+		} {
+			u, err := url.Parse(server.URL)
+			require.NoError(t, err)
+			ep := NewHTTPEndpoint(u, http.MethodGet, WithProxy(proxy))
+			require.NoError(t, ep.Validate())
+			respCh := make(chan WatcherResponse, 1)
+			require.NoError(t, ep.Initialize("wid", respCh))
+
+			assert.Error(t, ep.Task().Execute())
+			resp := <-respCh
+			assert.Error(t, resp.Err)
+		}
+	})
+}
+
+func TestWSEndpointProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+	proxy := newTestProxy(t, true, "", "")
+
+	wsURL, err := url.Parse("ws" + server.URL[len("http"):] + "/ws")
+	require.NoError(t, err)
+
+	endpoint := NewWSEndpoint(wsURL, nil, OneHitText, []byte("hello"), "an-id")
+	endpoint.Proxy = &ProxyConfig{URL: proxy.url("socks5")}
+	require.NoError(t, endpoint.Validate())
+	responseChan := make(chan WatcherResponse, 1)
+	require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+	defer endpoint.Close()
+
+	assert.NoError(t, endpoint.Task().Execute())
+	resp := <-responseChan
+	require.NoError(t, resp.Err)
+	data, err := resp.Data()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, wsURL.Host, <-proxy.targets)
+
+	md := resp.Metadata()
+	require.NotNil(t, md.TimeData.ProxyConnect)
+	assert.Positive(t, *md.TimeData.ProxyConnect)
+}
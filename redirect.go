@@ -0,0 +1,126 @@
+package wadjit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// defaultMaxRedirects is the number of redirects followed when no MaxHops is set, matching the
+// default policy of http.Client.
+const defaultMaxRedirects = 10
+
+// RedirectPolicy controls which redirects an HTTPEndpoint follows. A redirect not followed because
+// of MaxHops or SameHost is set as the response's error, the redirect response being the payload.
+type RedirectPolicy struct {
+	// NoFollow makes the task report redirect responses as-is, without error.
+	NoFollow bool
+	// MaxHops is the maximum number of redirects followed. Defaults to 10.
+	MaxHops int
+	// SameHost restricts redirects to the host, and port, of the endpoint's URL.
+	SameHost bool
+}
+
+// RedirectHop is a redirect response followed by a request.
+type RedirectHop struct {
+	// URL is the URL requested, and StatusCode and Location the redirect response to it.
+	URL        *url.URL
+	StatusCode int
+	Location   string
+	RemoteAddr net.Addr
+	// TimeData contains the timing information of the request.
+	TimeData RequestTimes
+}
+
+// RedirectError is the error of a redirect not followed because of the RedirectPolicy.
+type RedirectError struct {
+	URL    *url.URL
+	Reason string
+}
+
+// Error returns a string representation of the redirect not followed.
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirect to %s not followed: %s", e.URL, e.Reason)
+}
+
+// redirectChainKey is the context key of the redirectChain of a request.
+type redirectChainKey struct{}
+
+// redirectChain records the redirects followed by a request, along with the error of one not
+// followed. The timestamps, remote address and wrote signal are those traced for the request, and
+// are reset for each redirect followed.
+type redirectChain struct {
+	hops []RedirectHop
+	err  error
+
+	timestamps *requestTimestamps
+	remoteAddr *net.Addr
+	wrote      *wroteSignal
+}
+
+// checkRedirect applies the policy to a redirect of a request made with a redirectChain in its
+// context, recording the redirects followed in the chain. Meant as the CheckRedirect function of an
+// http.Client.
+func (p *RedirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	chain, _ := req.Context().Value(redirectChainKey{}).(*redirectChain)
+
+	policy := RedirectPolicy{}
+	if p != nil {
+		policy = *p
+	}
+	maxHops := policy.MaxHops
+	if maxHops == 0 {
+		maxHops = defaultMaxRedirects
+	}
+
+	var err error
+	switch {
+	case policy.NoFollow:
+		return http.ErrUseLastResponse
+	case len(via) > maxHops:
+		err = &RedirectError{URL: req.URL, Reason: fmt.Sprintf("stopped after %d redirects", maxHops)}
+	case policy.SameHost && req.URL.Host != via[0].URL.Host:
+		err = &RedirectError{URL: req.URL, Reason: "host differs from " + via[0].URL.Host}
+	}
+	if chain == nil {
+		return err
+	}
+	if err != nil {
+		chain.err = err
+		return http.ErrUseLastResponse
+	}
+
+	previous := via[len(via)-1]
+	hop := RedirectHop{URL: previous.URL}
+	if req.Response != nil {
+		hop.StatusCode = req.Response.StatusCode
+		hop.Location = req.Response.Header.Get("Location")
+		// HTTP/2 writes the request from another goroutine, wait for it before reading the
+		// timestamps and resetting them for the next hop
+		if req.Response.ProtoMajor == 2 && chain.wrote != nil {
+			<-chain.wrote.wait()
+		}
+	}
+	if chain.wrote != nil {
+		chain.wrote.reset()
+	}
+	if chain.timestamps != nil {
+		hop.TimeData = TimeDataFromTimestamps(*chain.timestamps)
+		*chain.timestamps = requestTimestamps{}
+	}
+	if chain.remoteAddr != nil {
+		hop.RemoteAddr = *chain.remoteAddr
+	}
+	chain.hops = append(chain.hops, hop)
+	return nil
+}
+
+// validate checks that the RedirectPolicy is ready for use.
+func (p *RedirectPolicy) validate() error {
+	if p.MaxHops < 0 {
+		return errors.New("MaxHops is negative")
+	}
+	return nil
+}
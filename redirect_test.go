@@ -0,0 +1,161 @@
+package wadjit
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redirectHandler redirects /hops/N to /hops/N-1, and /away to the same server by another host
+// name. Other paths are echoed.
+func redirectHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/hops/"):
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hops/"))
+		if err != nil || n == 0 {
+			_, _ = w.Write([]byte("arrived"))
+			return
+		}
+		http.Redirect(w, r, "/hops/"+strconv.Itoa(n-1), http.StatusFound)
+	case r.URL.Path == "/away":
+		_, port, _ := net.SplitHostPort(r.Host)
+		http.Redirect(w, r, "http://"+net.JoinHostPort("localhost", port)+"/hops/0", http.StatusMovedPermanently)
+	default:
+		echoHandler(w, r)
+	}
+}
+
+func TestHTTPEndpointRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(redirectHandler))
+	defer server.Close()
+
+	execute := func(t *testing.T, path string, policy *RedirectPolicy) WatcherResponse {
+		t.Helper()
+		u, err := url.Parse(server.URL + path)
+		require.NoError(t, err)
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithRedirectPolicy(policy), WithReadFast())
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		require.NoError(t, ep.Task().Execute())
+		return <-respCh
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		resp := execute(t, "/hops/3", nil)
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "arrived", string(data))
+
+		md := resp.Metadata()
+		assert.Equal(t, http.StatusOK, md.StatusCode)
+		require.Len(t, md.Redirects, 3)
+		for i, hop := range md.Redirects {
+			assert.Equal(t, "/hops/"+strconv.Itoa(3-i), hop.URL.Path)
+			assert.Equal(t, http.StatusFound, hop.StatusCode)
+			assert.Equal(t, "/hops/"+strconv.Itoa(2-i), hop.Location)
+			assert.Positive(t, hop.TimeData.Latency)
+			assert.NotNil(t, hop.RemoteAddr)
+		}
+		// The connection is reused for the later hops
+		assert.NotNil(t, md.Redirects[0].TimeData.TCPConnect)
+		assert.Nil(t, md.Redirects[1].TimeData.TCPConnect)
+		assert.Nil(t, md.TimeData.TCPConnect)
+	})
+
+	t.Run("no follow", func(t *testing.T) {
+		resp := execute(t, "/hops/3", &RedirectPolicy{NoFollow: true})
+		require.NoError(t, resp.Err)
+		md := resp.Metadata()
+		assert.Equal(t, http.StatusFound, md.StatusCode)
+		assert.Equal(t, "/hops/2", md.Headers.Get("Location"))
+		assert.Empty(t, md.Redirects)
+	})
+
+	t.Run("max hops", func(t *testing.T) {
+		resp := execute(t, "/hops/3", &RedirectPolicy{MaxHops: 2})
+		var redirectErr *RedirectError
+		require.True(t, errors.As(resp.Err, &redirectErr), "expected a *RedirectError, got %v", resp.Err)
+		assert.Equal(t, "/hops/0", redirectErr.URL.Path)
+
+		require.NotNil(t, resp.Payload)
+		md := resp.Metadata()
+		assert.Equal(t, http.StatusFound, md.StatusCode)
+		assert.Len(t, md.Redirects, 2)
+	})
+
+	t.Run("same host", func(t *testing.T) {
+		resp := execute(t, "/away", &RedirectPolicy{SameHost: true})
+		var redirectErr *RedirectError
+		require.True(t, errors.As(resp.Err, &redirectErr), "expected a *RedirectError, got %v", resp.Err)
+		assert.Equal(t, http.StatusMovedPermanently, resp.Metadata().StatusCode)
+
+		resp = execute(t, "/away", nil)
+		require.NoError(t, resp.Err)
+		md := resp.Metadata()
+		assert.Equal(t, http.StatusOK, md.StatusCode)
+		require.Len(t, md.Redirects, 1)
+		assert.Equal(t, "/away", md.Redirects[0].URL.Path)
+	})
+
+	t.Run("HTTP/2", func(t *testing.T) {
+		h2Server := httptest.NewUnstartedServer(http.HandlerFunc(redirectHandler))
+		h2Server.EnableHTTP2 = true
+		h2Server.StartTLS()
+		defer h2Server.Close()
+
+		u, err := url.Parse(h2Server.URL + "/hops/2")
+		require.NoError(t, err)
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithProtocol(HTTPProtocolHTTP2), WithReadFast())
+		ep.TLS = &TLSConfig{SkipVerify: true}
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		require.NoError(t, ep.Task().Execute())
+		resp := <-respCh
+		require.NoError(t, resp.Err)
+
+		// Each hop, the final one included, has its own write traced
+		md := resp.Metadata()
+		assert.Equal(t, "HTTP/2.0", md.Protocol)
+		require.Len(t, md.Redirects, 2)
+		for _, hop := range md.Redirects {
+			assert.NotNil(t, hop.TimeData.ServerProcessing)
+		}
+		assert.NotNil(t, md.TimeData.ServerProcessing)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		u, _ := url.Parse(server.URL)
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithRedirectPolicy(&RedirectPolicy{MaxHops: -1}))
+		assert.Error(t, ep.Validate())
+	})
+}
+
+func TestWroteSignal(t *testing.T) {
+	wrote := newWroteSignal()
+	first := wrote.wait()
+	wrote.done()
+	wrote.done()
+	<-first
+
+	// A reset signal waits for the next write
+	wrote.reset()
+	next := wrote.wait()
+	select {
+	case <-next:
+		t.Fatal("reset signal closed before the next write")
+	default:
+	}
+	wrote.done()
+	<-next
+}
@@ -3,6 +3,7 @@ package wadjit
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"maps"
 	"net"
@@ -25,6 +26,9 @@ type WatcherResponse struct {
 	URL       *url.URL     // URL of the response's target
 	Err       error        // Error that occurred during the request, if nil the request was successful
 	Payload   TaskResponse // Payload stores the response data from the endpoint
+	// Values holds the values extracted from the response by name, see ValueExtractor. Nil if the
+	// task has no value extractors.
+	Values map[string]Value
 }
 
 // Data reads and returns the data from the response.
@@ -89,12 +93,71 @@ type TaskResponseMetadata struct {
 	// HTTP metadata
 	StatusCode int
 	Headers    http.Header
+	// Protocol is the protocol the response was received over, e.g. "HTTP/1.1" or "HTTP/2.0".
+	Protocol string
+	// Redirects holds the redirects followed before the response, in order. Nil if none were.
+	Redirects []RedirectHop
+	// ConnReused is true when the request was made on a previously used connection, which was
+	// idle for ConnIdleTime before it.
+	ConnReused   bool
+	ConnIdleTime time.Duration
 
 	// Size is the size of the response body, or message, in bytes.
 	Size int64
+	// TransferredSize is the number of HTTP response body bytes received so far, as sent by the
+	// server, and DecodedSize the number after decoding. Both include bytes discarded beyond the
+	// maximum body size, Truncated being true when bytes beyond it were received. Content decoded
+	// by the transport itself, see HTTPEndpoint.AcceptEncoding, is only counted decoded.
+	TransferredSize int64
+	DecodedSize     int64
+	Truncated       bool
+	// ContentEncoding is the encoding of the HTTP response body, and Decoded true if the body is
+	// read decoded.
+	ContentEncoding string
+	Decoded         bool
+	// ContentHash is the hex-encoded hash of the HTTP response body, once read to its end by a
+	// task with a ContentHashConfig. ContentChanged is true when it differs from the hash of the
+	// previous body read by the task, and ContentDiff is then the unified diff of small text
+	// bodies, see ContentHashConfig.DiffMaxSize.
+	ContentHash    string
+	ContentChanged bool
+	ContentDiff    string
 
 	// TimeData contains the timing information for the request.
 	TimeData RequestTimes
+
+	// JSONRPCResults contains the decoded JSON-RPC responses, one per element for batches. Nil
+	// when the task is not in a JSON-RPC mode.
+	JSONRPCResults []JSONRPCResult
+
+	// FanOut contains the probed address and resolution changes of a FanOutEndpoint. Nil for
+	// other tasks.
+	FanOut *FanOutMetadata
+
+	// Sequence contains the results of the steps run by a SequenceEndpoint. Nil for other tasks.
+	Sequence *SequenceMetadata
+
+	// Comparison contains the statistics of the group and the results of the members of a
+	// ComparisonEndpoint. Nil for other tasks.
+	Comparison *ComparisonMetadata
+
+	// TLS describes the TLS connection the response was received on. Nil for plaintext
+	// connections.
+	TLS *TLSInfo
+
+	// Packets contains the sent and received datagrams, loss and round-trip times of a
+	// UDPEndpoint. Nil for other tasks.
+	Packets *PacketStats
+
+	// DNS contains the response code and answer of a DNSEndpoint. Nil for other tasks.
+	DNS *DNSMetadata
+
+	// GRPC contains the gRPC status and serving status of a GRPCHealthEndpoint. Nil for other
+	// tasks.
+	GRPC *GRPCMetadata
+
+	// secrets are redacted by String, see SecretConfig.
+	secrets []string
 }
 
 func (m TaskResponseMetadata) String() string {
@@ -111,13 +174,14 @@ func (m TaskResponseMetadata) String() string {
 		}
 	}
 	buffer.WriteString("}")
-	return "TaskResponseMetadata{" +
+	str := "TaskResponseMetadata{" +
 		"StatusCode: " + strconv.Itoa(m.StatusCode) + ", " +
 		"Headers: " + buffer.String() + ", " +
 		"Size: " + strconv.Itoa(int(m.Size)) + ", " +
 		"Latency: " + m.TimeData.Latency.String() + ", " +
 		"TimeSent: " + m.TimeData.SentAt.String() + ", " +
 		"TimeReceived: " + m.TimeData.ReceivedAt.String() + "}"
+	return redactString(str, m.secrets)
 }
 
 //
@@ -135,12 +199,79 @@ type HTTPTaskResponse struct {
 	dataErr  error
 
 	timestamps requestTimestamps
+	tlsInfo    *TLSInfo
+	redirects  []RedirectHop
+
+	connReused   bool
+	connIdleTime time.Duration
+
+	jsonRPCResults []JSONRPCResult
+
+	contentEncoding string
+	decoded         bool // true if the body is read decoded
 
-	usedReader atomic.Bool // flags if we returned a Reader
+	secrets []string // redacted from the metadata, see SecretConfig
+
+	wire       *countingReadCloser // counts the bytes of resp.Body, nil if it is nil
+	body       *limitedBody        // wraps wire, decoding it if decodeBody finds a decoder
+	hashed     *hashingBody        // wraps body if hashBody was called
+	usedReader atomic.Bool         // flags if we returned a Reader
 }
 
 func NewHTTPTaskResponse(remoteAddr net.Addr, r *http.Response) *HTTPTaskResponse {
-	return &HTTPTaskResponse{remoteAddr: remoteAddr, resp: r}
+	h := &HTTPTaskResponse{
+		remoteAddr:      remoteAddr,
+		resp:            r,
+		contentEncoding: r.Header.Get("Content-Encoding"),
+	}
+	// The transport removes the header of gzip content it requested and decodes
+	if r.Uncompressed {
+		h.contentEncoding = EncodingGzip
+		h.decoded = true
+	}
+	if r.Body != nil {
+		h.wire = &countingReadCloser{rc: r.Body}
+		h.body = &limitedBody{rc: h.wire}
+	}
+	return h
+}
+
+// decodeBody makes the response body read decoded, if its content encoding has a decoder among
+// the decoders given or the built-in ones. Must be called before the body is read.
+func (h *HTTPTaskResponse) decodeBody(decoders map[string]ContentDecoder) {
+	if h.body == nil || h.decoded {
+		return
+	}
+	if decoder := contentDecoder(h.contentEncoding, decoders); decoder != nil {
+		h.body.rc = &decodingReadCloser{rc: h.wire, newReader: decoder}
+		h.decoded = true
+	}
+}
+
+// limitBody applies a body size limit to the reading of the response body, see
+// HTTPEndpoint.MaxBodySize. Must be called before the body is read.
+func (h *HTTPTaskResponse) limitBody(limit int64, action BodyLimitAction) {
+	if h.body != nil {
+		h.body.limit = limit
+		h.body.action = action
+	}
+}
+
+// hashBody makes the response body hashed as it is read, for the hash to be compared to that of
+// the previous body in state, see HTTPEndpoint.ContentHash. Must be called before the body is
+// read, and after decodeBody and limitBody.
+func (h *HTTPTaskResponse) hashBody(state *contentState) {
+	if h.body != nil {
+		h.hashed = newHashingBody(h.body, state)
+	}
+}
+
+// bodyReader returns the reader of the response body, hashing it if hashBody was called.
+func (h *HTTPTaskResponse) bodyReader() io.ReadCloser {
+	if h.hashed != nil {
+		return h.hashed
+	}
+	return h.body
 }
 
 // readBody reads the HTTP response body into memory exactly once and then closes the body.
@@ -149,9 +280,10 @@ func (h *HTTPTaskResponse) readBody() {
 		h.dataErr = errors.New("http.Response.Body is nil")
 		return
 	}
-	defer h.resp.Body.Close()
+	body := h.bodyReader()
+	defer body.Close()
 
-	bodyBytes, err := io.ReadAll(h.resp.Body)
+	bodyBytes, err := io.ReadAll(body)
 	if err != nil {
 		h.dataErr = err
 		return
@@ -161,6 +293,20 @@ func (h *HTTPTaskResponse) readBody() {
 	h.timestamps.dataDone = time.Now()
 }
 
+// decodeJSONRPC decodes the JSON-RPC response, or batch response, read by readBody. Returns the
+// error object of a single response as an error, or an error if the body could not be decoded.
+func (h *HTTPTaskResponse) decodeJSONRPC() error {
+	if h.dataErr != nil {
+		return h.dataErr
+	}
+	results, err := decodeJSONRPCResults(h.data)
+	if err != nil {
+		return fmt.Errorf("failed to decode JSON-RPC response: %w", err)
+	}
+	h.jsonRPCResults = results
+	return jsonRPCResultsErr(h.data, results)
+}
+
 // Close closes the HTTP response body.
 func (h *HTTPTaskResponse) Close() error {
 	if h.resp.Body == nil {
@@ -211,7 +357,7 @@ func (h *HTTPTaskResponse) Reader() (io.ReadCloser, error) {
 
 	// Return custom readcloser that records when the stream is finished
 	return &timedReadCloser{
-		rc: h.resp.Body,
+		rc: h.bodyReader(),
 		doneFn: func() {
 			// Only set the timestamp if it hasn't been set yet
 			if h.timestamps.dataDone.IsZero() {
@@ -231,12 +377,106 @@ func (h *HTTPTaskResponse) Metadata() TaskResponseMetadata {
 		RemoteAddr: h.remoteAddr,
 		StatusCode: h.resp.StatusCode,
 		Headers:    http.Header{},
+		Protocol:   h.resp.Proto,
+		Redirects:  h.redirects,
 		Size:       h.resp.ContentLength,
 		TimeData:   TimeDataFromTimestamps(h.timestamps),
+
+		ConnReused:   h.connReused,
+		ConnIdleTime: h.connIdleTime,
+
+		JSONRPCResults: h.jsonRPCResults,
+		TLS:            h.tlsInfo,
 	}
 	maps.Copy(md.Headers, h.resp.Header)
+	md.ContentEncoding = h.contentEncoding
+	md.Decoded = h.decoded
+	if h.body != nil {
+		md.TransferredSize = h.wire.n.Load()
+		md.DecodedSize = h.body.transferred.Load()
+		md.Truncated = h.body.truncated.Load()
+	}
+	if h.hashed != nil {
+		if result, ok := h.hashed.contentResult(); ok {
+			md.ContentHash = result.hash
+			md.ContentChanged = result.changed
+			md.ContentDiff = result.diff
+		}
+	}
+
+	return redactMetadata(md, h.secrets)
+}
+
+// BodyTooLargeError is the error of reading a response body exceeding the maximum body size.
+type BodyTooLargeError struct {
+	Limit int64
+}
 
-	return md
+// Error returns a string representation of the exceeded limit.
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds %d bytes", e.Limit)
+}
+
+// limitedBody wraps an HTTP response body, counting the bytes received and applying the body size
+// limit, if any. Once the limit is reached, the body is read according to the limit action.
+type limitedBody struct {
+	rc     io.ReadCloser
+	limit  int64 // 0 for no limit
+	action BodyLimitAction
+
+	read   int64 // bytes returned, up to the limit
+	limErr error // result of reads once the limit is reached
+
+	transferred atomic.Int64 // bytes received, including those discarded
+	truncated   atomic.Bool
+}
+
+// Read reads from the underlying body, up to the limit.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limit <= 0 || b.read < b.limit {
+		if b.limit > 0 && int64(len(p)) > b.limit-b.read {
+			p = p[:b.limit-b.read]
+		}
+		n, err := b.rc.Read(p)
+		b.read += int64(n)
+		b.transferred.Add(int64(n))
+		return n, err
+	}
+	if b.limErr != nil {
+		return 0, b.limErr
+	}
+
+	// The limit is reached, check for data beyond it
+	if b.action == BodyLimitTruncate {
+		n, err := io.Copy(io.Discard, b.rc)
+		b.transferred.Add(n)
+		if n > 0 {
+			b.truncated.Store(true)
+		}
+		if err != nil {
+			return 0, err
+		}
+		b.limErr = io.EOF
+		return 0, b.limErr
+	}
+	var probe [1]byte
+	n, err := io.ReadFull(b.rc, probe[:])
+	b.transferred.Add(int64(n))
+	if n == 0 {
+		b.limErr = err
+		return 0, err
+	}
+	b.truncated.Store(true)
+	b.limErr = io.EOF
+	if b.action == BodyLimitError {
+		b.limErr = &BodyTooLargeError{Limit: b.limit}
+	}
+	return 0, b.limErr
+}
+
+// Close closes the underlying body.
+func (b *limitedBody) Close() error {
+	return b.rc.Close()
 }
 
 // dataDone checks if the sync.Once for Data() has run.
@@ -253,6 +493,9 @@ type WSTaskResponse struct {
 	remoteAddr net.Addr
 	data       []byte
 	timestamps requestTimestamps
+	tlsInfo    *TLSInfo
+
+	jsonRPCResults []JSONRPCResult
 }
 
 // NewWSTaskResponse can store an incoming WS message as a byte slice.
@@ -277,8 +520,10 @@ func (w *WSTaskResponse) Reader() (io.ReadCloser, error) {
 // Metadata returns metadata connected to the response.
 func (w *WSTaskResponse) Metadata() TaskResponseMetadata {
 	return TaskResponseMetadata{
-		RemoteAddr: w.remoteAddr,
-		Size:       int64(len(w.data)),
-		TimeData:   TimeDataFromTimestamps(w.timestamps),
+		RemoteAddr:     w.remoteAddr,
+		Size:           int64(len(w.data)),
+		TimeData:       TimeDataFromTimestamps(w.timestamps),
+		JSONRPCResults: w.jsonRPCResults,
+		TLS:            w.tlsInfo,
 	}
 }
@@ -146,6 +146,58 @@ func TestHTTPTaskResponse_Metadata(t *testing.T) {
 	require.Equal(t, "Value123", md.Headers.Get("X-Custom-Header"))
 }
 
+func TestHTTPTaskResponse_BodyLimit(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 100)
+	newResponse := func(limit int64, action BodyLimitAction) *HTTPTaskResponse {
+		resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body))}
+		taskResp := NewHTTPTaskResponse(nil, resp)
+		taskResp.limitBody(limit, action)
+		return taskResp
+	}
+
+	t.Run("within limit", func(t *testing.T) {
+		taskResp := newResponse(100, BodyLimitError)
+		data, err := taskResp.Data()
+		require.NoError(t, err)
+		require.Equal(t, body, data)
+		md := taskResp.Metadata()
+		require.Equal(t, int64(100), md.TransferredSize)
+		require.False(t, md.Truncated)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		taskResp := newResponse(10, BodyLimitError)
+		_, err := taskResp.Data()
+		var tooLarge *BodyTooLargeError
+		require.ErrorAs(t, err, &tooLarge)
+		require.Equal(t, int64(10), tooLarge.Limit)
+		require.True(t, taskResp.Metadata().Truncated)
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		taskResp := newResponse(10, BodyLimitTruncate)
+		data, err := taskResp.Data()
+		require.NoError(t, err)
+		require.Equal(t, body[:10], data)
+		md := taskResp.Metadata()
+		require.Equal(t, int64(100), md.TransferredSize)
+		require.True(t, md.Truncated)
+	})
+
+	t.Run("head", func(t *testing.T) {
+		taskResp := newResponse(10, BodyLimitHead)
+		r, err := taskResp.Reader()
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, body[:10], data)
+		md := taskResp.Metadata()
+		require.Equal(t, int64(11), md.TransferredSize) // The head, and the byte found beyond it
+		require.True(t, md.Truncated)
+	})
+}
+
 func TestWSTaskResponse_DataAndReader(t *testing.T) {
 	remoteAddr := net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
 	wsData := []byte("hello from websocket")
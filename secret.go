@@ -0,0 +1,327 @@
+package wadjit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretResolver returns the secret named by a secret reference, e.g. the value of the environment
+// variable of the name.
+type SecretResolver func(name string) (string, error)
+
+// SecretConfig enables secret references in the URL, headers and payload of a task. A reference
+// ${scheme:name} is replaced by the secret the resolver of the scheme returns for the name, e.g.
+// ${env:API_KEY} by the value of the environment variable API_KEY, or ${file:/run/secrets/key} by
+// the content of the file, without trailing newlines. In URLs, references are resolved in the user
+// info, path and query. Resolved secrets are redacted from the URLs, errors and metadata of the
+// task's responses.
+type SecretConfig struct {
+	// Resolvers holds resolvers by scheme, in addition to, or overriding, the built-in env and
+	// file resolvers.
+	Resolvers map[string]SecretResolver
+	// Refresh is the interval at which the references are resolved again, for rotated secrets to
+	// be picked up. When zero, they are resolved by Initialize and RotateSecrets only.
+	Refresh time.Duration
+}
+
+// redacted replaces secrets in redacted data.
+const redacted = "[REDACTED]"
+
+// secretRefPattern matches a secret reference, capturing its scheme and name.
+var secretRefPattern = regexp.MustCompile(`\$\{([a-z][a-z0-9]*):([^}]+)\}`)
+
+// builtinSecretResolvers are the resolvers of the env and file schemes.
+var builtinSecretResolvers = map[string]SecretResolver{
+	"env": func(name string) (string, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		return value, nil
+	},
+	"file": func(name string) (string, error) {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	},
+}
+
+// resolver returns the resolver of a scheme.
+func (c *SecretConfig) resolver(scheme string) (SecretResolver, bool) {
+	if resolver, ok := c.Resolvers[scheme]; ok {
+		return resolver, true
+	}
+	resolver, ok := builtinSecretResolvers[scheme]
+	return resolver, ok
+}
+
+// validate checks that the SecretConfig is ready for use.
+func (c *SecretConfig) validate() error {
+	if c.Refresh < 0 {
+		return errors.New("secret Refresh is negative")
+	}
+	for scheme, resolver := range c.Resolvers {
+		if resolver == nil {
+			return fmt.Errorf("secret resolver of scheme %q is nil", scheme)
+		}
+	}
+	return nil
+}
+
+// secretState holds the URL, header and payload of a task with their secret references, along
+// with their latest resolution. A nil secretState returns the task's values unresolved.
+type secretState struct {
+	config  *SecretConfig
+	url     *url.URL
+	header  http.Header
+	payload []byte
+
+	mu         sync.Mutex
+	resolved   secretValues
+	resolvedAt time.Time
+}
+
+// secretValues are the URL, header and payload of a task with their secret references resolved,
+// along with the secrets resolved, to redact.
+type secretValues struct {
+	url     *url.URL
+	header  http.Header
+	payload []byte
+	secrets []string
+}
+
+// newSecretState resolves the secret references of the URL, header and payload of a task.
+func newSecretState(config *SecretConfig, u *url.URL, header http.Header, payload []byte) (*secretState, error) {
+	s := &secretState{config: config, url: u, header: header, payload: payload}
+	if err := s.resolve(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// resolve resolves the secret references again, keeping the previous resolution on error.
+func (s *secretState) resolve() error {
+	var resolved secretValues
+	var errs error
+	seen := make(map[string]bool)
+	// replace resolves the references in v, escaping the secrets with escape if non-nil
+	replace := func(v string, escape func(string) string) string {
+		return secretRefPattern.ReplaceAllStringFunc(v, func(ref string) string {
+			match := secretRefPattern.FindStringSubmatch(ref)
+			resolver, ok := s.config.resolver(match[1])
+			if !ok {
+				// Not a reference to a secret, leave as-is
+				return ref
+			}
+			secret, err := resolver(match[2])
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("failed to resolve secret %s: %w", ref, err))
+				return ref
+			}
+			if secret != "" && !seen[secret] {
+				seen[secret] = true
+				resolved.secrets = append(resolved.secrets, secret)
+			}
+			if escape != nil {
+				return escape(secret)
+			}
+			return secret
+		})
+	}
+
+	urlClone := *s.url
+	if s.url.User != nil {
+		if password, ok := s.url.User.Password(); ok {
+			urlClone.User = url.UserPassword(replace(s.url.User.Username(), nil), replace(password, nil))
+		} else {
+			urlClone.User = url.User(replace(s.url.User.Username(), nil))
+		}
+	}
+	urlClone.Path = replace(s.url.Path, nil)
+	urlClone.RawPath = ""
+	urlClone.RawQuery = replace(s.url.RawQuery, url.QueryEscape)
+	resolved.url = &urlClone
+
+	resolved.header = make(http.Header, len(s.header))
+	for key, values := range s.header {
+		for _, value := range values {
+			resolved.header.Add(key, replace(value, nil))
+		}
+	}
+	resolved.payload = []byte(replace(string(s.payload), nil))
+	if errs != nil {
+		return errs
+	}
+
+	// Also redact the escaped forms secrets take in URLs
+	for _, secret := range resolved.secrets {
+		for _, escaped := range []string{url.PathEscape(secret), url.QueryEscape(secret)} {
+			if !seen[escaped] {
+				seen[escaped] = true
+				resolved.secrets = append(resolved.secrets, escaped)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolved = resolved
+	s.resolvedAt = time.Now()
+	return nil
+}
+
+// values returns the resolved URL, header and payload, resolving the references again first if
+// due, or the given ones if s is nil. The returned URL and header are copies, safe to modify. On a
+// failure to resolve again, the previous resolution is returned along with the error.
+func (s *secretState) values(u *url.URL, header http.Header, payload []byte) (secretValues, error) {
+	if s == nil {
+		urlClone := *u
+		return secretValues{url: &urlClone, header: header.Clone(), payload: payload}, nil
+	}
+
+	s.mu.Lock()
+	due := s.config.Refresh > 0 && time.Since(s.resolvedAt) >= s.config.Refresh
+	s.mu.Unlock()
+	var err error
+	if due {
+		err = s.resolve()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urlClone := *s.resolved.url
+	return secretValues{
+		url:     &urlClone,
+		header:  s.resolved.header.Clone(),
+		payload: s.resolved.payload,
+		secrets: s.resolved.secrets,
+	}, err
+}
+
+// current returns the secrets of the latest resolution, nil if s is nil.
+func (s *secretState) current() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resolved.secrets
+}
+
+// redactString replaces the secrets in s.
+func redactString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// redactURL returns a copy of u with the secrets replaced in its user info, path and query.
+func redactURL(u *url.URL, secrets []string) *url.URL {
+	if u == nil || len(secrets) == 0 {
+		return u
+	}
+	urlClone := *u
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			urlClone.User = url.UserPassword(redactString(u.User.Username(), secrets), redactString(password, secrets))
+		} else {
+			urlClone.User = url.User(redactString(u.User.Username(), secrets))
+		}
+	}
+	urlClone.Path = redactString(u.Path, secrets)
+	urlClone.RawPath = ""
+	urlClone.RawQuery = redactString(u.RawQuery, secrets)
+	return &urlClone
+}
+
+// redactHeader returns a copy of h with the secrets replaced in its values.
+func redactHeader(h http.Header, secrets []string) http.Header {
+	if h == nil || len(secrets) == 0 {
+		return h
+	}
+	redactedHeader := make(http.Header, len(h))
+	for key, values := range h {
+		redactedValues := make([]string, len(values))
+		for i, value := range values {
+			redactedValues[i] = redactString(value, secrets)
+		}
+		redactedHeader[key] = redactedValues
+	}
+	return redactedHeader
+}
+
+// redactError returns err with the secrets replaced in its message, nil if err is nil.
+func redactError(err error, secrets []string) error {
+	if err == nil || len(secrets) == 0 {
+		return err
+	}
+	return &redactedError{err: err, secrets: secrets}
+}
+
+// redactedError is an error with secrets replaced in its message, wrapping the original error.
+type redactedError struct {
+	err     error
+	secrets []string
+}
+
+// Error returns the message of the wrapped error with the secrets replaced.
+func (e *redactedError) Error() string {
+	return redactString(e.err.Error(), e.secrets)
+}
+
+// Unwrap returns the wrapped error, for errors.Is and errors.As to match it. A *url.Error, as
+// returned by http.Client, is replaced by a copy with its URL and error redacted. Errors wrapped
+// deeper are returned as-is, secrets included.
+func (e *redactedError) Unwrap() error {
+	if urlErr, ok := e.err.(*url.Error); ok {
+		return &url.Error{
+			Op:  urlErr.Op,
+			URL: redactString(urlErr.URL, e.secrets),
+			Err: redactError(urlErr.Err, e.secrets),
+		}
+	}
+	return e.err
+}
+
+// redactValues replaces the secrets in the values extracted from a response. A value holding a
+// secret is kept as a redacted string, for its parsed number not to be exported as a gauge.
+func redactValues(values map[string]Value, secrets []string) map[string]Value {
+	if len(secrets) == 0 {
+		return values
+	}
+	for name, value := range values {
+		if raw := redactString(value.Raw, secrets); raw != value.Raw {
+			values[name] = Value{Kind: ValueKindString, Raw: raw}
+		}
+	}
+	return values
+}
+
+// redactMetadata replaces the secrets in the headers, content diff and redirects of md, and keeps them for
+// TaskResponseMetadata.String to redact.
+func redactMetadata(md TaskResponseMetadata, secrets []string) TaskResponseMetadata {
+	if len(secrets) == 0 {
+		return md
+	}
+	md.Headers = redactHeader(md.Headers, secrets)
+	md.ContentDiff = redactString(md.ContentDiff, secrets)
+	if md.Redirects != nil {
+		md.Redirects = append([]RedirectHop(nil), md.Redirects...)
+		for i := range md.Redirects {
+			md.Redirects[i].URL = redactURL(md.Redirects[i].URL, secrets)
+			md.Redirects[i].Location = redactString(md.Redirects[i].Location, secrets)
+		}
+	}
+	md.secrets = secrets
+	return md
+}
@@ -1,8 +1,15 @@
 package wadjit
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
 	"net/netip"
 	"net/url"
+	"time"
 
 	"github.com/jkbrsn/go-taskman"
 )
@@ -34,6 +41,204 @@ type TransportControl struct {
 	SkipTLSVerify bool
 }
 
+// dialContext returns a dial function that connects to the literal address, regardless of the
+// address asked for, bypassing name resolution.
+func (tc *TransportControl) dialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		// TODO: move timeout to configuration
+		d := &net.Dialer{Timeout: 5 * time.Second}
+		return d.DialContext(ctx, "tcp", tc.AddrPort.String())
+	}
+}
+
+// dialTraced connects to the TCP address, through the TransportControl if non-nil, and stores the
+// start, DNS lookup and TCP connect timestamps in times.
+func dialTraced(
+	ctx context.Context,
+	tc *TransportControl,
+	addr string,
+	times *requestTimestamps,
+) (net.Conn, error) {
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { times.dnsStart = time.Now() },
+		DNSDone:      func(httptrace.DNSDoneInfo) { times.dnsDone = time.Now() },
+		ConnectStart: func(_, _ string) { times.connStart = time.Now() },
+		ConnectDone:  func(_, _ string, _ error) { times.connDone = time.Now() },
+	})
+
+	// TODO: move timeout to configuration
+	dialContext := (&net.Dialer{Timeout: 5 * time.Second}).DialContext
+	if tc != nil {
+		dialContext = tc.dialContext()
+	}
+
+	times.start = time.Now()
+	return dialContext(ctx, "tcp", addr)
+}
+
+// handshakeTraced performs a TLS handshake as client on the connection, and stores the handshake
+// timestamps in times.
+func handshakeTraced(
+	ctx context.Context,
+	rawConn net.Conn,
+	tlsConfig *tls.Config,
+	times *requestTimestamps,
+) (*tls.Conn, error) {
+	times.tlsStart = time.Now()
+	conn := tls.Client(rawConn, tlsConfig)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	times.tlsDone = time.Now()
+	return conn, nil
+}
+
+// tlsConfig returns the TLS configuration to use with the given server name for SNI, or nil if
+// TLS is not enabled.
+func (tc *TransportControl) tlsConfig(serverName string) *tls.Config {
+	if !tc.TLSEnabled {
+		return nil
+	}
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: tc.SkipTLSVerify,
+	}
+}
+
+// validate checks that the TransportControl is ready for use.
+func (tc *TransportControl) validate() error {
+	if tc.AddrPort == (netip.AddrPort{}) {
+		return errors.New("TransportControl.AddrPort is empty")
+	}
+	return nil
+}
+
+// awaitTimeout is the deadline of the executions of executeAwait.
+// TODO: move timeout to configuration
+var awaitTimeout = 5 * time.Second
+
+// awaitChan is the response channel of a task run by executeAwait. It tracks an execution that
+// timed out until the execution returns, for its late responses not to be taken for those of the
+// next execution.
+type awaitChan struct {
+	responses chan WatcherResponse
+	// running receives the result of an execution that timed out, nil when there is none.
+	running chan error
+}
+
+// newAwaitChan creates an awaitChan, whose responses channel the task is to be initialized with.
+func newAwaitChan() *awaitChan {
+	return &awaitChan{responses: make(chan WatcherResponse, 1)}
+}
+
+// executeAwait executes a task initialized with the responses channel of ac, and waits for its
+// response, the deadline applying to the execution as a whole. A new execution is not started
+// until a previous one that timed out has returned, and the responses sent by that execution are
+// discarded, as are further responses sent before an execution returns. Errors are returned as
+// error responses with the given IDs.
+// Note: executions with the same awaitChan must not run concurrently.
+func executeAwait(task WatcherTask, ac *awaitChan, taskID, watcherID string) WatcherResponse {
+	respChan := ac.responses
+	discardResponses(respChan)
+	if ac.running != nil {
+		select {
+		case <-ac.running:
+			ac.running = nil
+			// Responses sent before the execution returned
+			discardResponses(respChan)
+		default:
+			err := errors.New("timed out waiting for the previous execution to return")
+			return errorResponse(err, taskID, watcherID, taskURL(task))
+		}
+	}
+
+	// Execute apart, for a hung execution not to block the caller past the deadline
+	done := make(chan error, 1)
+	go func() { done <- task.Task().Execute() }()
+
+	timer := time.NewTimer(awaitTimeout)
+	defer timer.Stop()
+	var resp *WatcherResponse
+	for {
+		select {
+		case r := <-respChan:
+			if resp != nil {
+				if r.Payload != nil {
+					r.Payload.Close()
+				}
+				continue
+			}
+			resp = &r
+		case err := <-done:
+			done = nil
+			if resp == nil {
+				// Tasks failing to execute send an error response, unless failing before the request
+				select {
+				case r := <-respChan:
+					resp = &r
+				default:
+					if err != nil {
+						return errorResponse(err, taskID, watcherID, taskURL(task))
+					}
+				}
+			}
+			if resp != nil {
+				discardResponses(respChan)
+				return *resp
+			}
+			// The execution returned before the response was sent, keep waiting for it
+		case <-timer.C:
+			if done != nil {
+				// Still running, checked by the next execution
+				ac.running = done
+			}
+			if resp != nil {
+				return *resp
+			}
+			return errorResponse(errors.New("timed out waiting for response"), taskID, watcherID, taskURL(task))
+		}
+	}
+}
+
+// discardResponses closes and discards the responses on respChan, without waiting for any.
+func discardResponses(respChan chan WatcherResponse) {
+	for {
+		select {
+		case resp := <-respChan:
+			if resp.Payload != nil {
+				resp.Payload.Close()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// validateSingleResponse checks that a task sends a single response per execution, and none
+// between executions, for it to be run by a task awaiting its response.
+func validateSingleResponse(task WatcherTask) error {
+	switch t := task.(type) {
+	case *FanOutEndpoint:
+		return errors.New("FanOutEndpoint sends one response per address")
+	case *WSEndpoint:
+		if t.Mode == PersistentJSONRPC || t.Mode == PersistentCorrelated {
+			return errors.New("WSEndpoint in a persistent mode sends responses apart from executions")
+		}
+	}
+	return nil
+}
+
+// taskURL returns the URL of a task, if the task is of a type with a URL.
+func taskURL(task WatcherTask) *url.URL {
+	switch t := task.(type) {
+	case *HTTPEndpoint:
+		return t.URL
+	case *WSEndpoint:
+		return t.URL
+	}
+	return nil
+}
+
 // errorResponse is a helper to create a WatcherResponse with an error.
 func errorResponse(err error, taskID, watcherID string, url *url.URL) WatcherResponse {
 	return WatcherResponse{
@@ -0,0 +1,354 @@
+package wadjit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// ComparisonEndpoint is a single logical task running the tasks of a group of members
+// concurrently in the same cycle, e.g. the same request to each of a set of blockchain nodes, and
+// comparing a number extracted from their responses, e.g. the block height. One response is sent
+// per execution, with the maximum, minimum and median of the group and the deviation of each
+// member in the response metadata. Members deviating beyond the tolerance, or failing, are flagged
+// and named in the response's error. The statistics are also set as the response's values, for
+// them to be exported as gauges. Implements the WatcherTask interface and is meant for use in a
+// Watcher.
+type ComparisonEndpoint struct {
+	ID      string
+	Members []ComparisonMember
+	// Value extracts the compared value from the responses of the members, parsed as a number,
+	// see ValueKindNumber.
+	Value Extractor
+	// Reference is the statistic of the group deviations are measured from.
+	Reference ComparisonReference
+	// Tolerance is the largest absolute deviation of a member from the reference not flagged.
+	Tolerance float64
+
+	mu          sync.Mutex
+	memberChans []*awaitChan
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// ComparisonMember is a member of the group of a ComparisonEndpoint.
+type ComparisonMember struct {
+	// Name identifies the member in the results. Defaults to the index of the member.
+	Name string
+	// Task makes the request of the member, and must send a single response per execution, e.g.
+	// not a FanOutEndpoint or a WSEndpoint in a persistent mode.
+	Task WatcherTask
+}
+
+// ComparisonReference is an enum for the statistic of the group a ComparisonEndpoint measures
+// the deviations of its members from.
+type ComparisonReference int
+
+const (
+	// ComparisonMedian measures deviations from the median, flagging members behind and ahead of
+	// the majority.
+	ComparisonMedian ComparisonReference = iota
+	// ComparisonMax measures deviations from the maximum, e.g. the lag of a node behind the
+	// highest block of the group.
+	ComparisonMax
+	// ComparisonMin measures deviations from the minimum.
+	ComparisonMin
+)
+
+// String returns the name of the reference.
+func (r ComparisonReference) String() string {
+	switch r {
+	case ComparisonMedian:
+		return "median"
+	case ComparisonMax:
+		return "max"
+	case ComparisonMin:
+		return "min"
+	default:
+		return "ComparisonReference(" + strconv.Itoa(int(r)) + ")"
+	}
+}
+
+// ComparisonMetadata is the metadata added to responses of a ComparisonEndpoint. The statistics
+// are of the members with a value, and zero if none has.
+type ComparisonMetadata struct {
+	Max    float64
+	Min    float64
+	Median float64
+	// Members holds the results of the members, in order.
+	Members []ComparisonResult
+	// Flagged is the number of members flagged.
+	Flagged int
+}
+
+// ComparisonResult is the result of a member of a ComparisonEndpoint.
+type ComparisonResult struct {
+	Name string
+	// Value is the value extracted from the member's response.
+	Value float64
+	// Deviation is the value minus the reference statistic of the group.
+	Deviation float64
+	// Flagged is true when the deviation is beyond the tolerance, or the member failed.
+	Flagged bool
+	// Err is the error of the member's response, or of the extraction from it.
+	Err error
+	// Metadata is the metadata of the member's response.
+	Metadata TaskResponseMetadata
+}
+
+// Close closes the tasks of all members.
+func (e *ComparisonEndpoint) Close() error {
+	var errs error
+	for i := range e.Members {
+		if err := e.Members[i].Task.Close(); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// Initialize sets up the ComparisonEndpoint to be able to send on its responses, initializing
+// the tasks of all members.
+func (e *ComparisonEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+	e.memberChans = make([]*awaitChan, len(e.Members))
+	for i := range e.Members {
+		e.memberChans[i] = newAwaitChan()
+		if err := e.Members[i].Task.Initialize(watcherID, e.memberChans[i].responses); err != nil {
+			return fmt.Errorf("failed to initialize member %s: %w", e.Members[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Task returns a taskman.Task that runs the tasks of all members and compares their values.
+func (e *ComparisonEndpoint) Task() taskman.Task {
+	return &comparisonRequest{endpoint: e}
+}
+
+// Validate checks that the ComparisonEndpoint is ready to be initialized.
+func (e *ComparisonEndpoint) Validate() error {
+	if len(e.Members) < 2 {
+		return errors.New("Members must hold at least two members")
+	}
+	if e.Value == nil {
+		return errors.New("Value is nil")
+	}
+	if e.Reference < ComparisonMedian || e.Reference > ComparisonMin {
+		return fmt.Errorf("unsupported comparison reference %s", e.Reference)
+	}
+	if e.Tolerance < 0 || math.IsNaN(e.Tolerance) {
+		return errors.New("Tolerance is negative or NaN")
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	for i := range e.Members {
+		member := &e.Members[i]
+		if member.Name == "" {
+			member.Name = strconv.Itoa(i)
+		}
+		if member.Task == nil {
+			return fmt.Errorf("Task of member %s is nil", member.Name)
+		}
+		if err := validateSingleResponse(member.Task); err != nil {
+			return fmt.Errorf("invalid member %s: %w", member.Name, err)
+		}
+		if err := member.Task.Validate(); err != nil {
+			return fmt.Errorf("invalid member %s: %w", member.Name, err)
+		}
+	}
+	return nil
+}
+
+// runMember executes the task of a member and extracts the value from its response.
+// Note: the caller must hold the lock.
+func (e *ComparisonEndpoint) runMember(i int) ComparisonResult {
+	member := e.Members[i]
+	resp := executeAwait(member.Task, e.memberChans[i], e.ID, e.watcherID)
+	result := ComparisonResult{
+		Name:     member.Name,
+		Err:      resp.Err,
+		Metadata: resp.Metadata(),
+	}
+	if resp.Payload != nil {
+		defer resp.Payload.Close()
+	}
+	if result.Err == nil && resp.Payload == nil {
+		result.Err = errors.New("no payload")
+	}
+	if result.Err != nil {
+		return result
+	}
+
+	raw, err := e.Value(resp.Payload)
+	if err == nil {
+		var value Value
+		value, err = parseValue(raw, ValueKindNumber)
+		result.Value = value.Number
+	}
+	if err != nil {
+		result.Err = fmt.Errorf("failed to extract value: %w", err)
+	}
+	return result
+}
+
+// comparisonRequest is an implementation of taskman.Task that runs the tasks of the members of a
+// ComparisonEndpoint.
+type comparisonRequest struct {
+	endpoint *ComparisonEndpoint
+}
+
+// Execute runs the tasks of all members concurrently, and sends the comparison of their values.
+func (r *comparisonRequest) Execute() error {
+	e := r.endpoint
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results := make([]ComparisonResult, len(e.Members))
+	var wg sync.WaitGroup
+	for i := range e.Members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = e.runMember(i)
+		}()
+	}
+	wg.Wait()
+
+	metadata := compareResults(results, e.Reference, e.Tolerance)
+	var respErr error
+	var flagged []string
+	for _, result := range metadata.Members {
+		if result.Flagged {
+			flagged = append(flagged, result.Name)
+		}
+	}
+	if len(flagged) > 0 {
+		respErr = fmt.Errorf("members beyond tolerance or failing: %s", strings.Join(flagged, ", "))
+	}
+
+	// The statistics are only set as values when at least one member has a value
+	var values map[string]Value
+	if slices.ContainsFunc(metadata.Members, func(m ComparisonResult) bool { return m.Err == nil }) {
+		values = map[string]Value{
+			"max":    numberValue(metadata.Max),
+			"min":    numberValue(metadata.Min),
+			"median": numberValue(metadata.Median),
+		}
+	}
+
+	e.respChan <- WatcherResponse{
+		TaskID:    e.ID,
+		WatcherID: e.watcherID,
+		Err:       respErr,
+		Payload:   &comparisonTaskResponse{comparison: metadata},
+		Values:    values,
+	}
+	return nil
+}
+
+// compareResults computes the statistics of the values of the members that have one, and the
+// deviation of each from the reference, flagging members beyond the tolerance or failed.
+func compareResults(results []ComparisonResult, reference ComparisonReference, tolerance float64) ComparisonMetadata {
+	metadata := ComparisonMetadata{Members: results}
+	var values []float64
+	for _, result := range results {
+		if result.Err == nil {
+			values = append(values, result.Value)
+		}
+	}
+	if len(values) > 0 {
+		slices.Sort(values)
+		metadata.Min = values[0]
+		metadata.Max = values[len(values)-1]
+		if mid := len(values) / 2; len(values)%2 == 1 {
+			metadata.Median = values[mid]
+		} else {
+			metadata.Median = (values[mid-1] + values[mid]) / 2
+		}
+	}
+
+	ref := metadata.Median
+	switch reference {
+	case ComparisonMax:
+		ref = metadata.Max
+	case ComparisonMin:
+		ref = metadata.Min
+	}
+	for i := range metadata.Members {
+		result := &metadata.Members[i]
+		if result.Err == nil {
+			result.Deviation = result.Value - ref
+		}
+		result.Flagged = result.Err != nil || math.Abs(result.Deviation) > tolerance
+		if result.Flagged {
+			metadata.Flagged++
+		}
+	}
+	return metadata
+}
+
+// numberValue returns a Value of the number n.
+func numberValue(n float64) Value {
+	return Value{Kind: ValueKindNumber, Raw: strconv.FormatFloat(n, 'f', -1, 64), Number: n}
+}
+
+// comparisonTaskResponse is the TaskResponse of a ComparisonEndpoint, holding the comparison
+// metadata only.
+type comparisonTaskResponse struct {
+	comparison ComparisonMetadata
+}
+
+// Close is a no-op, the responses of the members being closed once compared.
+func (c *comparisonTaskResponse) Close() error {
+	return nil
+}
+
+// Data returns an error, a comparison having no payload.
+func (c *comparisonTaskResponse) Data() ([]byte, error) {
+	return nil, errors.New("no payload")
+}
+
+// Reader returns an error, a comparison having no payload.
+func (c *comparisonTaskResponse) Reader() (io.ReadCloser, error) {
+	return nil, errors.New("no payload")
+}
+
+// Metadata returns metadata with the comparison metadata set.
+func (c *comparisonTaskResponse) Metadata() TaskResponseMetadata {
+	comparison := c.comparison
+	comparison.Members = slices.Clone(c.comparison.Members)
+	return TaskResponseMetadata{Comparison: &comparison}
+}
+
+// NewComparisonEndpoint creates a new ComparisonEndpoint with the given ID, value extractor,
+// tolerance and members, measuring deviations from the median.
+func NewComparisonEndpoint(
+	id string,
+	value Extractor,
+	tolerance float64,
+	members ...ComparisonMember,
+) *ComparisonEndpoint {
+	return &ComparisonEndpoint{
+		ID:        id,
+		Members:   members,
+		Value:     value,
+		Tolerance: tolerance,
+	}
+}
@@ -0,0 +1,275 @@
+package wadjit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComparisonEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &ComparisonEndpoint{}
+}
+
+func TestComparisonEndpointValidate(t *testing.T) {
+	value := ExtractJSON("result")
+	endpoint := NewComparisonEndpoint("", value, 0, ComparisonMember{Task: &MockWatcherTask{}})
+	assert.Error(t, endpoint.Validate(), "expected error for a single member")
+
+	endpoint = NewComparisonEndpoint("", nil, 0, ComparisonMember{Task: &MockWatcherTask{}}, ComparisonMember{Task: &MockWatcherTask{}})
+	assert.Error(t, endpoint.Validate(), "expected error for nil Value")
+
+	endpoint = NewComparisonEndpoint("", value, -1, ComparisonMember{Task: &MockWatcherTask{}}, ComparisonMember{Task: &MockWatcherTask{}})
+	assert.Error(t, endpoint.Validate(), "expected error for negative Tolerance")
+
+	endpoint = NewComparisonEndpoint("", value, 0, ComparisonMember{Task: &MockWatcherTask{}}, ComparisonMember{})
+	assert.Error(t, endpoint.Validate(), "expected error for nil Task")
+
+	endpoint = NewComparisonEndpoint("", value, 0, ComparisonMember{Task: &MockWatcherTask{}}, ComparisonMember{Name: "b", Task: &MockWatcherTask{}})
+	endpoint.Reference = ComparisonReference(5)
+	assert.Error(t, endpoint.Validate(), "expected error for unsupported Reference")
+
+	endpoint.Reference = ComparisonMax
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+	assert.Equal(t, "0", endpoint.Members[0].Name)
+	assert.Equal(t, "b", endpoint.Members[1].Name)
+}
+
+// blockNumberMember returns a member requesting the block number of a node answering with the
+// given result.
+func blockNumberMember(t *testing.T, name, result string) ComparisonMember {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + result + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	payload := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	return ComparisonMember{Name: name, Task: NewHTTPEndpoint(u, http.MethodPost, WithPayload(payload))}
+}
+
+func TestComparisonEndpointExecute(t *testing.T) {
+	execute := func(t *testing.T, endpoint *ComparisonEndpoint) WatcherResponse {
+		t.Helper()
+		require.NoError(t, endpoint.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("wid", respCh))
+		defer endpoint.Close()
+		require.NoError(t, endpoint.Task().Execute())
+		return <-respCh
+	}
+
+	t.Run("lagging member", func(t *testing.T) {
+		endpoint := NewComparisonEndpoint("group", ExtractJSON("result"), 2,
+			blockNumberMember(t, "node-1", "0x64"),
+			blockNumberMember(t, "node-2", "0x63"),
+			blockNumberMember(t, "node-3", "0x5a"),
+		)
+		endpoint.Reference = ComparisonMax
+
+		resp := execute(t, endpoint)
+		assert.Equal(t, "group", resp.TaskID)
+		assert.ErrorContains(t, resp.Err, "node-3")
+		assert.NotContains(t, resp.Err.Error(), "node-2")
+
+		md := resp.Metadata().Comparison
+		require.NotNil(t, md)
+		assert.Equal(t, 100.0, md.Max)
+		assert.Equal(t, 90.0, md.Min)
+		assert.Equal(t, 99.0, md.Median)
+		assert.Equal(t, 1, md.Flagged)
+		require.Len(t, md.Members, 3)
+		for i, want := range []struct {
+			value, deviation float64
+			flagged          bool
+		}{
+			{value: 100, deviation: 0},
+			{value: 99, deviation: -1},
+			{value: 90, deviation: -10, flagged: true},
+		} {
+			assert.Equal(t, want.value, md.Members[i].Value, "value of member %d", i)
+			assert.Equal(t, want.deviation, md.Members[i].Deviation, "deviation of member %d", i)
+			assert.Equal(t, want.flagged, md.Members[i].Flagged, "flag of member %d", i)
+			assert.Equal(t, http.StatusOK, md.Members[i].Metadata.StatusCode)
+		}
+		assert.Equal(t, 100.0, resp.Values["max"].Number)
+		assert.Equal(t, 90.0, resp.Values["min"].Number)
+		assert.Equal(t, 99.0, resp.Values["median"].Number)
+
+		_, err := resp.Payload.Data()
+		assert.Error(t, err, "expected no payload")
+	})
+
+	t.Run("within tolerance", func(t *testing.T) {
+		endpoint := NewComparisonEndpoint("", ExtractJSON("result"), 1,
+			blockNumberMember(t, "", "0x64"),
+			blockNumberMember(t, "", "0x63"),
+		)
+		resp := execute(t, endpoint)
+		require.NoError(t, resp.Err)
+		md := resp.Metadata().Comparison
+		require.NotNil(t, md)
+		assert.Equal(t, 99.5, md.Median)
+		assert.Equal(t, 0, md.Flagged)
+	})
+
+	t.Run("failing member", func(t *testing.T) {
+		endpoint := NewComparisonEndpoint("", ExtractJSON("result"), 0,
+			blockNumberMember(t, "ok", "0x64"),
+			blockNumberMember(t, "invalid", "latest"),
+		)
+		resp := execute(t, endpoint)
+		assert.ErrorContains(t, resp.Err, "invalid")
+		md := resp.Metadata().Comparison
+		require.NotNil(t, md)
+		assert.Equal(t, 100.0, md.Median)
+		assert.False(t, md.Members[0].Flagged)
+		assert.True(t, md.Members[1].Flagged)
+		assert.ErrorContains(t, md.Members[1].Err, "failed to extract value")
+	})
+}
+
+func TestCompareResults(t *testing.T) {
+	failed := ComparisonResult{Name: "failed", Err: errors.New("failed")}
+	md := compareResults([]ComparisonResult{failed, {Value: 5}, {Value: 1}, {Value: 2}, {Value: 9}}, ComparisonMin, 3)
+	assert.Equal(t, 9.0, md.Max)
+	assert.Equal(t, 1.0, md.Min)
+	assert.Equal(t, 3.5, md.Median)
+	assert.Equal(t, []bool{true, true, false, false, true}, []bool{
+		md.Members[0].Flagged, md.Members[1].Flagged, md.Members[2].Flagged, md.Members[3].Flagged, md.Members[4].Flagged,
+	})
+	assert.Equal(t, 8.0, md.Members[4].Deviation)
+	assert.Equal(t, 3, md.Flagged)
+
+	md = compareResults([]ComparisonResult{failed, failed}, ComparisonMedian, 0)
+	assert.Zero(t, md.Median)
+	assert.Equal(t, 2, md.Flagged)
+}
+
+// hangingTask is a WatcherTask whose executions block until released.
+type hangingTask struct {
+	MockWatcherTask
+	release chan struct{}
+}
+
+func (h *hangingTask) Task() taskman.Task {
+	return h
+}
+
+func (h *hangingTask) Execute() error {
+	<-h.release
+	return nil
+}
+
+func TestComparisonEndpointHungMember(t *testing.T) {
+	timeout := awaitTimeout
+	awaitTimeout = 100 * time.Millisecond
+	defer func() { awaitTimeout = timeout }()
+
+	hung := &hangingTask{release: make(chan struct{})}
+	defer close(hung.release)
+	endpoint := NewComparisonEndpoint("", ExtractJSON("result"), 0,
+		blockNumberMember(t, "ok", "0x64"),
+		ComparisonMember{Name: "hung", Task: hung},
+	)
+	require.NoError(t, endpoint.Validate())
+	respCh := make(chan WatcherResponse, 1)
+	require.NoError(t, endpoint.Initialize("wid", respCh))
+
+	// Every cycle completes within the deadline, despite the member still hanging
+	for range 2 {
+		start := time.Now()
+		require.NoError(t, endpoint.Task().Execute())
+		assert.Less(t, time.Since(start), time.Second)
+		md := (<-respCh).Metadata().Comparison
+		require.NotNil(t, md)
+		assert.False(t, md.Members[0].Flagged)
+		assert.ErrorContains(t, md.Members[1].Err, "timed out")
+	}
+}
+
+// slowFirstTask is a WatcherTask whose first execution responds after a delay, with a different
+// result than the later executions.
+type slowFirstTask struct {
+	MockWatcherTask
+	delay    time.Duration
+	returned chan struct{}
+	calls    atomic.Int32
+}
+
+func (s *slowFirstTask) Task() taskman.Task {
+	return s
+}
+
+func (s *slowFirstTask) Execute() error {
+	result := "0x64"
+	if s.calls.Add(1) == 1 {
+		defer close(s.returned)
+		time.Sleep(s.delay)
+		result = "0x1"
+	}
+	s.respChan <- WatcherResponse{
+		TaskID:  s.ID,
+		Payload: &MockTaskResponse{data: []byte(`{"result":"` + result + `"}`)},
+	}
+	return nil
+}
+
+func TestComparisonEndpointLateMember(t *testing.T) {
+	timeout := awaitTimeout
+	awaitTimeout = 50 * time.Millisecond
+	defer func() { awaitTimeout = timeout }()
+
+	slow := &slowFirstTask{delay: 200 * time.Millisecond, returned: make(chan struct{})}
+	endpoint := NewComparisonEndpoint("", ExtractJSON("result"), 0,
+		blockNumberMember(t, "ok", "0x64"),
+		ComparisonMember{Name: "slow", Task: slow},
+	)
+	require.NoError(t, endpoint.Validate())
+	respCh := make(chan WatcherResponse, 1)
+	require.NoError(t, endpoint.Initialize("wid", respCh))
+
+	execute := func() ComparisonResult {
+		t.Helper()
+		require.NoError(t, endpoint.Task().Execute())
+		md := (<-respCh).Metadata().Comparison
+		require.NotNil(t, md)
+		return md.Members[1]
+	}
+
+	// The first execution times out, and is not run again until it has returned
+	assert.ErrorContains(t, execute().Err, "timed out waiting for response")
+	assert.ErrorContains(t, execute().Err, "previous execution")
+	assert.EqualValues(t, 1, slow.calls.Load())
+
+	// The late response of the first execution is not taken for that of the next one
+	<-slow.returned
+	var result ComparisonResult
+	require.Eventually(t, func() bool {
+		result = execute()
+		return result.Err == nil || !strings.Contains(result.Err.Error(), "previous execution")
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, result.Err)
+	assert.EqualValues(t, 100, result.Value)
+	assert.EqualValues(t, 2, slow.calls.Load())
+}
+
+func TestComparisonEndpointValidateSingleResponse(t *testing.T) {
+	u, err := url.Parse("ws://localhost/ws")
+	require.NoError(t, err)
+	endpoint := NewComparisonEndpoint("", ExtractJSON("result"), 0,
+		ComparisonMember{Task: &MockWatcherTask{}},
+		ComparisonMember{Task: NewWSEndpoint(u, nil, PersistentJSONRPC, []byte(`{}`), "")},
+	)
+	assert.ErrorContains(t, endpoint.Validate(), "persistent")
+}
@@ -0,0 +1,316 @@
+package wadjit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// DNSEndpoint queries a DNS server for the records of a name, and reports the answer. Implements
+// the WatcherTask interface and is meant for use in a Watcher.
+type DNSEndpoint struct {
+	// Server is the address of the DNS server to query, "host" or "host:port". The port defaults
+	// to 53.
+	Server string
+	// Network is the transport to query over, "udp" or "tcp". Defaults to "udp", retrying over
+	// TCP when the answer is truncated.
+	Network string
+	Name    string
+	Type    DNSRecordType
+	ID      string
+
+	// Expected is the set of record values the answer must match, in any order, for records of
+	// the queried type. Values are in the presentation format of DNSRecord. No check is made
+	// when nil.
+	Expected []string
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// DNSMetadata contains the outcome of a DNSEndpoint query.
+type DNSMetadata struct {
+	RCode     int
+	RCodeName string
+	Answers   []DNSRecord
+	// QueryTime is the time from sending the query to receiving the answer.
+	QueryTime time.Duration
+	// Network is the transport the answer was received over.
+	Network string
+}
+
+// Close closes the DNSEndpoint.
+func (e *DNSEndpoint) Close() error {
+	return nil
+}
+
+// Initialize sets up the DNSEndpoint to be able to send on its responses.
+func (e *DNSEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+
+	if e.Network == "" {
+		e.Network = "udp"
+	}
+	if _, _, err := net.SplitHostPort(e.Server); err != nil {
+		e.Server = net.JoinHostPort(strings.Trim(e.Server, "[]"), "53")
+	}
+
+	return nil
+}
+
+// Task returns a taskman.Task that queries the DNS server.
+func (e *DNSEndpoint) Task() taskman.Task {
+	return &dnsQuery{endpoint: e}
+}
+
+// Validate checks that the DNSEndpoint is ready to be initialized.
+func (e *DNSEndpoint) Validate() error {
+	if e.Server == "" {
+		return errors.New("Server is empty")
+	}
+	if e.Name == "" {
+		return errors.New("Name is empty")
+	}
+	if !e.Type.supported() {
+		return fmt.Errorf("unsupported record type %s", e.Type)
+	}
+	if e.Network != "" && e.Network != "udp" && e.Network != "tcp" {
+		return fmt.Errorf("unsupported network %q", e.Network)
+	}
+	if _, err := appendDNSName(nil, e.Name); err != nil {
+		return err
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	return nil
+}
+
+// url returns a URL identifying the query, used in responses.
+func (e *DNSEndpoint) url() *url.URL {
+	return &url.URL{
+		Scheme:   "dns",
+		Host:     e.Server,
+		Path:     "/" + fqdn(e.Name),
+		RawQuery: "type=" + e.Type.String(),
+	}
+}
+
+// dnsQuery is an implementation of taskman.Task that queries a DNSEndpoint.
+type dnsQuery struct {
+	endpoint *DNSEndpoint
+}
+
+// Execute queries the DNS server and sends the answer as a response. A response code other than
+// NOERROR, or an answer not matching the expected set, is set as the response's error.
+func (q *dnsQuery) Execute() error {
+	u := q.endpoint.url()
+
+	// TODO: move timeout to configuration
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query, err := buildDNSQuery(uint16(rand.N(1<<16)), q.endpoint.Name, q.endpoint.Type)
+	if err != nil {
+		q.endpoint.respChan <- errorResponse(err, q.endpoint.ID, q.endpoint.watcherID, u)
+		return err
+	}
+
+	timestamps := requestTimestamps{}
+	network := q.endpoint.Network
+	raw, msg, remoteAddr, err := exchangeDNS(ctx, network, q.endpoint.Server, query, &timestamps)
+	if err == nil && msg.truncated && network == "udp" {
+		// Retry over TCP for the full answer
+		network = "tcp"
+		timestamps = requestTimestamps{}
+		raw, msg, remoteAddr, err = exchangeDNS(ctx, network, q.endpoint.Server, query, &timestamps)
+	}
+	if err != nil {
+		q.endpoint.respChan <- errorResponse(err, q.endpoint.ID, q.endpoint.watcherID, u)
+		return err
+	}
+
+	metadata := DNSMetadata{
+		RCode:     msg.rcode,
+		RCodeName: dnsRCodeName(msg.rcode),
+		Answers:   msg.answers,
+		QueryTime: timestamps.firstByte.Sub(timestamps.wroteDone),
+		Network:   network,
+	}
+
+	var respErr error
+	if msg.rcode != 0 {
+		respErr = fmt.Errorf("DNS query failed with %s", metadata.RCodeName)
+	} else if q.endpoint.Expected != nil {
+		respErr = checkDNSAnswer(msg.answers, q.endpoint.Type, q.endpoint.Expected)
+	}
+
+	q.endpoint.respChan <- WatcherResponse{
+		TaskID:    q.endpoint.ID,
+		WatcherID: q.endpoint.watcherID,
+		URL:       u,
+		Err:       respErr,
+		Payload: &DNSTaskResponse{
+			remoteAddr: remoteAddr,
+			data:       raw,
+			timestamps: timestamps,
+			dns:        metadata,
+		},
+	}
+
+	return nil
+}
+
+// exchangeDNS sends the query to the server over the network, and reads and decodes the answer.
+// Returns the raw answer along with the decoded message.
+func exchangeDNS(
+	ctx context.Context,
+	network, server string,
+	query []byte,
+	times *requestTimestamps,
+) ([]byte, dnsMessage, net.Addr, error) {
+	// TODO: move timeout to configuration
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	times.start = time.Now()
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, dnsMessage{}, nil, err
+	}
+	defer conn.Close()
+	if network == "tcp" {
+		times.connStart, times.connDone = times.start, time.Now()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var raw []byte
+	if network == "tcp" {
+		// Messages over TCP are prefixed with their length
+		msg := append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+		if _, err := conn.Write(msg); err != nil {
+			return nil, dnsMessage{}, nil, fmt.Errorf("failed to write query: %w", err)
+		}
+		times.wroteDone = time.Now()
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, dnsMessage{}, nil, fmt.Errorf("failed to read answer: %w", err)
+		}
+		times.firstByte = time.Now()
+		raw = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, raw); err != nil {
+			return nil, dnsMessage{}, nil, fmt.Errorf("failed to read answer: %w", err)
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, dnsMessage{}, nil, fmt.Errorf("failed to write query: %w", err)
+		}
+		times.wroteDone = time.Now()
+		// Datagrams with another ID, e.g. late answers to earlier queries or spoofed ones, are
+		// ignored until the deadline
+		buf := make([]byte, maxUDPDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, dnsMessage{}, nil, fmt.Errorf("failed to read answer: %w", err)
+			}
+			if n >= 2 && binary.BigEndian.Uint16(buf) == binary.BigEndian.Uint16(query) {
+				times.firstByte = time.Now()
+				raw = buf[:n]
+				break
+			}
+		}
+	}
+	times.dataDone = time.Now()
+
+	msg, err := parseDNSResponse(raw)
+	if err != nil {
+		return nil, dnsMessage{}, nil, err
+	}
+	if msg.id != binary.BigEndian.Uint16(query) {
+		return nil, dnsMessage{}, nil, errors.New("DNS answer ID does not match query")
+	}
+	return raw, msg, conn.RemoteAddr(), nil
+}
+
+// checkDNSAnswer checks that the values of the answer's records of the queried type match the
+// expected set, in any order.
+func checkDNSAnswer(answers []DNSRecord, rtype DNSRecordType, expected []string) error {
+	var got []string
+	for _, record := range answers {
+		if record.Type == rtype {
+			got = append(got, record.Value)
+		}
+	}
+	gotSorted := slices.Sorted(slices.Values(got))
+	expectedSorted := slices.Sorted(slices.Values(expected))
+	if !slices.Equal(gotSorted, expectedSorted) {
+		return &AssertionError{
+			Expected: fmt.Sprintf("answer [%s]", strings.Join(expectedSorted, ", ")),
+			Got:      []byte(strings.Join(gotSorted, ", ")),
+		}
+	}
+	return nil
+}
+
+// NewDNSEndpoint creates a new DNSEndpoint with the given attributes.
+func NewDNSEndpoint(server, name string, rtype DNSRecordType, id string) *DNSEndpoint {
+	return &DNSEndpoint{
+		Server: server,
+		Name:   name,
+		Type:   rtype,
+		ID:     id,
+	}
+}
+
+//
+// DNSTaskResponse
+//
+
+// DNSTaskResponse is a TaskResponse for DNS queries. Its data is the raw DNS answer message.
+type DNSTaskResponse struct {
+	remoteAddr net.Addr
+	data       []byte
+	timestamps requestTimestamps
+	dns        DNSMetadata
+}
+
+// Close is a no-op, the connection is closed after the answer is read.
+func (d *DNSTaskResponse) Close() error {
+	return nil
+}
+
+// Data returns the raw DNS answer message.
+func (d *DNSTaskResponse) Data() ([]byte, error) {
+	return d.data, nil
+}
+
+// Reader returns an io.ReadCloser for the data. Closing is a no-op.
+func (d *DNSTaskResponse) Reader() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(d.data)), nil
+}
+
+// Metadata returns metadata connected to the response.
+func (d *DNSTaskResponse) Metadata() TaskResponseMetadata {
+	dns := d.dns
+	return TaskResponseMetadata{
+		RemoteAddr: d.remoteAddr,
+		Size:       int64(len(d.data)),
+		TimeData:   TimeDataFromTimestamps(d.timestamps),
+		DNS:        &dns,
+	}
+}
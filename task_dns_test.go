@@ -0,0 +1,331 @@
+package wadjit
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDNSRecord is a record served by dnsTestServer, with its data in wire format.
+type testDNSRecord struct {
+	name  string
+	rtype DNSRecordType
+	ttl   uint32
+	rdata []byte
+}
+
+// testDNSName returns the name in wire format.
+func testDNSName(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := appendDNSName(nil, name)
+	require.NoError(t, err)
+	return b
+}
+
+// testDNSZone returns the records served by dnsTestServer, keyed by the queried name.
+func testDNSZone(t *testing.T) map[string][]testDNSRecord {
+	mx := binary.BigEndian.AppendUint16(nil, 10)
+	srv := binary.BigEndian.AppendUint16(nil, 1)
+	srv = binary.BigEndian.AppendUint16(srv, 5)
+	srv = binary.BigEndian.AppendUint16(srv, 8080)
+	big := make([]testDNSRecord, 0, 40)
+	for i := range 40 {
+		big = append(big, testDNSRecord{"big.wadjit.test.", DNSTypeA, 60, []byte{10, 0, 0, byte(i)}})
+	}
+
+	return map[string][]testDNSRecord{
+		"wadjit.test.": {
+			{"wadjit.test.", DNSTypeA, 300, []byte{192, 0, 2, 1}},
+			{"wadjit.test.", DNSTypeA, 300, []byte{192, 0, 2, 2}},
+			{"wadjit.test.", DNSTypeAAAA, 300, net.ParseIP("2001:db8::1").To16()},
+			{"wadjit.test.", DNSTypeMX, 3600, append(mx, testDNSName(t, "mail.wadjit.test")...)},
+			{"wadjit.test.", DNSTypeTXT, 60, []byte("\x05hello\x06 world")},
+		},
+		"www.wadjit.test.": {
+			{"www.wadjit.test.", DNSTypeCNAME, 120, testDNSName(t, "wadjit.test")},
+			{"wadjit.test.", DNSTypeA, 300, []byte{192, 0, 2, 1}},
+		},
+		"_http._tcp.wadjit.test.": {
+			{"_http._tcp.wadjit.test.", DNSTypeSRV, 60, append(srv, testDNSName(t, "wadjit.test")...)},
+		},
+		"big.wadjit.test.": big,
+	}
+}
+
+// dnsTestAnswer builds the answer to a query from the zone. Answers over UDP larger than 512 bytes
+// are truncated.
+func dnsTestAnswer(query []byte, zone map[string][]testDNSRecord, udp bool) []byte {
+	name, next, err := readDNSName(query, dnsHeaderLen)
+	if err != nil {
+		return nil
+	}
+	qtype := DNSRecordType(binary.BigEndian.Uint16(query[next:]))
+	question := query[dnsHeaderLen : next+4]
+
+	records, found := zone[name]
+	var answers []byte
+	var count uint16
+	for _, record := range records {
+		if record.rtype != qtype && record.rtype != DNSTypeCNAME {
+			continue
+		}
+		// Point to the question name when possible, to exercise name compression
+		if record.name == name {
+			answers = binary.BigEndian.AppendUint16(answers, 0xc000|dnsHeaderLen)
+		} else {
+			answers, _ = appendDNSName(answers, record.name)
+		}
+		answers = binary.BigEndian.AppendUint16(answers, uint16(record.rtype))
+		answers = binary.BigEndian.AppendUint16(answers, dnsClassINET)
+		answers = binary.BigEndian.AppendUint32(answers, record.ttl)
+		answers = binary.BigEndian.AppendUint16(answers, uint16(len(record.rdata)))
+		answers = append(answers, record.rdata...)
+		count++
+	}
+
+	flags := uint16(1<<15 | 1<<8 | 1<<7) // QR, RD, RA
+	if !found {
+		flags |= 3 // NXDOMAIN
+	}
+	truncated := udp && dnsHeaderLen+len(question)+len(answers) > 512
+	if truncated {
+		flags |= 1 << 9
+		answers, count = nil, 0
+	}
+
+	resp := make([]byte, dnsHeaderLen)
+	copy(resp, query[:2])
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], count)
+	resp = append(resp, question...)
+	return append(resp, answers...)
+}
+
+// dnsTestServer starts a DNS server on UDP and TCP on the same port, answering from testDNSZone.
+// Returns the server address.
+func dnsTestServer(t *testing.T) string {
+	t.Helper()
+	zone := testDNSZone(t)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	udpConn, err := net.ListenPacket("udp", tcpListener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		tcpListener.Close()
+		udpConn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udpConn.WriteTo(dnsTestAnswer(buf[:n], zone, true), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := dnsTestAnswer(query, zone, false)
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			}()
+		}
+	}()
+
+	return tcpListener.Addr().String()
+}
+
+func TestDNSEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &DNSEndpoint{}
+}
+
+func TestDNSEndpointValidate(t *testing.T) {
+	endpoint := &DNSEndpoint{Name: "wadjit.test", Type: DNSTypeA}
+	assert.Error(t, endpoint.Validate(), "expected error for empty server")
+
+	endpoint = &DNSEndpoint{Server: "127.0.0.1", Type: DNSTypeA}
+	assert.Error(t, endpoint.Validate(), "expected error for empty name")
+
+	endpoint = &DNSEndpoint{Server: "127.0.0.1", Name: "wadjit.test", Type: DNSRecordType(255)}
+	assert.Error(t, endpoint.Validate(), "expected error for unsupported type")
+
+	endpoint = &DNSEndpoint{Server: "127.0.0.1", Name: "wadjit.test", Type: DNSTypeA, Network: "quic"}
+	assert.Error(t, endpoint.Validate(), "expected error for unsupported network")
+
+	endpoint = &DNSEndpoint{Server: "127.0.0.1", Name: "wadjit..test", Type: DNSTypeA}
+	assert.Error(t, endpoint.Validate(), "expected error for invalid name")
+
+	endpoint = NewDNSEndpoint("127.0.0.1", "wadjit.test", DNSTypeA, "")
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+	require.NoError(t, endpoint.Initialize("a-watcher-id", make(chan WatcherResponse)))
+	assert.Equal(t, "127.0.0.1:53", endpoint.Server)
+	assert.Equal(t, "udp", endpoint.Network)
+	assert.Equal(t, "dns://127.0.0.1:53/wadjit.test.?type=A", endpoint.url().String())
+}
+
+func TestDNSEndpointExecute(t *testing.T) {
+	server := dnsTestServer(t)
+
+	execute := func(t *testing.T, endpoint *DNSEndpoint) WatcherResponse {
+		t.Helper()
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		endpoint.Task().Execute()
+		select {
+		case resp := <-responseChan:
+			assert.Equal(t, endpoint.ID, resp.TaskID)
+			return resp
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+		return WatcherResponse{}
+	}
+
+	records := func(t *testing.T, resp WatcherResponse) []DNSRecord {
+		t.Helper()
+		md := resp.Metadata()
+		require.NotNil(t, md.DNS)
+		return md.DNS.Answers
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run("A over "+network, func(t *testing.T) {
+			endpoint := NewDNSEndpoint(server, "wadjit.test", DNSTypeA, "an-id")
+			endpoint.Network = network
+			endpoint.Expected = []string{"192.0.2.2", "192.0.2.1"}
+			resp := execute(t, endpoint)
+			require.NoError(t, resp.Err)
+
+			md := resp.Metadata()
+			require.NotNil(t, md.DNS)
+			assert.Equal(t, 0, md.DNS.RCode)
+			assert.Equal(t, "NOERROR", md.DNS.RCodeName)
+			assert.Equal(t, network, md.DNS.Network)
+			assert.Positive(t, md.DNS.QueryTime)
+			assert.Equal(t, []DNSRecord{
+				{Name: "wadjit.test.", Type: DNSTypeA, TTL: 300, Value: "192.0.2.1"},
+				{Name: "wadjit.test.", Type: DNSTypeA, TTL: 300, Value: "192.0.2.2"},
+			}, md.DNS.Answers)
+			assert.Equal(t, server, md.RemoteAddr.String())
+
+			data, err := resp.Data()
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), md.Size)
+		})
+	}
+
+	t.Run("record types", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			rtype DNSRecordType
+			want  []DNSRecord
+		}{
+			{"wadjit.test", DNSTypeAAAA, []DNSRecord{{"wadjit.test.", DNSTypeAAAA, 300, "2001:db8::1"}}},
+			{"wadjit.test", DNSTypeMX, []DNSRecord{{"wadjit.test.", DNSTypeMX, 3600, "10 mail.wadjit.test."}}},
+			{"wadjit.test", DNSTypeTXT, []DNSRecord{{"wadjit.test.", DNSTypeTXT, 60, "hello world"}}},
+			{"_http._tcp.wadjit.test", DNSTypeSRV, []DNSRecord{
+				{"_http._tcp.wadjit.test.", DNSTypeSRV, 60, "1 5 8080 wadjit.test."},
+			}},
+			{"www.wadjit.test", DNSTypeA, []DNSRecord{
+				{"www.wadjit.test.", DNSTypeCNAME, 120, "wadjit.test."},
+				{"wadjit.test.", DNSTypeA, 300, "192.0.2.1"},
+			}},
+		}
+		for _, c := range cases {
+			resp := execute(t, NewDNSEndpoint(server, c.name, c.rtype, "an-id"))
+			require.NoError(t, resp.Err)
+			assert.Equal(t, c.want, records(t, resp), "%s %s", c.name, c.rtype)
+		}
+	})
+
+	t.Run("truncated answer retried over TCP", func(t *testing.T) {
+		resp := execute(t, NewDNSEndpoint(server, "big.wadjit.test", DNSTypeA, "an-id"))
+		require.NoError(t, resp.Err)
+		assert.Len(t, records(t, resp), 40)
+		assert.Equal(t, "tcp", resp.Metadata().DNS.Network)
+	})
+
+	t.Run("NXDOMAIN", func(t *testing.T) {
+		resp := execute(t, NewDNSEndpoint(server, "missing.wadjit.test", DNSTypeA, "an-id"))
+		assert.ErrorContains(t, resp.Err, "NXDOMAIN")
+		md := resp.Metadata()
+		require.NotNil(t, md.DNS)
+		assert.Equal(t, 3, md.DNS.RCode)
+		assert.Empty(t, md.DNS.Answers)
+	})
+
+	t.Run("unexpected answer", func(t *testing.T) {
+		endpoint := NewDNSEndpoint(server, "wadjit.test", DNSTypeA, "an-id")
+		endpoint.Expected = []string{"192.0.2.1"}
+		resp := execute(t, endpoint)
+		var assertionErr *AssertionError
+		require.True(t, errors.As(resp.Err, &assertionErr), "expected an *AssertionError, got %v", resp.Err)
+		assert.Equal(t, "192.0.2.1, 192.0.2.2", string(assertionErr.Got))
+	})
+
+	t.Run("mismatched ID ignored over UDP", func(t *testing.T) {
+		// The server first answers with a wrong ID, then with the right one
+		zone := testDNSZone(t)
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+		go func() {
+			buf := make([]byte, 512)
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			answer := dnsTestAnswer(buf[:n], zone, true)
+			spoofed := append([]byte{}, answer...)
+			spoofed[0] ^= 0xff
+			conn.WriteTo([]byte{0}, addr)
+			conn.WriteTo(spoofed, addr)
+			conn.WriteTo(answer, addr)
+		}()
+
+		endpoint := NewDNSEndpoint(conn.LocalAddr().String(), "wadjit.test", DNSTypeA, "an-id")
+		endpoint.Network = "udp"
+		resp := execute(t, endpoint)
+		require.NoError(t, resp.Err)
+		assert.Len(t, records(t, resp), 2)
+	})
+
+	t.Run("no server", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		endpoint := NewDNSEndpoint("127.0.0.1:"+strconv.Itoa(port), "wadjit.test", DNSTypeA, "an-id")
+		endpoint.Network = "tcp"
+		resp := execute(t, endpoint)
+		assert.Error(t, resp.Err)
+		assert.Nil(t, resp.Payload)
+	})
+}
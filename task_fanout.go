@@ -0,0 +1,373 @@
+package wadjit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// AddrResolver resolves a host name into IP addresses. Implemented by *net.Resolver.
+type AddrResolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// FanOutEndpoint is a single logical task that resolves a host name, and probes each of the
+// resolved addresses with a task pinned to that address, e.g. an HTTPEndpoint or WSEndpoint with
+// a TransportControl. One response is sent per address and cycle, with the probed address and
+// the changes to the set of resolved addresses in the response metadata. Implements the
+// WatcherTask interface and is meant for use in a Watcher.
+type FanOutEndpoint struct {
+	ID   string
+	Host string
+	Port uint16
+
+	// NewTask creates the task that probes the given address. The task's responses are sent on
+	// with the FanOutEndpoint's ID as their TaskID. An address whose task fails to be created,
+	// validated or initialized gets an error response, and is retried on the next execution.
+	NewTask func(addr netip.AddrPort) WatcherTask
+
+	// Network is the network to resolve addresses for, "ip", "ip4" or "ip6". Defaults to "ip".
+	Network string
+	// Resolver resolves the host. Defaults to net.DefaultResolver.
+	Resolver AddrResolver
+	// ResolveInterval is the minimum time between resolutions of the host. When zero, the host
+	// is resolved on every execution.
+	ResolveInterval time.Duration
+
+	mu         sync.Mutex
+	targets    map[netip.Addr]*fanOutTarget
+	resolvedAt time.Time
+	changes    FanOutMetadata
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// FanOutMetadata is the metadata added to responses of a FanOutEndpoint.
+type FanOutMetadata struct {
+	// Addr is the address probed.
+	Addr netip.AddrPort
+	// Added and Removed are the addresses added to, and removed from, the set of resolved
+	// addresses by the latest resolution that changed the set. They are kept until the set
+	// changes again, ChangedAt telling the responses of later cycles apart.
+	Added   []netip.Addr
+	Removed []netip.Addr
+	// ChangedAt is the time of the resolution that made the changes, zero before any.
+	ChangedAt time.Time
+}
+
+// fanOutTarget is a task probing a single resolved address.
+type fanOutTarget struct {
+	addr     netip.AddrPort
+	task     WatcherTask
+	respChan chan WatcherResponse
+	done     chan struct{}
+}
+
+// Close closes the tasks of all resolved addresses.
+func (e *FanOutEndpoint) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var errs error
+	for addr, target := range e.targets {
+		if err := target.close(); err != nil {
+			errs = errors.Join(errs, err)
+		}
+		delete(e.targets, addr)
+	}
+	return errs
+}
+
+// Initialize sets up the FanOutEndpoint to be able to send on its responses. The host is resolved
+// on the first execution.
+func (e *FanOutEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+	e.targets = make(map[netip.Addr]*fanOutTarget)
+	if e.Network == "" {
+		e.Network = "ip"
+	}
+	if e.Resolver == nil {
+		e.Resolver = net.DefaultResolver
+	}
+
+	return nil
+}
+
+// Task returns a taskman.Task that probes all resolved addresses of the host.
+func (e *FanOutEndpoint) Task() taskman.Task {
+	return &fanOutRequest{endpoint: e}
+}
+
+// Validate checks that the FanOutEndpoint is ready to be initialized.
+func (e *FanOutEndpoint) Validate() error {
+	if e.Host == "" {
+		return errors.New("Host is empty")
+	}
+	if e.Port == 0 {
+		return errors.New("Port is zero")
+	}
+	if e.NewTask == nil {
+		return errors.New("NewTask is nil")
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	return nil
+}
+
+// Addrs returns the currently resolved addresses, sorted.
+func (e *FanOutEndpoint) Addrs() []netip.Addr {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	addrs := make([]netip.Addr, 0, len(e.targets))
+	for addr := range e.targets {
+		addrs = append(addrs, addr)
+	}
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	return addrs
+}
+
+// hostURL returns a URL identifying the host, used in responses not tied to an address.
+func (e *FanOutEndpoint) hostURL() *url.URL {
+	return &url.URL{Host: net.JoinHostPort(e.Host, strconv.Itoa(int(e.Port)))}
+}
+
+// resolve resolves the host if due, and updates the set of targets to match the resolved
+// addresses. Returns the targets to probe, and the errors of the addresses whose target failed to
+// start or close.
+func (e *FanOutEndpoint) resolve(ctx context.Context) ([]*fanOutTarget, map[netip.AddrPort]error, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var failed map[netip.AddrPort]error
+	if e.resolvedAt.IsZero() || time.Since(e.resolvedAt) >= e.ResolveInterval {
+		addrs, err := e.Resolver.LookupNetIP(ctx, e.Network, e.Host)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve %q: %w", e.Host, err)
+		}
+		failed = e.updateTargets(addrs)
+		if len(failed) == 0 {
+			e.resolvedAt = time.Now()
+		} else {
+			// Resolve again on the next execution, retrying the addresses that failed
+			e.resolvedAt = time.Time{}
+		}
+	}
+
+	targets := make([]*fanOutTarget, 0, len(e.targets))
+	for _, target := range e.targets {
+		targets = append(targets, target)
+	}
+	return targets, failed, nil
+}
+
+// updateTargets starts a target for each new address and closes the targets of addresses no
+// longer resolved. Returns the errors of the addresses whose target failed to start or close.
+// Note: the caller must hold the lock.
+func (e *FanOutEndpoint) updateTargets(addrs []netip.Addr) map[netip.AddrPort]error {
+	resolved := make(map[netip.Addr]struct{}, len(addrs))
+	var added, removed []netip.Addr
+	failed := make(map[netip.AddrPort]error)
+
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		resolved[addr] = struct{}{}
+		if _, ok := e.targets[addr]; ok {
+			continue
+		}
+		addrPort := netip.AddrPortFrom(addr, e.Port)
+		target, err := e.newTarget(addrPort)
+		if err != nil {
+			failed[addrPort] = err
+			continue
+		}
+		e.targets[addr] = target
+		added = append(added, addr)
+	}
+	for addr, target := range e.targets {
+		if _, ok := resolved[addr]; ok {
+			continue
+		}
+		if err := target.close(); err != nil {
+			failed[target.addr] = fmt.Errorf("failed to close task for %s: %w", target.addr, err)
+		}
+		delete(e.targets, addr)
+		removed = append(removed, addr)
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		slices.SortFunc(added, netip.Addr.Compare)
+		slices.SortFunc(removed, netip.Addr.Compare)
+		e.changes = FanOutMetadata{Added: added, Removed: removed, ChangedAt: time.Now()}
+	}
+	return failed
+}
+
+// newTarget creates, validates and initializes the task for an address, and starts forwarding
+// its responses.
+func (e *FanOutEndpoint) newTarget(addr netip.AddrPort) (*fanOutTarget, error) {
+	task := e.NewTask(addr)
+	if task == nil {
+		return nil, fmt.Errorf("NewTask returned nil for %s", addr)
+	}
+	if err := task.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid task for %s: %w", addr, err)
+	}
+
+	target := &fanOutTarget{
+		addr:     addr,
+		task:     task,
+		respChan: make(chan WatcherResponse, 1),
+		done:     make(chan struct{}),
+	}
+	if err := task.Initialize(e.watcherID, target.respChan); err != nil {
+		return nil, fmt.Errorf("failed to initialize task for %s: %w", addr, err)
+	}
+	go e.forward(target)
+
+	return target, nil
+}
+
+// forward sends the responses of a target's task on the FanOutEndpoint's response channel, with
+// the FanOutEndpoint's ID and the fan-out metadata set.
+func (e *FanOutEndpoint) forward(target *fanOutTarget) {
+	for {
+		select {
+		case resp := <-target.respChan:
+			e.mu.Lock()
+			metadata := e.changes
+			e.mu.Unlock()
+			metadata.Addr = target.addr
+
+			resp.TaskID = e.ID
+			resp.Payload = &fanOutTaskResponse{TaskResponse: resp.Payload, fanOut: metadata}
+			e.respChan <- resp
+		case <-target.done:
+			return
+		}
+	}
+}
+
+// addrErrorResponse returns an error response for an address, with the fan-out metadata set.
+func (e *FanOutEndpoint) addrErrorResponse(addr netip.AddrPort, err error) WatcherResponse {
+	e.mu.Lock()
+	metadata := e.changes
+	e.mu.Unlock()
+	metadata.Addr = addr
+
+	resp := errorResponse(err, e.ID, e.watcherID, e.hostURL())
+	resp.Payload = &fanOutTaskResponse{fanOut: metadata}
+	return resp
+}
+
+// close closes the target's task and stops forwarding its responses.
+func (t *fanOutTarget) close() error {
+	close(t.done)
+	return t.task.Close()
+}
+
+// fanOutRequest is an implementation of taskman.Task that probes every resolved address of a
+// FanOutEndpoint.
+type fanOutRequest struct {
+	endpoint *FanOutEndpoint
+}
+
+// Execute resolves the host if due, and then executes the tasks of all resolved addresses
+// concurrently.
+func (r *fanOutRequest) Execute() error {
+	// TODO: move timeout to configuration
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	targets, failed, err := r.endpoint.resolve(ctx)
+	if err != nil {
+		r.endpoint.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, r.endpoint.hostURL())
+		return err
+	}
+
+	// The addresses whose target failed do not hold up the others
+	errChan := make(chan error, len(targets)+len(failed))
+	for addr, err := range failed {
+		r.endpoint.respChan <- r.endpoint.addrErrorResponse(addr, err)
+		errChan <- err
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := target.task.Task().Execute(); err != nil {
+				errChan <- fmt.Errorf("%s: %w", target.addr, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errChan)
+
+	var errs error
+	for err := range errChan {
+		errs = errors.Join(errs, err)
+	}
+	return errs
+}
+
+// fanOutTaskResponse wraps the TaskResponse of a task probing a single address, adding the
+// fan-out metadata. The wrapped TaskResponse is nil for error responses.
+type fanOutTaskResponse struct {
+	TaskResponse
+	fanOut FanOutMetadata
+}
+
+// Close closes the wrapped response.
+func (f *fanOutTaskResponse) Close() error {
+	if f.TaskResponse == nil {
+		return nil
+	}
+	return f.TaskResponse.Close()
+}
+
+// Data returns the data of the wrapped response.
+func (f *fanOutTaskResponse) Data() ([]byte, error) {
+	if f.TaskResponse == nil {
+		return nil, errors.New("no payload")
+	}
+	return f.TaskResponse.Data()
+}
+
+// Reader returns a reader for the data of the wrapped response.
+func (f *fanOutTaskResponse) Reader() (io.ReadCloser, error) {
+	if f.TaskResponse == nil {
+		return nil, errors.New("no payload")
+	}
+	return f.TaskResponse.Reader()
+}
+
+// Metadata returns the metadata of the wrapped response, with the fan-out metadata set.
+func (f *fanOutTaskResponse) Metadata() TaskResponseMetadata {
+	var md TaskResponseMetadata
+	if f.TaskResponse != nil {
+		md = f.TaskResponse.Metadata()
+	}
+	fanOut := f.fanOut
+	md.FanOut = &fanOut
+	return md
+}
@@ -0,0 +1,227 @@
+package wadjit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticResolver is an AddrResolver returning a configurable set of addresses.
+type staticResolver struct {
+	mu    sync.Mutex
+	addrs []netip.Addr
+	calls int
+}
+
+func (r *staticResolver) LookupNetIP(_ context.Context, _, _ string) ([]netip.Addr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	return r.addrs, nil
+}
+
+func (r *staticResolver) set(addrs ...netip.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs = addrs
+}
+
+func TestFanOutEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &FanOutEndpoint{}
+}
+
+func TestFanOutEndpointValidate(t *testing.T) {
+	newTask := func(addr netip.AddrPort) WatcherTask { return &MockWatcherTask{} }
+
+	endpoint := &FanOutEndpoint{Port: 80, NewTask: newTask}
+	assert.Error(t, endpoint.Validate(), "expected error for empty Host")
+
+	endpoint = &FanOutEndpoint{Host: "example.com", NewTask: newTask}
+	assert.Error(t, endpoint.Validate(), "expected error for zero Port")
+
+	endpoint = &FanOutEndpoint{Host: "example.com", Port: 80}
+	assert.Error(t, endpoint.Validate(), "expected error for nil NewTask")
+
+	endpoint = &FanOutEndpoint{Host: "example.com", Port: 80, NewTask: newTask}
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+}
+
+func TestFanOutEndpointExecute(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	serverAddr := netip.MustParseAddrPort(serverURL.Host)
+
+	// The server only listens on 127.0.0.1, so probing 127.0.0.2 fails
+	up := netip.MustParseAddr("127.0.0.1")
+	down := netip.MustParseAddr("127.0.0.2")
+	resolver := &staticResolver{addrs: []netip.Addr{up, down}}
+
+	endpoint := &FanOutEndpoint{
+		ID:       "fan-out",
+		Host:     "service.test",
+		Port:     serverAddr.Port(),
+		Resolver: resolver,
+		NewTask: func(addr netip.AddrPort) WatcherTask {
+			u := &url.URL{Scheme: "http", Host: "service.test:" + serverURL.Port()}
+			return NewHTTPEndpoint(u, http.MethodGet,
+				WithTransportControl(&TransportControl{AddrPort: addr}))
+		},
+	}
+	require.NoError(t, endpoint.Validate())
+
+	responseChan := make(chan WatcherResponse, 4)
+	require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+	defer endpoint.Close()
+
+	collect := func() map[netip.Addr]WatcherResponse {
+		responses := make(map[netip.Addr]WatcherResponse)
+		for range len(endpoint.Addrs()) {
+			select {
+			case resp := <-responseChan:
+				assert.Equal(t, "fan-out", resp.TaskID)
+				assert.Equal(t, "a-watcher-id", resp.WatcherID)
+				md := resp.Metadata()
+				require.NotNil(t, md.FanOut)
+				responses[md.FanOut.Addr.Addr()] = resp
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for response")
+			}
+		}
+		return responses
+	}
+
+	t.Run("all addresses probed", func(t *testing.T) {
+		err := endpoint.Task().Execute()
+		assert.Error(t, err, "expected error from the unreachable address")
+		assert.Equal(t, []netip.Addr{up, down}, endpoint.Addrs())
+
+		responses := collect()
+		require.Len(t, responses, 2)
+
+		resp := responses[up]
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "GET request received on path /", string(data))
+		md := resp.Metadata()
+		assert.Equal(t, http.StatusOK, md.StatusCode)
+		assert.Equal(t, netip.AddrPortFrom(up, serverAddr.Port()), md.FanOut.Addr)
+		assert.Equal(t, []netip.Addr{up, down}, md.FanOut.Added)
+		assert.Empty(t, md.FanOut.Removed)
+		assert.False(t, md.FanOut.ChangedAt.IsZero())
+
+		resp = responses[down]
+		assert.Error(t, resp.Err)
+		assert.Equal(t, netip.AddrPortFrom(down, serverAddr.Port()), resp.Metadata().FanOut.Addr)
+	})
+
+	var changedAt time.Time
+	t.Run("removed address", func(t *testing.T) {
+		resolver.set(up)
+		err := endpoint.Task().Execute()
+		assert.NoError(t, err)
+		assert.Equal(t, []netip.Addr{up}, endpoint.Addrs())
+
+		responses := collect()
+		require.Len(t, responses, 1)
+		md := responses[up].Metadata()
+		assert.Empty(t, md.FanOut.Added)
+		assert.Equal(t, []netip.Addr{down}, md.FanOut.Removed)
+		changedAt = md.FanOut.ChangedAt
+	})
+
+	t.Run("unchanged addresses", func(t *testing.T) {
+		require.NoError(t, endpoint.Task().Execute())
+
+		// The changes of the earlier resolution are kept, with their time
+		responses := collect()
+		require.Len(t, responses, 1)
+		md := responses[up].Metadata()
+		assert.Equal(t, []netip.Addr{down}, md.FanOut.Removed)
+		assert.Equal(t, changedAt, md.FanOut.ChangedAt)
+	})
+
+	t.Run("resolve interval", func(t *testing.T) {
+		endpoint.ResolveInterval = time.Hour
+		calls := resolver.calls
+		resolver.set(up, down)
+
+		err := endpoint.Task().Execute()
+		assert.NoError(t, err)
+		assert.Equal(t, calls, resolver.calls, "expected no resolution within the interval")
+		assert.Equal(t, []netip.Addr{up}, endpoint.Addrs())
+		collect()
+	})
+}
+
+func TestFanOutEndpointFailedTarget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	serverAddr := netip.MustParseAddrPort(serverURL.Host)
+
+	up := netip.MustParseAddr("127.0.0.1")
+	broken := netip.MustParseAddr("127.0.0.2")
+	resolver := &staticResolver{addrs: []netip.Addr{up, broken}}
+
+	endpoint := &FanOutEndpoint{
+		ID:              "fan-out",
+		Host:            "service.test",
+		Port:            serverAddr.Port(),
+		Resolver:        resolver,
+		ResolveInterval: time.Hour,
+		NewTask: func(addr netip.AddrPort) WatcherTask {
+			if addr.Addr() == broken {
+				return nil
+			}
+			u := &url.URL{Scheme: "http", Host: "service.test:" + serverURL.Port()}
+			return NewHTTPEndpoint(u, http.MethodGet,
+				WithTransportControl(&TransportControl{AddrPort: addr}))
+		},
+	}
+	require.NoError(t, endpoint.Validate())
+
+	responseChan := make(chan WatcherResponse, 4)
+	require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+	defer endpoint.Close()
+
+	for range 2 {
+		calls := resolver.calls
+		err := endpoint.Task().Execute()
+		assert.ErrorContains(t, err, "NewTask returned nil")
+		assert.Equal(t, calls+1, resolver.calls, "expected the failed address to be retried")
+		assert.Equal(t, []netip.Addr{up}, endpoint.Addrs())
+
+		// The started address is probed, and the failed one reported on its own
+		responses := make(map[netip.Addr]WatcherResponse)
+		for range 2 {
+			select {
+			case resp := <-responseChan:
+				assert.Equal(t, "fan-out", resp.TaskID)
+				md := resp.Metadata()
+				require.NotNil(t, md.FanOut)
+				responses[md.FanOut.Addr.Addr()] = resp
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for response")
+			}
+		}
+		require.Len(t, responses, 2)
+		assert.NoError(t, responses[up].Err)
+		assert.ErrorContains(t, responses[broken].Err, "NewTask returned nil")
+		assert.Equal(t, netip.AddrPortFrom(broken, serverAddr.Port()), responses[broken].Metadata().FanOut.Addr)
+	}
+}
@@ -0,0 +1,442 @@
+package wadjit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// GRPCHealthStatus is the serving status of a gRPC health check, as defined by grpc.health.v1.
+type GRPCHealthStatus int
+
+const (
+	GRPCHealthUnknown        GRPCHealthStatus = 0
+	GRPCHealthServing        GRPCHealthStatus = 1
+	GRPCHealthNotServing     GRPCHealthStatus = 2
+	GRPCHealthServiceUnknown GRPCHealthStatus = 3 // Only used by Watch
+)
+
+// String returns the name of the serving status.
+func (s GRPCHealthStatus) String() string {
+	switch s {
+	case GRPCHealthUnknown:
+		return "UNKNOWN"
+	case GRPCHealthServing:
+		return "SERVING"
+	case GRPCHealthNotServing:
+		return "NOT_SERVING"
+	case GRPCHealthServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return "STATUS" + strconv.Itoa(int(s))
+	}
+}
+
+// GRPCStatusError is the error of an RPC completing with a non-OK gRPC status.
+type GRPCStatusError struct {
+	Code    int
+	Message string
+}
+
+// Error returns a string representation of the gRPC status.
+func (e *GRPCStatusError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.Code, e.Message)
+}
+
+// GRPCMetadata contains the outcome of a GRPCHealthEndpoint call.
+type GRPCMetadata struct {
+	// Code and Message are the gRPC status of the call, Code being 0 for OK.
+	Code    int
+	Message string
+	// Status is the serving status reported by the server, when the call succeeded.
+	Status GRPCHealthStatus
+}
+
+const (
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+	grpcHealthWatchPath = "/grpc.health.v1.Health/Watch"
+	// grpcMaxMessageSize bounds the size of a message read, health responses being a few bytes
+	grpcMaxMessageSize = 1 << 20
+)
+
+// GRPCHealthEndpoint calls the standard gRPC health checking service, grpc.health.v1.Health, of
+// the target endpoint. The connect phases are found in the TCPConnect and TLSHandshake times of the
+// response, and the RPC latency in its ServerProcessing and RequestTimeTotal times. Implements the
+// WatcherTask interface and is meant for use in a Watcher.
+type GRPCHealthEndpoint struct {
+	// URL is the target, http:// for plaintext HTTP/2 and https:// for TLS, e.g.
+	// http://example.com:50051.
+	URL *url.URL
+	// Service is the name of the service to check, empty for the overall health of the server.
+	Service string
+	ID      string
+
+	// Watch makes the task call Watch instead of Check, reporting the first status streamed by the
+	// server before closing the stream.
+	Watch bool
+
+	// TransportControl facilitates DNS-bypass when non-nil.
+	TransportControl *TransportControl
+	// TLS configures client certificates, root CAs, versions and pinning when non-nil.
+	TLS *TLSConfig
+
+	client *http.Client
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// Close closes the idle connections of the GRPCHealthEndpoint.
+func (e *GRPCHealthEndpoint) Close() error {
+	if e.client != nil {
+		e.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Initialize sets up the GRPCHealthEndpoint to be able to send on its responses.
+func (e *GRPCHealthEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+
+	// gRPC requires HTTP/2, unencrypted with prior knowledge for plaintext endpoints
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Protocols = new(http.Protocols)
+	if e.URL.Scheme == "https" {
+		tr.Protocols.SetHTTP2(true)
+	} else {
+		tr.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if e.TransportControl != nil {
+		tr.DialContext = e.TransportControl.dialContext()
+	}
+	tlsConfig, err := clientTLSConfig(e.TLS, e.TransportControl, e.URL.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to build TLS config: %w", err)
+	}
+	if tlsConfig != nil {
+		tr.TLSClientConfig = tlsConfig
+	}
+	e.client = &http.Client{Transport: tr}
+
+	return nil
+}
+
+// Task returns a taskman.Task that calls the health service of the endpoint.
+func (e *GRPCHealthEndpoint) Task() taskman.Task {
+	return &grpcHealthCall{endpoint: e}
+}
+
+// Validate checks that the GRPCHealthEndpoint is ready to be initialized.
+func (e *GRPCHealthEndpoint) Validate() error {
+	if e.URL == nil {
+		return errors.New("URL is nil")
+	}
+	if e.URL.Scheme != "http" && e.URL.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", e.URL.Scheme)
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	if e.TransportControl != nil {
+		if err := e.TransportControl.validate(); err != nil {
+			return err
+		}
+	}
+	if e.TLS != nil {
+		if err := e.TLS.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcHealthCall is an implementation of taskman.Task that calls the health service of a
+// GRPCHealthEndpoint.
+type grpcHealthCall struct {
+	endpoint *GRPCHealthEndpoint
+}
+
+// Execute calls Check, or Watch, and sends the serving status as a response. A non-OK gRPC status,
+// or a serving status other than SERVING, is set as the response's error.
+func (c *grpcHealthCall) Execute() error {
+	// Clone the URL to avoid downstream mutation
+	urlClone := *c.endpoint.URL
+	path := grpcHealthCheckPath
+	if c.endpoint.Watch {
+		path = grpcHealthWatchPath
+	}
+	target := urlClone.JoinPath(path)
+
+	// TODO: move timeout to configuration
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 1. Send the request
+	body := grpcFrame(grpcHealthCheckRequest(c.endpoint.Service))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+	if err != nil {
+		c.endpoint.respChan <- errorResponse(err, c.endpoint.ID, c.endpoint.watcherID, &urlClone)
+		return err
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+
+	timestamps := &requestTimestamps{}
+	var remoteAddr net.Addr
+	var tlsState tls.ConnectionState
+	traceCtx, wrote := traceWrote(ctx)
+	request = request.WithContext(httptrace.WithClientTrace(traceCtx, traceRequest(timestamps, &remoteAddr, &tlsState, nil)))
+
+	response, err := c.endpoint.client.Do(request)
+	if err != nil {
+		c.endpoint.respChan <- errorResponse(err, c.endpoint.ID, c.endpoint.watcherID, &urlClone)
+		return err
+	}
+	defer response.Body.Close()
+	if !tlsState.HandshakeComplete && response.TLS != nil {
+		tlsState = *response.TLS
+	}
+
+	// 2. Read the health check response, and the status from the trailers
+	message, grpcMeta, err := readGRPCUnaryResponse(response, c.endpoint.Watch)
+	if err != nil {
+		c.endpoint.respChan <- errorResponse(err, c.endpoint.ID, c.endpoint.watcherID, &urlClone)
+		return err
+	}
+	timestamps.dataDone = time.Now()
+
+	// HTTP/2 writes the request from another goroutine, wait for it before reading the timestamps
+	select {
+	case <-wrote.wait():
+	case <-ctx.Done():
+		c.endpoint.respChan <- errorResponse(ctx.Err(), c.endpoint.ID, c.endpoint.watcherID, &urlClone)
+		return ctx.Err()
+	}
+
+	var respErr error
+	if grpcMeta.Code != 0 {
+		respErr = &GRPCStatusError{Code: grpcMeta.Code, Message: grpcMeta.Message}
+	} else {
+		grpcMeta.Status, err = parseGRPCHealthCheckResponse(message)
+		if err != nil {
+			respErr = fmt.Errorf("failed to decode health check response: %w", err)
+		} else if grpcMeta.Status != GRPCHealthServing {
+			respErr = fmt.Errorf("service %q is %s", c.endpoint.Service, grpcMeta.Status)
+		}
+	}
+
+	// 3. Send the response on the channel
+	c.endpoint.respChan <- WatcherResponse{
+		TaskID:    c.endpoint.ID,
+		WatcherID: c.endpoint.watcherID,
+		URL:       &urlClone,
+		Err:       respErr,
+		Payload: &GRPCTaskResponse{
+			remoteAddr: remoteAddr,
+			data:       message,
+			timestamps: *timestamps,
+			tlsInfo:    newTLSInfo(&tlsState),
+			grpc:       grpcMeta,
+		},
+	}
+
+	return nil
+}
+
+// readGRPCUnaryResponse reads the first message of a gRPC response, and the gRPC status. The rest
+// of the stream is read for the status in the trailers, unless firstOnly is set, in which case a
+// received message is considered an OK status.
+func readGRPCUnaryResponse(response *http.Response, firstOnly bool) ([]byte, GRPCMetadata, error) {
+	if response.StatusCode != http.StatusOK {
+		return nil, GRPCMetadata{}, fmt.Errorf("unexpected HTTP status %d", response.StatusCode)
+	}
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "application/grpc") {
+		return nil, GRPCMetadata{}, fmt.Errorf("unexpected content type %q", response.Header.Get("Content-Type"))
+	}
+
+	// A trailers-only response carries the status in the headers
+	if status := response.Header.Get("Grpc-Status"); status != "" {
+		meta, err := grpcStatus(status, response.Header.Get("Grpc-Message"))
+		return nil, meta, err
+	}
+
+	message, err := readGRPCMessage(response.Body)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, GRPCMetadata{}, fmt.Errorf("failed to read message: %w", err)
+	}
+	if firstOnly && message != nil {
+		return message, GRPCMetadata{}, nil
+	}
+	if _, err := io.Copy(io.Discard, response.Body); err != nil {
+		return nil, GRPCMetadata{}, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	status := response.Trailer.Get("Grpc-Status")
+	if status == "" {
+		return nil, GRPCMetadata{}, errors.New("missing grpc-status trailer")
+	}
+	meta, err := grpcStatus(status, response.Trailer.Get("Grpc-Message"))
+	return message, meta, err
+}
+
+// grpcStatus returns the metadata of a gRPC status and message from headers or trailers.
+func grpcStatus(status, message string) (GRPCMetadata, error) {
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return GRPCMetadata{}, fmt.Errorf("invalid grpc-status %q", status)
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return GRPCMetadata{Code: code, Message: message}, nil
+}
+
+// grpcFrame prefixes a message with the uncompressed gRPC length-prefixed message header.
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// readGRPCMessage reads a single length-prefixed message. Returns io.EOF if the stream ends before
+// a message starts.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > grpcMaxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the maximum of %d bytes", size, grpcMaxMessageSize)
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// grpcHealthCheckRequest encodes a grpc.health.v1.HealthCheckRequest, of which the service name is
+// field 1.
+func grpcHealthCheckRequest(service string) []byte {
+	if service == "" {
+		return []byte{}
+	}
+	b := []byte{1<<3 | 2} // Field 1, length-delimited
+	b = binary.AppendUvarint(b, uint64(len(service)))
+	return append(b, service...)
+}
+
+// parseGRPCHealthCheckResponse decodes a grpc.health.v1.HealthCheckResponse, of which the status
+// is field 1. Unknown fields are skipped.
+func parseGRPCHealthCheckResponse(message []byte) (GRPCHealthStatus, error) {
+	status := GRPCHealthUnknown
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errors.New("invalid field key")
+		}
+		message = message[n:]
+		field, wireType := key>>3, key&7
+
+		switch wireType {
+		case 0: // Varint
+			v, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errors.New("invalid varint")
+			}
+			message = message[n:]
+			if field == 1 {
+				status = GRPCHealthStatus(v)
+			}
+		case 1: // 64-bit
+			if len(message) < 8 {
+				return 0, errors.New("field out of bounds")
+			}
+			message = message[8:]
+		case 2: // Length-delimited
+			l, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < l {
+				return 0, errors.New("field out of bounds")
+			}
+			message = message[n+int(l):]
+		case 5: // 32-bit
+			if len(message) < 4 {
+				return 0, errors.New("field out of bounds")
+			}
+			message = message[4:]
+		default:
+			return 0, fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+	return status, nil
+}
+
+// NewGRPCHealthEndpoint creates a new GRPCHealthEndpoint with the given attributes.
+func NewGRPCHealthEndpoint(u *url.URL, service string, id string) *GRPCHealthEndpoint {
+	return &GRPCHealthEndpoint{
+		URL:     u,
+		Service: service,
+		ID:      id,
+	}
+}
+
+//
+// GRPCTaskResponse
+//
+
+// GRPCTaskResponse is a TaskResponse for gRPC health checks. Its data is the encoded
+// HealthCheckResponse message.
+type GRPCTaskResponse struct {
+	remoteAddr net.Addr
+	data       []byte
+	timestamps requestTimestamps
+	tlsInfo    *TLSInfo
+	grpc       GRPCMetadata
+}
+
+// Close is a no-op, the stream is closed after the response is read.
+func (g *GRPCTaskResponse) Close() error {
+	return nil
+}
+
+// Data returns the encoded HealthCheckResponse message.
+func (g *GRPCTaskResponse) Data() ([]byte, error) {
+	return g.data, nil
+}
+
+// Reader returns an io.ReadCloser for the data. Closing is a no-op.
+func (g *GRPCTaskResponse) Reader() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(g.data)), nil
+}
+
+// Metadata returns metadata connected to the response.
+func (g *GRPCTaskResponse) Metadata() TaskResponseMetadata {
+	grpc := g.grpc
+	return TaskResponseMetadata{
+		RemoteAddr: g.remoteAddr,
+		Size:       int64(len(g.data)),
+		TimeData:   TimeDataFromTimestamps(g.timestamps),
+		TLS:        g.tlsInfo,
+		GRPC:       &grpc,
+	}
+}
@@ -0,0 +1,222 @@
+package wadjit
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// grpcHealthHandler serves grpc.health.v1.Health with the serving status of each service. Services
+// not in the map get a trailers-only NOT_FOUND status.
+func grpcHealthHandler(services map[string]GRPCHealthStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		message, err := readGRPCMessage(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The request's only field is the length-delimited service name
+		var service string
+		if len(message) > 0 {
+			l, n := binary.Uvarint(message[1:])
+			service = string(message[1+n : 1+n+int(l)])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		status, ok := services[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown%20service")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(grpcFrame([]byte{1 << 3, byte(status)}))
+
+		if r.URL.Path == grpcHealthWatchPath {
+			// Keep the stream open until the client goes away
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}
+}
+
+// grpcHealthServer starts a plaintext HTTP/2 server serving the health service.
+func grpcHealthServer(services map[string]GRPCHealthStatus) *httptest.Server {
+	server := httptest.NewUnstartedServer(grpcHealthHandler(services))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	return server
+}
+
+// executeGRPCHealth executes a single call of the endpoint and returns the response.
+func executeGRPCHealth(t *testing.T, endpoint *GRPCHealthEndpoint) (WatcherResponse, error) {
+	t.Helper()
+	require.NoError(t, endpoint.Validate())
+	respChan := make(chan WatcherResponse, 1)
+	require.NoError(t, endpoint.Initialize("watcher", respChan))
+	defer endpoint.Close()
+
+	err := endpoint.Task().Execute()
+	select {
+	case resp := <-respChan:
+		return resp, err
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for response")
+	}
+	return WatcherResponse{}, err
+}
+
+func TestGRPCHealthEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &GRPCHealthEndpoint{}
+}
+
+func TestGRPCHealthEndpointValidate(t *testing.T) {
+	endpoint := &GRPCHealthEndpoint{}
+	assert.Error(t, endpoint.Validate(), "expected error for nil URL")
+
+	endpoint = &GRPCHealthEndpoint{URL: &url.URL{Scheme: "grpc", Host: "example.com:50051"}}
+	assert.Error(t, endpoint.Validate(), "expected error for unsupported scheme")
+
+	endpoint = NewGRPCHealthEndpoint(&url.URL{Scheme: "http", Host: "example.com:50051"}, "", "")
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+}
+
+func TestGRPCHealthEndpointCheck(t *testing.T) {
+	server := grpcHealthServer(map[string]GRPCHealthStatus{
+		"":        GRPCHealthServing,
+		"db":      GRPCHealthNotServing,
+		"pending": GRPCHealthUnknown,
+	})
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	t.Run("serving", func(t *testing.T) {
+		resp, err := executeGRPCHealth(t, NewGRPCHealthEndpoint(u, "", "check"))
+		require.NoError(t, err)
+		require.NoError(t, resp.Err)
+		assert.Equal(t, "check", resp.TaskID)
+
+		md := resp.Metadata()
+		require.NotNil(t, md.GRPC)
+		assert.Equal(t, 0, md.GRPC.Code)
+		assert.Equal(t, GRPCHealthServing, md.GRPC.Status)
+		assert.NotNil(t, md.RemoteAddr)
+		assert.Nil(t, md.TLS)
+		require.NotNil(t, md.TimeData.TCPConnect)
+		assert.Positive(t, *md.TimeData.TCPConnect)
+		require.NotNil(t, md.TimeData.RequestTimeTotal)
+		assert.Positive(t, *md.TimeData.RequestTimeTotal)
+
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1 << 3, byte(GRPCHealthServing)}, data)
+	})
+
+	t.Run("not serving", func(t *testing.T) {
+		resp, err := executeGRPCHealth(t, NewGRPCHealthEndpoint(u, "db", "check"))
+		require.NoError(t, err)
+		assert.ErrorContains(t, resp.Err, "NOT_SERVING")
+		require.NotNil(t, resp.Payload)
+		assert.Equal(t, GRPCHealthNotServing, resp.Metadata().GRPC.Status)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		resp, err := executeGRPCHealth(t, NewGRPCHealthEndpoint(u, "pending", "check"))
+		require.NoError(t, err)
+		assert.ErrorContains(t, resp.Err, "UNKNOWN")
+		assert.Equal(t, GRPCHealthUnknown, resp.Metadata().GRPC.Status)
+	})
+
+	t.Run("service not found", func(t *testing.T) {
+		resp, err := executeGRPCHealth(t, NewGRPCHealthEndpoint(u, "missing", "check"))
+		require.NoError(t, err)
+
+		var statusErr *GRPCStatusError
+		require.True(t, errors.As(resp.Err, &statusErr))
+		assert.Equal(t, 5, statusErr.Code)
+		assert.Equal(t, "unknown service", statusErr.Message)
+		assert.Equal(t, 5, resp.Metadata().GRPC.Code)
+	})
+}
+
+func TestGRPCHealthEndpointWatch(t *testing.T) {
+	server := grpcHealthServer(map[string]GRPCHealthStatus{"api": GRPCHealthServing})
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	endpoint := NewGRPCHealthEndpoint(u, "api", "watch")
+	endpoint.Watch = true
+	resp, err := executeGRPCHealth(t, endpoint)
+	require.NoError(t, err)
+	require.NoError(t, resp.Err)
+	assert.Equal(t, GRPCHealthServing, resp.Metadata().GRPC.Status)
+}
+
+func TestGRPCHealthEndpointTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := httptest.NewUnstartedServer(grpcHealthHandler(map[string]GRPCHealthStatus{"": GRPCHealthServing}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pki.serverCert}}
+	server.StartTLS()
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	endpoint := NewGRPCHealthEndpoint(u, "", "tls")
+	endpoint.TLS = &TLSConfig{RootCAsPEM: pki.caPEM}
+	resp, err := executeGRPCHealth(t, endpoint)
+	require.NoError(t, err)
+	require.NoError(t, resp.Err)
+
+	md := resp.Metadata()
+	assert.Equal(t, GRPCHealthServing, md.GRPC.Status)
+	require.NotNil(t, md.TLS)
+	require.NotNil(t, md.TimeData.TLSHandshake)
+	assert.Positive(t, *md.TimeData.TLSHandshake)
+}
+
+func TestGRPCHealthMessages(t *testing.T) {
+	assert.Empty(t, grpcHealthCheckRequest(""))
+	assert.Equal(t, []byte{0x0a, 2, 'd', 'b'}, grpcHealthCheckRequest("db"))
+
+	frame := grpcFrame([]byte{1, 2, 3})
+	assert.Equal(t, []byte{0, 0, 0, 0, 3, 1, 2, 3}, frame)
+	message, err := readGRPCMessage(bytes.NewReader(frame))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, message)
+
+	// A length prefix beyond the maximum is refused before allocating
+	_, err = readGRPCMessage(bytes.NewReader([]byte{0, 0xff, 0xff, 0xff, 0xff}))
+	assert.ErrorContains(t, err, "exceeds the maximum")
+
+	// Unknown fields of each wire type are skipped
+	status, err := parseGRPCHealthCheckResponse([]byte{
+		2<<3 | 2, 1, 'x', // Field 2, length-delimited
+		3<<3 | 5, 0, 0, 0, 0, // Field 3, 32-bit
+		1 << 3, 2, // Field 1, status
+	})
+	require.NoError(t, err)
+	assert.Equal(t, GRPCHealthNotServing, status)
+
+	_, err = parseGRPCHealthCheckResponse([]byte{2<<3 | 2, 5, 'x'})
+	assert.Error(t, err, "expected error for truncated field")
+
+	assert.Equal(t, "SERVICE_UNKNOWN", GRPCHealthServiceUnknown.String())
+	assert.Equal(t, "STATUS9", GRPCHealthStatus(9).String())
+}
@@ -5,11 +5,15 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptrace"
-	"net/netip"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jkbrsn/go-taskman"
@@ -24,26 +28,167 @@ type HTTPEndpointOption func(*HTTPEndpoint)
 type HTTPEndpoint struct {
 	Header  http.Header
 	Method  string
+	Mode    HTTPEndpointMode
 	Payload []byte
-	URL     *url.URL
-	ID      string
+	// Protocol selects the HTTP version requests are made over. HTTPProtocolAuto leaves it to
+	// negotiation.
+	Protocol HTTPProtocol
+	URL      *url.URL
+	ID       string
 
 	// TransportControl facilitates DNS-bypass when non-nil.
 	TransportControl *TransportControl
-	client           *http.Client
+	// TLS configures client certificates, root CAs, versions and pinning when non-nil.
+	TLS *TLSConfig
+	// Proxy routes requests through an HTTP CONNECT or SOCKS5 proxy when non-nil.
+	Proxy *ProxyConfig
+	// Auth authenticates each request when non-nil. A cached token is dropped when a request is
+	// answered with 401 Unauthorized.
+	Auth Authenticator
+	// Redirects controls the redirects followed, up to 10 of any host when nil.
+	Redirects *RedirectPolicy
+	// ConnReuse controls the reuse of connections between requests. Connections are pooled per
+	// endpoint.
+	ConnReuse ConnReusePolicy
+	client    *http.Client
+	// requests counts the requests made, alternating connection reuse by it
+	requests *atomic.Uint64
 
 	// OptReadFast is a flag that, when set, makes the task execution read the response body into
 	// memory and close the body as soon as the full response has been received. This completes the
-	// request faster but buffers the body into memory.
-	// TODO: consider introducing a max-length option to limit this option for large responses.
+	// request faster but buffers the body into memory, see MaxBodySize to bound it.
 	OptReadFast bool
 
+	// MaxBodySize is the maximum number of response body bytes read, into memory or from the
+	// response's Reader. What happens beyond it is set by BodyLimit. No limit when 0.
+	MaxBodySize int64
+	// BodyLimit is the action taken when the response body exceeds MaxBodySize.
+	BodyLimit BodyLimitAction
+	// AcceptEncoding lists the content encodings to request, in order of preference, see the
+	// Encoding constants. Each must have a decoder, built-in for gzip and deflate only, and
+	// responses in an encoding with a decoder are read decoded. When nil, the transport requests
+	// and decodes gzip itself, and when empty, unencoded content is requested.
+	AcceptEncoding []string
+	// Decoders holds content decoders by encoding, in addition to the built-in gzip and deflate
+	// decoders. Requesting br or zstd requires a decoder to be supplied here.
+	Decoders map[string]ContentDecoder
+	// ContentHash enables the hashing of response bodies when non-nil, for the response metadata
+	// to flag changes in content between responses.
+	ContentHash *ContentHashConfig
+	content     *contentState
+	// Assertions are checked against the response body, which is read by the task execution when
+	// set. The first failure is set as the response's error.
+	Assertions []ByteAssertion
+	// Values holds the extractors of the values set on successful responses, by name. The
+	// response body is then read by the task execution, and a failed extraction is set as the
+	// response's error.
+	Values map[string]ValueExtractor
+	// Secrets enables secret references in the URL, headers and payload when non-nil, resolved by
+	// Initialize and redacted from the responses.
+	Secrets *SecretConfig
+	secrets *secretState
+	// Template renders the URL, headers and payload of each request when non-nil. The response
+	// body is then read by the task execution, to be available to the templates of the next. The
+	// URL template must keep the host of URL when TLS or TransportControl is set.
+	Template *RequestTemplate
+	template *templateState
+
 	watcherID string
 	respChan  chan<- WatcherResponse
 }
 
-// Close closes the HTTP endpoint.
+// HTTPEndpointMode is an enum for the mode of the HTTP endpoint.
+type HTTPEndpointMode int
+
+const (
+	// HTTP default mode sends the payload as-is, and leaves the response body unparsed.
+	HTTPModeDefault HTTPEndpointMode = iota
+	// HTTP JSON RPC mode requires the payload to be a JSON-RPC request, or batch of requests, and
+	// decodes the response body. The decoded responses are exposed in the response metadata, and a
+	// JSON-RPC error object in a single response is set as the response's error.
+	HTTPModeJSONRPC
+)
+
+// BodyLimitAction is an enum for the action taken when a response body exceeds the maximum body
+// size of an HTTPEndpoint.
+type BodyLimitAction int
+
+const (
+	// BodyLimitError fails the reading of the body with a *BodyTooLargeError.
+	BodyLimitError BodyLimitAction = iota
+	// BodyLimitTruncate keeps the bytes up to the limit and discards the rest, reading the body to
+	// its end for its true size.
+	BodyLimitTruncate
+	// BodyLimitHead keeps the bytes up to the limit and stops reading, for checks on the head of
+	// large bodies.
+	BodyLimitHead
+)
+
+// HTTPProtocol is an enum for the HTTP version an HTTPEndpoint makes requests over. HTTP/3 is not
+// supported.
+type HTTPProtocol int
+
+const (
+	// HTTPProtocolAuto uses HTTP/2 when negotiated with ALPN over TLS, and HTTP/1.1 otherwise.
+	HTTPProtocolAuto HTTPProtocol = iota
+	// HTTPProtocolHTTP1 forces HTTP/1.1, also over TLS.
+	HTTPProtocolHTTP1
+	// HTTPProtocolHTTP2 forces HTTP/2 over TLS, failing requests to servers not negotiating h2.
+	HTTPProtocolHTTP2
+	// HTTPProtocolH2C forces unencrypted HTTP/2 with prior knowledge, for http:// URLs.
+	HTTPProtocolH2C
+)
+
+// String returns the name of the protocol.
+func (p HTTPProtocol) String() string {
+	switch p {
+	case HTTPProtocolAuto:
+		return "auto"
+	case HTTPProtocolHTTP1:
+		return "http/1.1"
+	case HTTPProtocolHTTP2:
+		return "h2"
+	case HTTPProtocolH2C:
+		return "h2c"
+	default:
+		return "HTTPProtocol(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// protocols returns the http.Protocols of the protocol, nil for HTTPProtocolAuto.
+func (p HTTPProtocol) protocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	switch p {
+	case HTTPProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case HTTPProtocolHTTP2:
+		protocols.SetHTTP2(true)
+	case HTTPProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil
+	}
+	return protocols
+}
+
+// ConnReusePolicy is an enum for how an HTTPEndpoint reuses connections between requests.
+type ConnReusePolicy int
+
+const (
+	// ConnReuseAlways keeps connections alive, reusing an idle connection when there is one.
+	ConnReuseAlways ConnReusePolicy = iota
+	// ConnReuseNever makes every request on a new connection, measuring the full cold start.
+	ConnReuseNever
+	// ConnReuseAlternate alternates between requests on a new connection and requests reusing
+	// the connection of the previous one, starting with a new connection.
+	ConnReuseAlternate
+)
+
+// Close closes the idle connections of the HTTP endpoint.
 func (e *HTTPEndpoint) Close() error {
+	if e.client != nil {
+		e.client.CloseIdleConnections()
+	}
 	return nil
 }
 
@@ -52,36 +197,83 @@ func (e *HTTPEndpoint) Initialize(watcherID string, responseChannel chan<- Watch
 	e.watcherID = watcherID
 	e.respChan = responseChannel
 
-	if e.TransportControl == nil {
-		e.client = http.DefaultClient
-	} else {
-		tc := e.TransportControl
+	// Clone the default transport to keep sensible settings, the endpoint's connections being
+	// pooled apart from those of other endpoints
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if e.ConnReuse == ConnReuseNever {
+		tr.DisableKeepAlives = true
+	}
+	// Requested encodings are decoded by the task, not the transport
+	if e.AcceptEncoding != nil {
+		tr.DisableCompression = true
+	}
 
-		// Clone the default transport to keep sensible settings
-		tr := http.DefaultTransport.(*http.Transport).Clone()
+	// Override name–resolution only
+	if e.TransportControl != nil {
+		tr.DialContext = e.TransportControl.dialContext()
+	}
+
+	// Tunnel through the proxy, which takes the place of any proxy from the environment
+	if e.Proxy != nil {
+		tr.Proxy = nil
+		tr.DialContext = e.Proxy.dialContext(e.TransportControl)
+	}
+
+	// Optional TLS wrapping with correct SNI
+	tlsConfig, err := clientTLSConfig(e.TLS, e.TransportControl, e.URL.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to build TLS config: %w", err)
+	}
+	if tlsConfig != nil {
+		tr.TLSClientConfig = tlsConfig
+	}
+
+	// Restrict the transport to the selected protocol
+	tr.Protocols = e.Protocol.protocols()
 
-		// Override name–resolution only
-		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
-			// TODO: move timeout to configuration
-			d := &net.Dialer{Timeout: 5 * time.Second}
-			return d.DialContext(ctx, "tcp", tc.AddrPort.String())
+	e.client = &http.Client{
+		Transport:     tr,
+		CheckRedirect: e.Redirects.checkRedirect,
+	}
+	e.requests = new(atomic.Uint64)
+
+	if e.Template != nil {
+		if e.template, err = e.Template.compile(); err != nil {
+			return err
 		}
+	}
 
-		// Optional TLS wrapping with correct SNI
-		if tc.TLSEnabled {
-			tr.TLSClientConfig = &tls.Config{
-				ServerName:         e.URL.Hostname(),
-				InsecureSkipVerify: tc.SkipTLSVerify,
-			}
+	if e.Mode == HTTPModeJSONRPC {
+		if e.Header.Get("Content-Type") == "" {
+			e.Header.Set("Content-Type", "application/json")
+		}
+		if e.Method == "" {
+			e.Method = http.MethodPost
 		}
+	}
+
+	if e.ContentHash != nil {
+		e.content = &contentState{config: e.ContentHash}
+	}
 
-		e.client = &http.Client{Transport: tr}
+	if e.Secrets != nil {
+		if e.secrets, err = newSecretState(e.Secrets, e.URL, e.Header, e.Payload); err != nil {
+			return err
+		}
 	}
 
-	// TODO: set mode based on payload, e.g. JSON RPC, text ete.
 	return nil
 }
 
+// RotateSecrets resolves the secret references of the HTTP endpoint again, for rotated secrets to
+// be used by the next request. Does nothing if the endpoint has no SecretConfig.
+func (e *HTTPEndpoint) RotateSecrets() error {
+	if e.secrets == nil {
+		return nil
+	}
+	return e.secrets.resolve()
+}
+
 // Task returns a taskman.Task that sends an HTTP request to the endpoint.
 func (e *HTTPEndpoint) Task() taskman.Task {
 	return &httpRequest{
@@ -89,9 +281,15 @@ func (e *HTTPEndpoint) Task() taskman.Task {
 		respChan: e.respChan,
 		data:     e.Payload,
 		method:   e.Method,
+		mode:     e.Mode,
 	}
 }
 
+// setTemplateVars sets the variables the template of the HTTP endpoint is rendered with.
+func (e *HTTPEndpoint) setTemplateVars(vars map[string]string) {
+	e.template.setVars(vars)
+}
+
 // Validate checks that the HTTPEndpoint is ready to be initialized.
 func (e *HTTPEndpoint) Validate() error {
 	if e.URL == nil {
@@ -106,13 +304,130 @@ func (e *HTTPEndpoint) Validate() error {
 		e.ID = xid.New().String()
 	}
 	if e.TransportControl != nil {
-		if e.TransportControl.AddrPort == (netip.AddrPort{}) {
-			return errors.New("TransportControl.AddrPort is empty")
+		if err := e.TransportControl.validate(); err != nil {
+			return err
+		}
+	}
+	if e.TLS != nil {
+		if err := e.TLS.validate(); err != nil {
+			return err
+		}
+	}
+	if e.Proxy != nil {
+		if err := e.Proxy.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateAuth(e.Auth); err != nil {
+		return err
+	}
+	if e.Secrets != nil {
+		if err := e.Secrets.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateValueExtractors(e.Values); err != nil {
+		return err
+	}
+	if e.ContentHash != nil {
+		if err := e.ContentHash.validate(); err != nil {
+			return err
+		}
+	}
+	if e.Redirects != nil {
+		if err := e.Redirects.validate(); err != nil {
+			return err
+		}
+	}
+	if e.Template != nil {
+		if err := e.Template.validate(); err != nil {
+			return err
+		}
+		if err := e.Template.validateHost(e.URL, e.TLS, e.TransportControl); err != nil {
+			return err
+		}
+	}
+	for _, encoding := range e.AcceptEncoding {
+		if encoding == "" || strings.ContainsAny(encoding, ", ") {
+			return fmt.Errorf("invalid content encoding %q", encoding)
+		}
+		if !strings.EqualFold(encoding, "identity") && contentDecoder(encoding, e.Decoders) == nil {
+			return fmt.Errorf("no decoder for content encoding %q", encoding)
+		}
+	}
+	if e.MaxBodySize < 0 {
+		return errors.New("MaxBodySize is negative")
+	}
+	if e.BodyLimit < BodyLimitError || e.BodyLimit > BodyLimitHead {
+		return fmt.Errorf("unsupported body limit action %d", e.BodyLimit)
+	}
+	if e.ConnReuse < ConnReuseAlways || e.ConnReuse > ConnReuseAlternate {
+		return fmt.Errorf("unsupported connection reuse policy %d", e.ConnReuse)
+	}
+	switch e.Protocol {
+	case HTTPProtocolAuto, HTTPProtocolHTTP1:
+	case HTTPProtocolHTTP2:
+		if e.URL.Scheme != "https" {
+			return errors.New("HTTP/2 requires an https URL, use h2c for http URLs")
+		}
+	case HTTPProtocolH2C:
+		if e.URL.Scheme != "http" {
+			return errors.New("h2c requires an http URL")
+		}
+	default:
+		return fmt.Errorf("unsupported protocol %s", e.Protocol)
+	}
+	// A payload template is only rendered, and decoded, at execution
+	if e.Mode == HTTPModeJSONRPC && (e.Template == nil || e.Template.Payload == "") {
+		if err := validateJSONRPCPayload(e.Payload); err != nil {
+			return fmt.Errorf("invalid JSON-RPC payload: %w", err)
 		}
 	}
 	return nil
 }
 
+// WithAcceptEncoding configures the HTTPEndpoint to request the provided content encodings, in
+// order of preference. Encodings other than gzip and deflate need a decoder, see WithDecoder.
+func WithAcceptEncoding(encodings ...string) HTTPEndpointOption {
+	// Keep the list non-nil, an empty one requests unencoded content
+	return func(ep *HTTPEndpoint) { ep.AcceptEncoding = append([]string{}, encodings...) }
+}
+
+// WithAssertions configures the HTTPEndpoint to check the provided assertions against the
+// response body.
+func WithAssertions(assertions ...ByteAssertion) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Assertions = assertions }
+}
+
+// WithAuth configures the HTTPEndpoint to authenticate its requests with the provided
+// Authenticator.
+func WithAuth(a Authenticator) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Auth = a }
+}
+
+// WithConnReuse configures the HTTPEndpoint to reuse connections according to the provided
+// policy.
+func WithConnReuse(policy ConnReusePolicy) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.ConnReuse = policy }
+}
+
+// WithContentHash configures the HTTPEndpoint to hash response bodies with the provided
+// configuration, flagging changes in content.
+func WithContentHash(c *ContentHashConfig) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.ContentHash = c }
+}
+
+// WithDecoder configures the HTTPEndpoint to decode content of the provided encoding with the
+// provided decoder.
+func WithDecoder(encoding string, decoder ContentDecoder) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) {
+		if ep.Decoders == nil {
+			ep.Decoders = make(map[string]ContentDecoder)
+		}
+		ep.Decoders[encoding] = decoder
+	}
+}
+
 // WithHeader configures the HTTPEndpoint to use the provided header.
 func WithHeader(h http.Header) HTTPEndpointOption {
 	return func(ep *HTTPEndpoint) { ep.Header = h }
@@ -123,17 +438,68 @@ func WithID(id string) HTTPEndpointOption {
 	return func(ep *HTTPEndpoint) { ep.ID = id }
 }
 
+// WithMaxBodySize configures the HTTPEndpoint to read at most size bytes of the response body,
+// taking the provided action beyond it.
+func WithMaxBodySize(size int64, action BodyLimitAction) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) {
+		ep.MaxBodySize = size
+		ep.BodyLimit = action
+	}
+}
+
+// WithMode configures the HTTPEndpoint to use the provided mode.
+func WithMode(mode HTTPEndpointMode) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Mode = mode }
+}
+
 // WithPayload configures the HTTPEndpoint to use the provided payload.
 func WithPayload(b []byte) HTTPEndpointOption {
 	return func(ep *HTTPEndpoint) { ep.Payload = b }
 }
 
+// WithProtocol configures the HTTPEndpoint to make requests over the provided protocol.
+func WithProtocol(p HTTPProtocol) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Protocol = p }
+}
+
+// WithProxy configures the HTTPEndpoint to make requests through the provided proxy.
+func WithProxy(p *ProxyConfig) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Proxy = p }
+}
+
+// WithRedirectPolicy configures the HTTPEndpoint to follow redirects according to the provided
+// policy.
+func WithRedirectPolicy(p *RedirectPolicy) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Redirects = p }
+}
+
 // WithReadFast configures the HTTPEndpoint to read the response body into memory and close
 // the body as soon as the full response is received.
 func WithReadFast() HTTPEndpointOption {
 	return func(ep *HTTPEndpoint) { ep.OptReadFast = true }
 }
 
+// WithSecrets configures the HTTPEndpoint to resolve secret references with the provided
+// configuration.
+func WithSecrets(c *SecretConfig) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Secrets = c }
+}
+
+// WithTemplate configures the HTTPEndpoint to render its requests with the provided template.
+func WithTemplate(t *RequestTemplate) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Template = t }
+}
+
+// WithValues configures the HTTPEndpoint to extract the provided values from its responses.
+func WithValues(values map[string]ValueExtractor) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.Values = values }
+}
+
+// WithTLSConfig configures the HTTPEndpoint to use the provided TLS configuration.
+func WithTLSConfig(cfg *TLSConfig) HTTPEndpointOption {
+	return func(ep *HTTPEndpoint) { ep.TLS = cfg }
+}
+
 // WithTransportControl configures the HTTPEndpoint to use the provided TransportControl.
 func WithTransportControl(tc *TransportControl) HTTPEndpointOption {
 	return func(ep *HTTPEndpoint) { ep.TransportControl = tc }
@@ -146,61 +512,174 @@ type httpRequest struct {
 
 	data   []byte
 	method string
+	mode   HTTPEndpointMode
 }
 
 // Execute sends an HTTP request to the endpoint.
 func (r httpRequest) Execute() error {
-	// Clone the URL to avoid downstream mutation
-	urlClone := *r.endpoint.URL
+	values, err := r.endpoint.secrets.values(r.endpoint.URL, r.endpoint.Header, r.data)
+	secrets := values.secrets
+	if err != nil {
+		r.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, redactURL(values.url, secrets))
+		return err
+	}
 
-	request, err := http.NewRequest(r.method, urlClone.String(), bytes.NewReader(r.data))
+	data := r.endpoint.template.next(r.endpoint.ID, r.endpoint.watcherID)
+	rendered, err := r.endpoint.template.render(data, values.url, values.header, values.payload)
+	// The URL of the response is a copy, with any secrets redacted
+	urlClone := redactURL(rendered.url, secrets)
 	if err != nil {
-		r.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, &urlClone)
+		err = redactError(err, secrets)
+		r.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, urlClone)
+		return err
+	}
+
+	request, err := http.NewRequest(r.method, rendered.url.String(), bytes.NewReader(rendered.payload))
+	if err != nil {
+		err = redactError(err, secrets)
+		r.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, urlClone)
 		return err
 	}
 
 	// Add tracing to the request
 	timestamps := &requestTimestamps{}
 	var remoteAddr net.Addr
-	trace := traceRequest(timestamps, &remoteAddr)
-	ctx := httptrace.WithClientTrace(request.Context(), trace)
+	var tlsState tls.ConnectionState
+	var connInfo httptrace.GotConnInfo
+	trace := traceRequest(timestamps, &remoteAddr, &tlsState, &connInfo)
+	ctx, wrote := traceWrote(request.Context())
+	if r.endpoint.Proxy != nil {
+		ctx = withProxyTimes(ctx, timestamps)
+	}
+	chain := &redirectChain{timestamps: timestamps, remoteAddr: &remoteAddr, wrote: wrote}
+	ctx = context.WithValue(ctx, redirectChainKey{}, chain)
+	ctx = httptrace.WithClientTrace(ctx, trace)
 	request = request.WithContext(ctx)
 
 	// Add headers to the request
-	for key, values := range r.endpoint.Header {
+	for key, values := range rendered.header {
 		for _, value := range values {
 			request.Header.Add(key, value)
 		}
 	}
 
+	if len(r.endpoint.AcceptEncoding) > 0 {
+		request.Header.Set("Accept-Encoding", strings.Join(r.endpoint.AcceptEncoding, ", "))
+	} else if r.endpoint.AcceptEncoding != nil {
+		request.Header.Set("Accept-Encoding", "identity")
+	}
+
+	if r.endpoint.Auth != nil {
+		// Token requests are made apart from the traced request
+		// TODO: move timeout to configuration
+		authCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := r.endpoint.Auth.Authenticate(authCtx, request, rendered.payload)
+		cancel()
+		if err != nil {
+			err = redactError(fmt.Errorf("failed to authenticate: %w", err), secrets)
+			r.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, urlClone)
+			return err
+		}
+	}
+
+	// Every other request of the alternating policy is made on a new connection
+	if r.endpoint.ConnReuse == ConnReuseAlternate && r.endpoint.requests.Add(1)%2 == 1 {
+		r.endpoint.client.CloseIdleConnections()
+	}
+
 	// Send the request
 	response, err := r.endpoint.client.Do(request)
 	if err != nil {
-		r.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, &urlClone)
+		err = redactError(err, secrets)
+		r.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, urlClone)
 		return err
 	}
 
+	// Drop a cached token that was refused, for the next request to get a new one
+	if invalidator, ok := r.endpoint.Auth.(tokenInvalidator); ok && response.StatusCode == http.StatusUnauthorized {
+		invalidator.invalidate()
+	}
+
+	// HTTP/2 writes the request from another goroutine, wait for it before reading the timestamps
+	if response.ProtoMajor == 2 {
+		<-wrote.wait()
+	}
+
 	// Create a task response
 	taskResponse := NewHTTPTaskResponse(remoteAddr, response)
 	taskResponse.timestamps = *timestamps
-	if r.endpoint.OptReadFast {
-		taskResponse.readBody()
+	// The handshake hook only fires for new connections, fall back to the state of a reused one
+	if !tlsState.HandshakeComplete && response.TLS != nil {
+		tlsState = *response.TLS
+	}
+	taskResponse.tlsInfo = newTLSInfo(&tlsState)
+	taskResponse.redirects = chain.hops
+	taskResponse.connReused = connInfo.Reused
+	taskResponse.connIdleTime = connInfo.IdleTime
+	taskResponse.secrets = secrets
+	if r.endpoint.AcceptEncoding != nil || r.endpoint.Decoders != nil {
+		taskResponse.decodeBody(r.endpoint.Decoders)
+	}
+	if r.endpoint.MaxBodySize > 0 {
+		taskResponse.limitBody(r.endpoint.MaxBodySize, r.endpoint.BodyLimit)
+	}
+	if r.endpoint.content != nil {
+		taskResponse.hashBody(r.endpoint.content)
+	}
+	readBody := r.endpoint.OptReadFast || r.mode == HTTPModeJSONRPC || len(r.endpoint.Assertions) > 0 ||
+		len(r.endpoint.Values) > 0 || r.endpoint.template != nil
+	if readBody {
+		taskResponse.once.Do(taskResponse.readBody)
+	}
+
+	// Decode the JSON-RPC response, surfacing a JSON-RPC error as the response's error
+	var respErr error
+	if r.mode == HTTPModeJSONRPC {
+		respErr = taskResponse.decodeJSONRPC()
+	} else if readBody {
+		respErr = taskResponse.dataErr
+	}
+	if respErr == nil && len(r.endpoint.Assertions) > 0 {
+		respErr = checkAssertions(r.endpoint.Assertions, taskResponse.data)
 	}
+	var extracted map[string]Value
+	if respErr == nil {
+		extracted, respErr = extractValues(r.endpoint.Values, taskResponse)
+		extracted = redactValues(extracted, secrets)
+	}
+	// A redirect not followed by policy takes precedence, as it is the redirect that was decoded
+	if chain.err != nil {
+		respErr = chain.err
+	}
+	r.endpoint.template.setPrevious(&PreviousResponse{
+		StatusCode: response.StatusCode,
+		Header:     response.Header,
+		Body:       taskResponse.data,
+		Err:        respErr,
+	})
 
 	// Send the response on the channel
 	r.respChan <- WatcherResponse{
 		TaskID:    r.endpoint.ID,
 		WatcherID: r.endpoint.watcherID,
-		URL:       &urlClone,
-		Err:       nil,
+		URL:       urlClone,
+		Err:       redactError(respErr, secrets),
 		Payload:   taskResponse,
+		Values:    extracted,
 	}
 
 	return nil
 }
 
-// traceRequest traces the HTTP request and stores the timestamps in the provided times.
-func traceRequest(times *requestTimestamps, addr *net.Addr) *httptrace.ClientTrace {
+// traceRequest traces the HTTP request and stores the timestamps in the provided times, the state
+// of a completed TLS handshake in tlsState, and the info of the connection obtained in connInfo
+// when non-nil.
+func traceRequest(
+	times *requestTimestamps,
+	addr *net.Addr,
+	tlsState *tls.ConnectionState,
+	connInfo *httptrace.GotConnInfo,
+) *httptrace.ClientTrace {
 	return &httptrace.ClientTrace{
 		// The earliest guaranteed callback is usually GetConn, so we set the start time there
 		GetConn: func(string) { times.start = time.Now() },
@@ -208,18 +687,74 @@ func traceRequest(times *requestTimestamps, addr *net.Addr) *httptrace.ClientTra
 			if info.Conn != nil && addr != nil {
 				*addr = info.Conn.RemoteAddr()
 			}
+			if connInfo != nil {
+				*connInfo = info
+			}
+		},
+		DNSStart:          func(httptrace.DNSStartInfo) { times.dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { times.dnsDone = time.Now() },
+		ConnectStart:      func(_, _ string) { times.connStart = time.Now() },
+		ConnectDone:       func(_, _ string, _ error) { times.connDone = time.Now() },
+		TLSHandshakeStart: func() { times.tlsStart = time.Now() },
+		TLSHandshakeDone: func(cs tls.ConnectionState, _ error) {
+			times.tlsDone = time.Now()
+			if tlsState != nil {
+				*tlsState = cs
+			}
 		},
-		DNSStart:             func(httptrace.DNSStartInfo) { times.dnsStart = time.Now() },
-		DNSDone:              func(httptrace.DNSDoneInfo) { times.dnsDone = time.Now() },
-		ConnectStart:         func(_, _ string) { times.connStart = time.Now() },
-		ConnectDone:          func(_, _ string, _ error) { times.connDone = time.Now() },
-		TLSHandshakeStart:    func() { times.tlsStart = time.Now() },
-		TLSHandshakeDone:     func(_ tls.ConnectionState, _ error) { times.tlsDone = time.Now() },
 		WroteRequest:         func(httptrace.WroteRequestInfo) { times.wroteDone = time.Now() },
 		GotFirstResponseByte: func() { times.firstByte = time.Now() },
 	}
 }
 
+// traceWrote returns a context tracing when the request has been written, by closing the channel of
+// the returned wroteSignal. Any trace added to the context later is called before it is closed.
+func traceWrote(ctx context.Context) (context.Context, *wroteSignal) {
+	wrote := newWroteSignal()
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) { wrote.done() },
+	}), wrote
+}
+
+// wroteSignal signals when a request has been written. It is reset for each redirect followed, for
+// the signal to be that of the last request written.
+type wroteSignal struct {
+	mu     sync.Mutex
+	ch     chan struct{}
+	closed bool
+}
+
+// newWroteSignal creates a new wroteSignal.
+func newWroteSignal() *wroteSignal {
+	return &wroteSignal{ch: make(chan struct{})}
+}
+
+// done closes the channel of the current request, once. Retried HTTP/2 requests are written, and
+// traced, again.
+func (s *wroteSignal) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		close(s.ch)
+		s.closed = true
+	}
+}
+
+// wait returns the channel closed when the current request has been written.
+func (s *wroteSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+// reset replaces the channel for the next request.
+func (s *wroteSignal) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ch = make(chan struct{})
+	s.closed = false
+}
+
 // NewHTTPEndpoint creates a new HTTPEndpoint with the given attributes.
 func NewHTTPEndpoint(
 	u *url.URL,
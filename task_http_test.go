@@ -1,6 +1,8 @@
 package wadjit
 
 import (
+	"bytes"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -189,6 +191,85 @@ func TestHTTPEndpointExecuteMethods(t *testing.T) {
 	}
 }
 
+func TestHTTPEndpointExecuteJSONRPC(t *testing.T) {
+	server := jsonRPCServer()
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	execute := func(t *testing.T, payload string) WatcherResponse {
+		endpoint := NewHTTPEndpoint(u, "", WithMode(HTTPModeJSONRPC), WithPayload([]byte(payload)))
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		assert.Equal(t, http.MethodPost, endpoint.Method)
+		assert.Equal(t, "application/json", endpoint.Header.Get("Content-Type"))
+
+		require.NoError(t, endpoint.Task().Execute())
+		select {
+		case resp := <-responseChan:
+			return resp
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+		return WatcherResponse{}
+	}
+
+	t.Run("result", func(t *testing.T) {
+		resp := execute(t, `{"jsonrpc":"2.0","id":1,"method":"echo"}`)
+		require.NoError(t, resp.Err)
+		md := resp.Metadata()
+		require.Len(t, md.JSONRPCResults, 1)
+		assert.EqualValues(t, 1, md.JSONRPCResults[0].ID)
+		assert.NotEmpty(t, md.JSONRPCResults[0].Result)
+		assert.Nil(t, md.JSONRPCResults[0].Error)
+		assert.NotNil(t, md.TimeData.RequestTimeTotal, "body is read when decoding")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		resp := execute(t, `{"jsonrpc":"2.0","id":1,"method":"fail"}`)
+		var rpcErr *JSONRPCError
+		require.ErrorAs(t, resp.Err, &rpcErr)
+		assert.Equal(t, -32000, rpcErr.Code)
+		assert.Equal(t, "failure", rpcErr.Message)
+		assert.Equal(t, http.StatusOK, resp.Metadata().StatusCode)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		resp := execute(t, `[{"jsonrpc":"2.0","id":1,"method":"echo"},{"jsonrpc":"2.0","id":2,"method":"fail"}]`)
+		require.NoError(t, resp.Err, "batch element errors are surfaced per element")
+		md := resp.Metadata()
+		require.Len(t, md.JSONRPCResults, 2)
+		assert.Nil(t, md.JSONRPCResults[0].Error)
+		require.NotNil(t, md.JSONRPCResults[1].Error)
+		assert.Equal(t, -32000, md.JSONRPCResults[1].Error.Code)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		endpoint := NewHTTPEndpoint(u, http.MethodPost, WithMode(HTTPModeJSONRPC), WithPayload([]byte(`{"id":1}`)))
+		assert.Error(t, endpoint.Validate())
+		endpoint = NewHTTPEndpoint(u, http.MethodPost, WithMode(HTTPModeJSONRPC))
+		assert.Error(t, endpoint.Validate())
+	})
+
+	t.Run("non JSON-RPC response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(echoHandler))
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		endpoint := NewHTTPEndpoint(u, http.MethodGet, WithMode(HTTPModeJSONRPC),
+			WithPayload([]byte(`{"jsonrpc":"2.0","id":1,"method":"echo"}`)))
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		require.NoError(t, endpoint.Task().Execute())
+
+		resp := <-responseChan
+		assert.ErrorContains(t, resp.Err, "failed to decode JSON-RPC response")
+	})
+}
+
 func TestHTTPEndpoint_ResponseRemoteAddr(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(echoHandler))
 	defer server.Close()
@@ -334,6 +415,213 @@ func TestConnectionReuse(t *testing.T) {
 	assert.Equal(t, md1.RemoteAddr.String(), md2.RemoteAddr.String())
 }
 
+func TestHTTPEndpointProtocol(t *testing.T) {
+	h2cServer := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetHTTP1(true)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	h2Server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	h2Server.EnableHTTP2 = true
+	h2Server.StartTLS()
+	defer h2Server.Close()
+
+	h1Server := httptest.NewTLSServer(http.HandlerFunc(echoHandler))
+	defer h1Server.Close()
+
+	cases := []struct {
+		name      string
+		serverURL string
+		protocol  HTTPProtocol
+		wantProto string
+		wantTLS   bool
+		wantALPN  string
+		wantErr   bool
+	}{
+		{name: "auto cleartext", serverURL: h2cServer.URL, protocol: HTTPProtocolAuto, wantProto: "HTTP/1.1"},
+		{name: "h2c", serverURL: h2cServer.URL, protocol: HTTPProtocolH2C, wantProto: "HTTP/2.0"},
+		{name: "auto TLS", serverURL: h2Server.URL, protocol: HTTPProtocolAuto, wantProto: "HTTP/2.0", wantTLS: true, wantALPN: "h2"},
+		{name: "forced h2", serverURL: h2Server.URL, protocol: HTTPProtocolHTTP2, wantProto: "HTTP/2.0", wantTLS: true, wantALPN: "h2"},
+		{name: "forced HTTP/1.1", serverURL: h2Server.URL, protocol: HTTPProtocolHTTP1, wantProto: "HTTP/1.1", wantTLS: true},
+		{name: "forced h2 downgraded", serverURL: h1Server.URL, protocol: HTTPProtocolHTTP2, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.serverURL)
+			require.NoError(t, err)
+			ep := NewHTTPEndpoint(u, http.MethodGet, WithProtocol(tc.protocol), WithReadFast())
+			if u.Scheme == "https" {
+				ep.TLS = &TLSConfig{SkipVerify: true}
+			}
+			require.NoError(t, ep.Validate())
+			respCh := make(chan WatcherResponse, 1)
+			require.NoError(t, ep.Initialize("wid", respCh))
+
+			err = ep.Task().Execute()
+			resp := <-respCh
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.Error(t, resp.Err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, resp.Err)
+
+			md := resp.Metadata()
+			assert.Equal(t, tc.wantProto, md.Protocol)
+			if tc.wantTLS {
+				require.NotNil(t, md.TLS)
+				assert.Equal(t, tc.wantALPN, md.TLS.ALPN)
+			} else {
+				assert.Nil(t, md.TLS)
+			}
+		})
+	}
+
+	// Protocols must match the URL scheme
+	httpURL, _ := url.Parse("http://example.com")
+	httpsURL, _ := url.Parse("https://example.com")
+	assert.Error(t, NewHTTPEndpoint(httpURL, http.MethodGet, WithProtocol(HTTPProtocolHTTP2)).Validate())
+	assert.Error(t, NewHTTPEndpoint(httpsURL, http.MethodGet, WithProtocol(HTTPProtocolH2C)).Validate())
+	assert.Error(t, NewHTTPEndpoint(httpsURL, http.MethodGet, WithProtocol(HTTPProtocol(9))).Validate())
+}
+
+func TestHTTPEndpointConnReuse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		policy     ConnReusePolicy
+		wantReused []bool
+	}{
+		{name: "always", policy: ConnReuseAlways, wantReused: []bool{false, true, true, true}},
+		{name: "never", policy: ConnReuseNever, wantReused: []bool{false, false, false, false}},
+		{name: "alternate", policy: ConnReuseAlternate, wantReused: []bool{false, true, false, true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ep := NewHTTPEndpoint(u, http.MethodGet, WithConnReuse(tc.policy), WithReadFast())
+			require.NoError(t, ep.Validate())
+			respCh := make(chan WatcherResponse, 1)
+			require.NoError(t, ep.Initialize("wid", respCh))
+			defer ep.Close()
+
+			for i, wantReused := range tc.wantReused {
+				require.NoError(t, ep.Task().Execute())
+				resp := <-respCh
+				require.NoError(t, resp.Err)
+				md := resp.Metadata()
+				assert.Equal(t, wantReused, md.ConnReused, "request %d", i)
+				if wantReused {
+					assert.Nil(t, md.TimeData.TCPConnect, "request %d", i)
+					assert.Positive(t, md.ConnIdleTime, "request %d", i)
+				} else {
+					assert.NotNil(t, md.TimeData.TCPConnect, "request %d", i)
+					assert.Zero(t, md.ConnIdleTime, "request %d", i)
+				}
+			}
+		})
+	}
+
+	t.Run("isolated per endpoint", func(t *testing.T) {
+		for range 2 {
+			ep := NewHTTPEndpoint(u, http.MethodGet, WithReadFast())
+			require.NoError(t, ep.Validate())
+			respCh := make(chan WatcherResponse, 1)
+			require.NoError(t, ep.Initialize("wid", respCh))
+			require.NoError(t, ep.Task().Execute())
+			resp := <-respCh
+			require.NoError(t, resp.Err)
+			assert.False(t, resp.Metadata().ConnReused)
+		}
+	})
+
+	ep := NewHTTPEndpoint(u, http.MethodGet, WithConnReuse(ConnReusePolicy(7)))
+	assert.Error(t, ep.Validate())
+}
+
+func TestHTTPEndpointMaxBodySize(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 100*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	execute := func(t *testing.T, opts ...HTTPEndpointOption) WatcherResponse {
+		t.Helper()
+		ep := NewHTTPEndpoint(u, http.MethodGet, opts...)
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		require.NoError(t, ep.Task().Execute())
+		return <-respCh
+	}
+
+	t.Run("error", func(t *testing.T) {
+		resp := execute(t, WithMaxBodySize(1024, BodyLimitError), WithReadFast())
+		var tooLarge *BodyTooLargeError
+		require.ErrorAs(t, resp.Err, &tooLarge)
+		require.NotNil(t, resp.Payload)
+		md := resp.Metadata()
+		assert.True(t, md.Truncated)
+		assert.Less(t, md.TransferredSize, int64(len(body)))
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		resp := execute(t, WithMaxBodySize(1024, BodyLimitTruncate), WithReadFast())
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, body[:1024], data)
+		md := resp.Metadata()
+		assert.True(t, md.Truncated)
+		assert.Equal(t, int64(len(body)), md.TransferredSize)
+	})
+
+	t.Run("head with assertions", func(t *testing.T) {
+		resp := execute(t,
+			WithMaxBodySize(10, BodyLimitHead),
+			WithAssertions(ExpectEqual([]byte("0123456789"))),
+		)
+		require.NoError(t, resp.Err)
+		md := resp.Metadata()
+		assert.True(t, md.Truncated)
+		assert.Less(t, md.TransferredSize, int64(len(body)))
+
+		resp = execute(t,
+			WithMaxBodySize(10, BodyLimitHead),
+			WithAssertions(ExpectPrefix([]byte("9"))),
+		)
+		var assertionErr *AssertionError
+		assert.ErrorAs(t, resp.Err, &assertionErr)
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		resp := execute(t, WithMaxBodySize(1024, BodyLimitError))
+		require.NoError(t, resp.Err)
+		r, err := resp.Reader()
+		require.NoError(t, err)
+		defer r.Close()
+		_, err = io.ReadAll(r)
+		var tooLarge *BodyTooLargeError
+		assert.ErrorAs(t, err, &tooLarge)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		assert.Error(t, NewHTTPEndpoint(u, http.MethodGet, WithMaxBodySize(-1, BodyLimitError)).Validate())
+		assert.Error(t, NewHTTPEndpoint(u, http.MethodGet, WithMaxBodySize(1, BodyLimitAction(5))).Validate())
+	})
+}
+
 func TestNewHTTPEndpoint(t *testing.T) {
 	url, err := url.Parse("http://example.com")
 	assert.NoError(t, err, "failed to parse URL")
@@ -0,0 +1,270 @@
+package wadjit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// SequenceEndpoint is a single logical task running steps in order, e.g. logging in, extracting a
+// token and calling an API with it. Values extracted from the response of a step are set as
+// variables, available to the RequestTemplate of the later steps as {{ .Vars.name }}. The sequence
+// stops at the first step failing, and one response is sent per execution, with the payload of
+// the last step run and the results of all steps run in the response metadata. Implements the
+// WatcherTask interface and is meant for use in a Watcher.
+type SequenceEndpoint struct {
+	ID    string
+	Steps []SequenceStep
+	// Vars holds the variables each execution starts with.
+	Vars map[string]string
+
+	mu        sync.Mutex
+	stepChans []*awaitChan
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// SequenceStep is a step of a SequenceEndpoint.
+type SequenceStep struct {
+	// Name identifies the step in the results. Defaults to the index of the step.
+	Name string
+	// Task makes the request of the step, and must send a single response per execution, e.g.
+	// not a FanOutEndpoint or a WSEndpoint in a persistent mode. The Template of an HTTPEndpoint
+	// or WSEndpoint is rendered with the variables extracted by the previous steps.
+	Task WatcherTask
+	// Extract holds the extractors of the variables set from the step's response, by name. A
+	// failed extraction fails the step.
+	Extract map[string]Extractor
+}
+
+// SequenceMetadata is the metadata added to responses of a SequenceEndpoint.
+type SequenceMetadata struct {
+	// Steps holds the results of the steps run, in order.
+	Steps []SequenceStepResult
+	// Failed is the index of the step that failed, -1 if none did.
+	Failed int
+	// Duration is the total time of the steps run.
+	Duration time.Duration
+}
+
+// SequenceStepResult is the result of a step of a SequenceEndpoint.
+type SequenceStepResult struct {
+	Name string
+	// Err is the error of the step's response, or of an extraction from it.
+	Err error
+	// Duration is the time from the start of the step until its response was received and its
+	// variables extracted.
+	Duration time.Duration
+	// Metadata is the metadata of the step's response.
+	Metadata TaskResponseMetadata
+}
+
+// templatedTask is a WatcherTask rendering its requests with a RequestTemplate, with variables
+// set by a SequenceEndpoint.
+type templatedTask interface {
+	setTemplateVars(vars map[string]string)
+}
+
+// Close closes the tasks of all steps.
+func (e *SequenceEndpoint) Close() error {
+	var errs error
+	for i := range e.Steps {
+		if err := e.Steps[i].Task.Close(); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// Initialize sets up the SequenceEndpoint to be able to send on its responses, initializing the
+// tasks of all steps.
+func (e *SequenceEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+	e.stepChans = make([]*awaitChan, len(e.Steps))
+	for i := range e.Steps {
+		e.stepChans[i] = newAwaitChan()
+		if err := e.Steps[i].Task.Initialize(watcherID, e.stepChans[i].responses); err != nil {
+			return fmt.Errorf("failed to initialize step %s: %w", e.Steps[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Task returns a taskman.Task that runs the steps of the sequence.
+func (e *SequenceEndpoint) Task() taskman.Task {
+	return &sequenceRequest{endpoint: e}
+}
+
+// Validate checks that the SequenceEndpoint is ready to be initialized.
+func (e *SequenceEndpoint) Validate() error {
+	if len(e.Steps) == 0 {
+		return errors.New("Steps is empty")
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	for i := range e.Steps {
+		step := &e.Steps[i]
+		if step.Name == "" {
+			step.Name = strconv.Itoa(i)
+		}
+		if step.Task == nil {
+			return fmt.Errorf("Task of step %s is nil", step.Name)
+		}
+		if err := validateSingleResponse(step.Task); err != nil {
+			return fmt.Errorf("invalid step %s: %w", step.Name, err)
+		}
+		if err := step.Task.Validate(); err != nil {
+			return fmt.Errorf("invalid step %s: %w", step.Name, err)
+		}
+		for name, extractor := range step.Extract {
+			if name == "" || extractor == nil {
+				return fmt.Errorf("invalid extractor %q of step %s", name, step.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// runStep executes the task of a step with the given variables, and returns its response.
+// Note: the caller must hold the lock.
+func (e *SequenceEndpoint) runStep(i int, vars map[string]string) WatcherResponse {
+	step := e.Steps[i]
+	if task, ok := step.Task.(templatedTask); ok {
+		task.setTemplateVars(maps.Clone(vars))
+	}
+	return executeAwait(step.Task, e.stepChans[i], e.ID, e.watcherID)
+}
+
+// sequenceRequest is an implementation of taskman.Task that runs the steps of a SequenceEndpoint.
+type sequenceRequest struct {
+	endpoint *SequenceEndpoint
+}
+
+// Execute runs the steps in order, until the first failing, and sends the result of the sequence.
+func (r *sequenceRequest) Execute() error {
+	e := r.endpoint
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vars := maps.Clone(e.Vars)
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	metadata := SequenceMetadata{Failed: -1}
+	start := time.Now()
+
+	var last WatcherResponse
+	var respErr error
+	for i, step := range e.Steps {
+		stepStart := time.Now()
+		resp := e.runStep(i, vars)
+		stepErr := resp.Err
+		if stepErr == nil && resp.Payload == nil {
+			stepErr = errors.New("no payload")
+		}
+		if stepErr == nil {
+			for name, extractor := range step.Extract {
+				value, err := extractor(resp.Payload)
+				if err != nil {
+					stepErr = fmt.Errorf("failed to extract %q: %w", name, err)
+					break
+				}
+				vars[name] = value
+			}
+		}
+
+		result := SequenceStepResult{
+			Name:     step.Name,
+			Err:      stepErr,
+			Duration: time.Since(stepStart),
+			Metadata: resp.Metadata(),
+		}
+		metadata.Steps = append(metadata.Steps, result)
+
+		// Only the payload of the last step run is sent on
+		if last.Payload != nil {
+			last.Payload.Close()
+		}
+		last = resp
+		if stepErr != nil {
+			metadata.Failed = i
+			respErr = fmt.Errorf("step %s failed: %w", step.Name, stepErr)
+			break
+		}
+	}
+	metadata.Duration = time.Since(start)
+
+	e.respChan <- WatcherResponse{
+		TaskID:    e.ID,
+		WatcherID: e.watcherID,
+		URL:       last.URL,
+		Err:       respErr,
+		Payload:   &sequenceTaskResponse{TaskResponse: last.Payload, sequence: metadata},
+	}
+	return nil
+}
+
+// sequenceTaskResponse wraps the TaskResponse of the last step run by a SequenceEndpoint, adding
+// the sequence metadata. The wrapped TaskResponse is nil if the step got an error response.
+type sequenceTaskResponse struct {
+	TaskResponse
+	sequence SequenceMetadata
+}
+
+// Close closes the wrapped response.
+func (s *sequenceTaskResponse) Close() error {
+	if s.TaskResponse == nil {
+		return nil
+	}
+	return s.TaskResponse.Close()
+}
+
+// Data returns the data of the wrapped response.
+func (s *sequenceTaskResponse) Data() ([]byte, error) {
+	if s.TaskResponse == nil {
+		return nil, errors.New("no payload")
+	}
+	return s.TaskResponse.Data()
+}
+
+// Reader returns a reader for the data of the wrapped response.
+func (s *sequenceTaskResponse) Reader() (io.ReadCloser, error) {
+	if s.TaskResponse == nil {
+		return nil, errors.New("no payload")
+	}
+	return s.TaskResponse.Reader()
+}
+
+// Metadata returns the metadata of the wrapped response, with the sequence metadata set.
+func (s *sequenceTaskResponse) Metadata() TaskResponseMetadata {
+	var md TaskResponseMetadata
+	if s.TaskResponse != nil {
+		md = s.TaskResponse.Metadata()
+	}
+	sequence := s.sequence
+	md.Sequence = &sequence
+	return md
+}
+
+// NewSequenceEndpoint creates a new SequenceEndpoint with the given ID and steps.
+func NewSequenceEndpoint(id string, steps ...SequenceStep) *SequenceEndpoint {
+	return &SequenceEndpoint{
+		ID:    id,
+		Steps: steps,
+	}
+}
@@ -0,0 +1,187 @@
+package wadjit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &SequenceEndpoint{}
+}
+
+func TestSequenceEndpointValidate(t *testing.T) {
+	endpoint := &SequenceEndpoint{}
+	assert.Error(t, endpoint.Validate(), "expected error for no steps")
+
+	endpoint = NewSequenceEndpoint("", SequenceStep{})
+	assert.Error(t, endpoint.Validate(), "expected error for nil Task")
+
+	endpoint = NewSequenceEndpoint("", SequenceStep{Task: &MockWatcherTask{}, Extract: map[string]Extractor{"x": nil}})
+	assert.Error(t, endpoint.Validate(), "expected error for nil Extractor")
+
+	endpoint = NewSequenceEndpoint("", SequenceStep{Task: &FanOutEndpoint{Host: "localhost", Port: 80, NewTask: func(netip.AddrPort) WatcherTask { return nil }}})
+	assert.Error(t, endpoint.Validate(), "expected error for a task sending several responses")
+
+	endpoint = NewSequenceEndpoint("", SequenceStep{Task: &MockWatcherTask{}}, SequenceStep{Name: "b", Task: &MockWatcherTask{}})
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+	assert.Equal(t, "0", endpoint.Steps[0].Name)
+	assert.Equal(t, "b", endpoint.Steps[1].Name)
+}
+
+func TestSequenceEndpointExecute(t *testing.T) {
+	// The server issues a token on login, and requires it on the API
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Session", "session-1")
+			if string(body) == "user:secret" {
+				_, _ = w.Write([]byte(`{"token":"abc"}`))
+			} else {
+				_, _ = w.Write([]byte(`{"token":"invalid"}`))
+			}
+		case "/api":
+			if r.Header.Get("Authorization") != "Bearer abc" || r.Header.Get("X-Session") != "session-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte("denied"))
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	newSequence := func(t *testing.T, password string, extract map[string]Extractor) *SequenceEndpoint {
+		t.Helper()
+		loginURL, err := url.Parse(server.URL + "/login")
+		require.NoError(t, err)
+		apiURL, err := url.Parse(server.URL + "/api")
+		require.NoError(t, err)
+
+		login := NewHTTPEndpoint(loginURL, http.MethodPost, WithPayload([]byte("user:"+password)))
+		api := NewHTTPEndpoint(apiURL, http.MethodGet,
+			WithAssertions(ExpectEqual([]byte("ok"))),
+			WithTemplate(&RequestTemplate{Header: map[string]string{
+				"Authorization": "Bearer {{ .Vars.token }}",
+				"X-Session":     "{{ .Vars.session }}",
+			}}),
+		)
+		endpoint := NewSequenceEndpoint("sequence",
+			SequenceStep{Name: "login", Task: login, Extract: extract},
+			SequenceStep{Name: "api", Task: api},
+		)
+		require.NoError(t, endpoint.Validate())
+		return endpoint
+	}
+	extract := map[string]Extractor{"token": ExtractJSON("token"), "session": ExtractHeader("X-Session")}
+
+	execute := func(t *testing.T, endpoint *SequenceEndpoint) WatcherResponse {
+		t.Helper()
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("wid", respCh))
+		t.Cleanup(func() { endpoint.Close() })
+		require.NoError(t, endpoint.Task().Execute())
+		resp := <-respCh
+		assert.Equal(t, "sequence", resp.TaskID)
+		assert.Equal(t, "wid", resp.WatcherID)
+		return resp
+	}
+
+	t.Run("success", func(t *testing.T) {
+		endpoint := newSequence(t, "secret", extract)
+		// Every execution starts over, extracting the variables anew
+		for range 2 {
+			resp := execute(t, endpoint)
+			require.NoError(t, resp.Err)
+			data, err := resp.Data()
+			require.NoError(t, err)
+			assert.Equal(t, "ok", string(data))
+			assert.Equal(t, "/api", resp.URL.Path)
+
+			md := resp.Metadata()
+			require.NotNil(t, md.Sequence)
+			assert.Equal(t, -1, md.Sequence.Failed)
+			require.Len(t, md.Sequence.Steps, 2)
+			assert.Equal(t, "login", md.Sequence.Steps[0].Name)
+			assert.Equal(t, "api", md.Sequence.Steps[1].Name)
+			var total int64
+			for _, step := range md.Sequence.Steps {
+				assert.NoError(t, step.Err)
+				assert.Positive(t, step.Duration)
+				assert.Equal(t, http.StatusOK, step.Metadata.StatusCode)
+				total += int64(step.Duration)
+			}
+			assert.GreaterOrEqual(t, int64(md.Sequence.Duration), total)
+		}
+	})
+
+	t.Run("failed assertion", func(t *testing.T) {
+		resp := execute(t, newSequence(t, "wrong", extract))
+		var assertionErr *AssertionError
+		assert.ErrorAs(t, resp.Err, &assertionErr)
+
+		md := resp.Metadata()
+		require.NotNil(t, md.Sequence)
+		assert.Equal(t, 1, md.Sequence.Failed)
+		require.Len(t, md.Sequence.Steps, 2)
+		assert.Equal(t, http.StatusUnauthorized, md.Sequence.Steps[1].Metadata.StatusCode)
+	})
+
+	t.Run("failed extraction", func(t *testing.T) {
+		resp := execute(t, newSequence(t, "secret", map[string]Extractor{"token": ExtractJSON("missing")}))
+		assert.Error(t, resp.Err)
+
+		md := resp.Metadata()
+		require.NotNil(t, md.Sequence)
+		assert.Equal(t, 0, md.Sequence.Failed)
+		assert.Len(t, md.Sequence.Steps, 1, "expected the sequence to stop at the failed step")
+	})
+}
+
+// repeatingTask is a WatcherTask sending several responses per execution.
+type repeatingTask struct {
+	MockWatcherTask
+	responses int
+}
+
+func (r *repeatingTask) Task() taskman.Task {
+	return r
+}
+
+func (r *repeatingTask) Execute() error {
+	for range r.responses {
+		r.respChan <- WatcherResponse{TaskID: r.ID, Payload: &MockTaskResponse{data: []byte("ok")}}
+	}
+	return nil
+}
+
+func TestSequenceEndpointExtraResponses(t *testing.T) {
+	endpoint := NewSequenceEndpoint("", SequenceStep{Task: &repeatingTask{responses: 3}})
+	require.NoError(t, endpoint.Validate())
+	respCh := make(chan WatcherResponse, 1)
+	require.NoError(t, endpoint.Initialize("wid", respCh))
+
+	// The responses beyond the first are discarded, for the task not to block on sending them
+	for range 2 {
+		done := make(chan error, 1)
+		go func() { done <- endpoint.Task().Execute() }()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for execution")
+		}
+		resp := <-respCh
+		require.NoError(t, resp.Err)
+	}
+}
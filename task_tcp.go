@@ -0,0 +1,283 @@
+package wadjit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// defaultTCPReadSize is the maximum number of bytes read when reading up to a delimiter without a
+// ReadSize set.
+const defaultTCPReadSize = 64 * 1024
+
+// TCPEndpoint connects to the target endpoint over TCP, optionally with TLS, and optionally sends
+// a payload and reads a response, e.g. a banner. Implements the WatcherTask interface and is
+// meant for use in a Watcher.
+type TCPEndpoint struct {
+	// URL is the target, of which only the host and port are used, e.g. tcp://example.com:25.
+	URL     *url.URL
+	Payload []byte
+	ID      string
+
+	// ReadDelimiter makes the task read until the delimiter is received, which is included in the
+	// response data. Limited by ReadSize, or 64 KiB when ReadSize is zero, the response failing
+	// when the delimiter is not received within the limit.
+	ReadDelimiter []byte
+	// ReadSize makes the task read up to ReadSize bytes. No response is read when both ReadSize
+	// and ReadDelimiter are unset, and the task completes when the connection is established.
+	ReadSize int
+	// Assertions are checked against the response data, the first failure being set as the
+	// response's error.
+	Assertions []ByteAssertion
+
+	// TransportControl facilitates DNS-bypass when non-nil.
+	TransportControl *TransportControl
+	// TLS enables TLS when non-nil, an empty TLSConfig leaving the defaults in place.
+	TLS *TLSConfig
+
+	tlsConfig *tls.Config
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// Close closes the TCPEndpoint.
+func (e *TCPEndpoint) Close() error {
+	return nil
+}
+
+// Initialize sets up the TCPEndpoint to be able to send on its responses.
+func (e *TCPEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+
+	tlsConfig, err := clientTLSConfig(e.TLS, e.TransportControl, e.URL.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to build TLS config: %w", err)
+	}
+	e.tlsConfig = tlsConfig
+
+	return nil
+}
+
+// Task returns a taskman.Task that connects to the endpoint.
+func (e *TCPEndpoint) Task() taskman.Task {
+	return &tcpRequest{endpoint: e}
+}
+
+// Validate checks that the TCPEndpoint is ready to be initialized.
+func (e *TCPEndpoint) Validate() error {
+	if e.URL == nil {
+		return errors.New("URL is nil")
+	}
+	if e.URL.Hostname() == "" || e.URL.Port() == "" {
+		return errors.New("URL must have a host and a port")
+	}
+	if e.ReadSize < 0 {
+		return errors.New("ReadSize is negative")
+	}
+	if len(e.Assertions) > 0 && !e.reads() {
+		return errors.New("Assertions require ReadDelimiter or ReadSize to be set")
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	if e.TransportControl != nil {
+		if err := e.TransportControl.validate(); err != nil {
+			return err
+		}
+	}
+	if e.TLS != nil {
+		if err := e.TLS.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reads checks if the TCPEndpoint is configured to read a response.
+func (e *TCPEndpoint) reads() bool {
+	return e.ReadDelimiter != nil || e.ReadSize > 0
+}
+
+// tcpRequest is an implementation of taskman.Task that connects to a TCPEndpoint.
+type tcpRequest struct {
+	endpoint *TCPEndpoint
+}
+
+// Execute connects to the endpoint, and writes the payload and reads the response if configured.
+// A failed assertion is set as the response's error.
+func (r *tcpRequest) Execute() error {
+	// Clone the URL to avoid downstream mutation
+	urlClone := *r.endpoint.URL
+
+	// TODO: move timeout to configuration
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 1. Establish the connection
+	timestamps := requestTimestamps{}
+	conn, err := dialTraced(ctx, r.endpoint.TransportControl, urlClone.Host, &timestamps)
+	if err != nil {
+		r.endpoint.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, &urlClone)
+		return err
+	}
+	defer conn.Close()
+	remoteAddr := conn.RemoteAddr()
+
+	var tlsInfo *TLSInfo
+	if r.endpoint.tlsConfig != nil {
+		tlsConn, err := handshakeTraced(ctx, conn, r.endpoint.tlsConfig, &timestamps)
+		if err != nil {
+			r.endpoint.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, &urlClone)
+			return err
+		}
+		state := tlsConn.ConnectionState()
+		tlsInfo = newTLSInfo(&state)
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// 2. Write the payload
+	if len(r.endpoint.Payload) > 0 {
+		if _, err := conn.Write(r.endpoint.Payload); err != nil {
+			err = fmt.Errorf("failed to write payload: %w", err)
+			r.endpoint.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, &urlClone)
+			return err
+		}
+		timestamps.wroteDone = time.Now()
+	}
+
+	// 3. Read the response, or consider the established connection the response
+	var data []byte
+	var respErr error
+	if r.endpoint.reads() {
+		data, err = readTCPResponse(conn, r.endpoint.ReadDelimiter, r.endpoint.ReadSize, &timestamps)
+		if err != nil {
+			err = fmt.Errorf("failed to read response: %w", err)
+			r.endpoint.respChan <- errorResponse(err, r.endpoint.ID, r.endpoint.watcherID, &urlClone)
+			return err
+		}
+		respErr = checkAssertions(r.endpoint.Assertions, data)
+	} else {
+		timestamps.firstByte = time.Now()
+	}
+
+	// 4. Send the response on the channel
+	r.endpoint.respChan <- WatcherResponse{
+		TaskID:    r.endpoint.ID,
+		WatcherID: r.endpoint.watcherID,
+		URL:       &urlClone,
+		Err:       respErr,
+		Payload: &TCPTaskResponse{
+			remoteAddr: remoteAddr,
+			data:       data,
+			timestamps: timestamps,
+			tlsInfo:    tlsInfo,
+		},
+	}
+
+	return nil
+}
+
+// readTCPResponse reads from the connection until the delimiter is received, size bytes have been
+// read, or the connection is closed by the peer. Reaching size or the end of the connection
+// without receiving a set delimiter is an error. Stores the first byte and data done timestamps in
+// times.
+func readTCPResponse(conn net.Conn, delimiter []byte, size int, times *requestTimestamps) ([]byte, error) {
+	if size == 0 {
+		size = defaultTCPReadSize
+	}
+
+	data := make([]byte, 0, min(size, 4096))
+	buf := make([]byte, 4096)
+	found := false
+	for len(data) < size {
+		n, err := conn.Read(buf[:min(len(buf), size-len(data))])
+		if n > 0 && times.firstByte.IsZero() {
+			times.firstByte = time.Now()
+		}
+		data = append(data, buf[:n]...)
+
+		if delimiter != nil {
+			if i := bytes.Index(data, delimiter); i >= 0 {
+				data = data[:i+len(delimiter)]
+				found = true
+				break
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			if delimiter != nil {
+				return data, fmt.Errorf("connection closed before delimiter %q", delimiter)
+			}
+			break
+		}
+		if err != nil {
+			return data, err
+		}
+	}
+	times.dataDone = time.Now()
+	if delimiter != nil && !found {
+		return data, fmt.Errorf("delimiter %q not found within %d bytes", delimiter, size)
+	}
+
+	return data, nil
+}
+
+// NewTCPEndpoint creates a new TCPEndpoint with the given attributes.
+func NewTCPEndpoint(u *url.URL, payload []byte, id string) *TCPEndpoint {
+	return &TCPEndpoint{
+		URL:     u,
+		Payload: payload,
+		ID:      id,
+	}
+}
+
+//
+// TCPTaskResponse
+//
+
+// TCPTaskResponse is a TaskResponse for TCP connections, holding the response read, if any.
+type TCPTaskResponse struct {
+	remoteAddr net.Addr
+	data       []byte
+	timestamps requestTimestamps
+	tlsInfo    *TLSInfo
+}
+
+// Close is a no-op, the connection is closed after the response is read.
+func (t *TCPTaskResponse) Close() error {
+	return nil
+}
+
+// Data returns the response read from the connection.
+func (t *TCPTaskResponse) Data() ([]byte, error) {
+	return t.data, nil
+}
+
+// Reader returns an io.ReadCloser for the data. Closing is a no-op.
+func (t *TCPTaskResponse) Reader() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(t.data)), nil
+}
+
+// Metadata returns metadata connected to the response.
+func (t *TCPTaskResponse) Metadata() TaskResponseMetadata {
+	return TaskResponseMetadata{
+		RemoteAddr: t.remoteAddr,
+		Size:       int64(len(t.data)),
+		TimeData:   TimeDataFromTimestamps(t.timestamps),
+		TLS:        t.tlsInfo,
+	}
+}
@@ -0,0 +1,202 @@
+package wadjit
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bannerServer starts a TCP server that greets each connection with a banner line, and then echoes
+// back the lines it receives. Wraps the connections in TLS if tlsConfig is non-nil.
+func bannerServer(t *testing.T, tlsConfig *tls.Config) net.Listener {
+	t.Helper()
+	var listener net.Listener
+	var err error
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := conn.Write([]byte("220 wadjit ready\r\n")); err != nil {
+					return
+				}
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadBytes('\n')
+					if err != nil {
+						return
+					}
+					if _, err := conn.Write(line); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func TestTCPEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &TCPEndpoint{}
+}
+
+func TestTCPEndpointValidate(t *testing.T) {
+	endpoint := &TCPEndpoint{}
+	assert.Error(t, endpoint.Validate(), "expected error for nil URL")
+
+	endpoint = &TCPEndpoint{URL: &url.URL{Scheme: "tcp", Host: "example.com"}}
+	assert.Error(t, endpoint.Validate(), "expected error for URL without port")
+
+	endpoint = &TCPEndpoint{
+		URL:        &url.URL{Scheme: "tcp", Host: "example.com:25"},
+		Assertions: []ByteAssertion{ExpectPrefix([]byte("220"))},
+	}
+	assert.Error(t, endpoint.Validate(), "expected error for assertions without reading")
+
+	endpoint = &TCPEndpoint{URL: &url.URL{Scheme: "tcp", Host: "example.com:25"}, ReadSize: -1}
+	assert.Error(t, endpoint.Validate(), "expected error for negative ReadSize")
+
+	endpoint = NewTCPEndpoint(&url.URL{Scheme: "tcp", Host: "example.com:25"}, nil, "")
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+}
+
+func TestTCPEndpointExecute(t *testing.T) {
+	listener := bannerServer(t, nil)
+	defer listener.Close()
+	u := &url.URL{Scheme: "tcp", Host: listener.Addr().String()}
+
+	execute := func(t *testing.T, endpoint *TCPEndpoint) WatcherResponse {
+		t.Helper()
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		endpoint.Task().Execute()
+		select {
+		case resp := <-responseChan:
+			assert.Equal(t, endpoint.ID, resp.TaskID)
+			assert.Equal(t, "a-watcher-id", resp.WatcherID)
+			return resp
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+		return WatcherResponse{}
+	}
+
+	t.Run("connect only", func(t *testing.T) {
+		resp := execute(t, NewTCPEndpoint(u, nil, "an-id"))
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Empty(t, data)
+
+		md := resp.Metadata()
+		assert.Equal(t, listener.Addr().String(), md.RemoteAddr.String())
+		assert.NotNil(t, md.TimeData.TCPConnect)
+		assert.Nil(t, md.TimeData.TLSHandshake)
+		assert.NotZero(t, md.TimeData.Latency)
+	})
+
+	t.Run("banner", func(t *testing.T) {
+		endpoint := NewTCPEndpoint(u, nil, "an-id")
+		endpoint.ReadDelimiter = []byte("\r\n")
+		endpoint.Assertions = []ByteAssertion{ExpectPrefix([]byte("220 "))}
+		resp := execute(t, endpoint)
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "220 wadjit ready\r\n", string(data))
+		assert.Equal(t, int64(len(data)), resp.Metadata().Size)
+		assert.NotNil(t, resp.Metadata().TimeData.DataTransfer)
+	})
+
+	t.Run("payload and size", func(t *testing.T) {
+		endpoint := NewTCPEndpoint(u, []byte("PING\n"), "an-id")
+		endpoint.ReadSize = len("220 wadjit ready\r\nPING\n")
+		endpoint.Assertions = []ByteAssertion{ExpectMatch(regexp.MustCompile(`PING\n$`))}
+		resp := execute(t, endpoint)
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "220 wadjit ready\r\nPING\n", string(data))
+		assert.NotNil(t, resp.Metadata().TimeData.ServerProcessing)
+	})
+
+	t.Run("delimiter not found", func(t *testing.T) {
+		endpoint := NewTCPEndpoint(u, nil, "an-id")
+		endpoint.ReadDelimiter = []byte("\r\n")
+		endpoint.ReadSize = len("220 wadjit")
+		resp := execute(t, endpoint)
+		assert.ErrorContains(t, resp.Err, `delimiter "\r\n" not found within 10 bytes`)
+	})
+
+	t.Run("failed assertion", func(t *testing.T) {
+		endpoint := NewTCPEndpoint(u, nil, "an-id")
+		endpoint.ReadDelimiter = []byte("\r\n")
+		endpoint.Assertions = []ByteAssertion{ExpectContains([]byte("ESMTP"))}
+		resp := execute(t, endpoint)
+		var assertionErr *AssertionError
+		require.True(t, errors.As(resp.Err, &assertionErr), "expected an *AssertionError, got %v", resp.Err)
+		assert.Equal(t, []byte("220 wadjit ready\r\n"), assertionErr.Got)
+		// The received data is still available through the payload
+		require.NotNil(t, resp.Payload)
+		data, err := resp.Payload.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "220 wadjit ready\r\n", string(data))
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closedURL := &url.URL{Scheme: "tcp", Host: closed.Addr().String()}
+		closed.Close()
+
+		resp := execute(t, NewTCPEndpoint(closedURL, nil, "an-id"))
+		assert.Error(t, resp.Err)
+		assert.Nil(t, resp.Payload)
+	})
+}
+
+func TestTCPEndpointExecuteTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	listener := bannerServer(t, &tls.Config{Certificates: []tls.Certificate{pki.serverCert}})
+	defer listener.Close()
+
+	endpoint := NewTCPEndpoint(&url.URL{Scheme: "tcp", Host: listener.Addr().String()}, nil, "an-id")
+	endpoint.ReadDelimiter = []byte("\r\n")
+	endpoint.TLS = &TLSConfig{RootCAsPEM: pki.caPEM}
+	require.NoError(t, endpoint.Validate())
+	responseChan := make(chan WatcherResponse, 1)
+	require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+
+	require.NoError(t, endpoint.Task().Execute())
+	resp := <-responseChan
+	require.NoError(t, resp.Err)
+	data, err := resp.Data()
+	require.NoError(t, err)
+	assert.Equal(t, "220 wadjit ready\r\n", string(data))
+
+	md := resp.Metadata()
+	assert.NotNil(t, md.TimeData.TLSHandshake)
+	require.NotNil(t, md.TLS)
+	assert.Equal(t, "CN=127.0.0.1", md.TLS.Chain[0].Subject)
+}
@@ -0,0 +1,293 @@
+package wadjit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// TLSCertEndpoint performs a TLS handshake with the target endpoint, and checks the certificates
+// presented by the server for upcoming expiry. Implements the WatcherTask interface and is meant
+// for use in a Watcher.
+type TLSCertEndpoint struct {
+	// URL is the target, of which only the host and port are used. The port defaults to 443.
+	URL *url.URL
+	ID  string
+
+	// ExpiryThreshold is the minimum remaining validity of every certificate of the verified
+	// chain, or of the leaf when verification is skipped. A certificate expiring within the
+	// threshold is reported as a *CertExpiryError. Other certificates presented by the server,
+	// e.g. stale cross-signs, are not checked.
+	ExpiryThreshold time.Duration
+
+	// TransportControl facilitates DNS-bypass when non-nil.
+	TransportControl *TransportControl
+	// TLS configures client certificates, root CAs, versions and pinning when non-nil.
+	TLS *TLSConfig
+
+	tlsConfig *tls.Config
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// CertExpiryError is the error of a certificate expiring within the expiry threshold of a
+// TLSCertEndpoint, or having already expired.
+type CertExpiryError struct {
+	Subject  string
+	NotAfter time.Time
+	// Remaining is the validity left at the time of the check, negative for expired certificates.
+	Remaining time.Duration
+}
+
+// Error returns a string representation of the upcoming expiry.
+func (e *CertExpiryError) Error() string {
+	if e.Remaining <= 0 {
+		return fmt.Sprintf("certificate %q expired at %s", e.Subject, e.NotAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate %q expires in %s, at %s",
+		e.Subject, e.Remaining.Round(time.Second), e.NotAfter.Format(time.RFC3339))
+}
+
+// Close closes the TLSCertEndpoint.
+func (e *TLSCertEndpoint) Close() error {
+	return nil
+}
+
+// Initialize sets up the TLSCertEndpoint to be able to send on its responses.
+func (e *TLSCertEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+
+	tlsConfig, err := clientTLSConfig(e.TLS, e.TransportControl, e.URL.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to build TLS config: %w", err)
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: e.URL.Hostname()}
+	}
+	e.tlsConfig = tlsConfig
+
+	return nil
+}
+
+// Task returns a taskman.Task that checks the certificates of the endpoint.
+func (e *TLSCertEndpoint) Task() taskman.Task {
+	return &tlsCertCheck{endpoint: e}
+}
+
+// Validate checks that the TLSCertEndpoint is ready to be initialized.
+func (e *TLSCertEndpoint) Validate() error {
+	if e.URL == nil {
+		return errors.New("URL is nil")
+	}
+	if e.URL.Hostname() == "" {
+		return errors.New("URL has no host")
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	if e.TransportControl != nil {
+		if err := e.TransportControl.validate(); err != nil {
+			return err
+		}
+	}
+	if e.TLS != nil {
+		if err := e.TLS.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// address returns the host and port to connect to.
+func (e *TLSCertEndpoint) address() string {
+	port := e.URL.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(e.URL.Hostname(), port)
+}
+
+// tlsCertCheck is an implementation of taskman.Task that checks the certificates of a
+// TLSCertEndpoint.
+type tlsCertCheck struct {
+	endpoint *TLSCertEndpoint
+}
+
+// Execute performs the TLS handshake and checks the presented certificates. A completed handshake
+// is sent as a response, with expiry and verification errors set as the response's error.
+func (c *tlsCertCheck) Execute() error {
+	// Clone the URL to avoid downstream mutation
+	urlClone := *c.endpoint.URL
+
+	// TODO: move timeout to configuration
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The chain is verified after the handshake, so that an expired certificate is reported as
+	// such rather than as a failed handshake
+	tlsConfig := c.endpoint.tlsConfig.Clone()
+	verify := !tlsConfig.InsecureSkipVerify
+	tlsConfig.InsecureSkipVerify = true
+
+	timestamps := requestTimestamps{}
+	rawConn, err := dialTraced(ctx, c.endpoint.TransportControl, c.endpoint.address(), &timestamps)
+	if err != nil {
+		c.endpoint.respChan <- errorResponse(err, c.endpoint.ID, c.endpoint.watcherID, &urlClone)
+		return err
+	}
+	defer rawConn.Close()
+
+	conn, err := handshakeTraced(ctx, rawConn, tlsConfig, &timestamps)
+	if err != nil {
+		c.endpoint.respChan <- errorResponse(err, c.endpoint.ID, c.endpoint.watcherID, &urlClone)
+		return err
+	}
+	// The handshake completing is the response of a certificate check
+	timestamps.firstByte = timestamps.tlsDone
+
+	state := conn.ConnectionState()
+	taskResponse := &TLSTaskResponse{
+		remoteAddr: rawConn.RemoteAddr(),
+		chain:      state.PeerCertificates,
+		timestamps: timestamps,
+		tlsInfo:    newTLSInfo(&state),
+	}
+
+	// Verify the chain, and then check the expiry of its certificates, or of the leaf only when
+	// verification is skipped. A chain failing verification is reported as such only.
+	var respErr error
+	chain := state.PeerCertificates[:min(len(state.PeerCertificates), 1)]
+	if verify {
+		chain, respErr = verifyChain(state.PeerCertificates, tlsConfig)
+	}
+	if respErr == nil {
+		respErr = checkCertExpiry(chain, c.endpoint.ExpiryThreshold, time.Now())
+	}
+
+	c.endpoint.respChan <- WatcherResponse{
+		TaskID:    c.endpoint.ID,
+		WatcherID: c.endpoint.watcherID,
+		URL:       &urlClone,
+		Err:       respErr,
+		Payload:   taskResponse,
+	}
+
+	return nil
+}
+
+// checkCertExpiry returns a *CertExpiryError for each certificate expiring within the threshold
+// of now, joined, or nil if there are none.
+func checkCertExpiry(chain []*x509.Certificate, threshold time.Duration, now time.Time) error {
+	var errs error
+	for _, cert := range chain {
+		remaining := cert.NotAfter.Sub(now)
+		if remaining <= threshold {
+			errs = errors.Join(errs, &CertExpiryError{
+				Subject:   cert.Subject.String(),
+				NotAfter:  cert.NotAfter,
+				Remaining: remaining,
+			})
+		}
+	}
+	return errs
+}
+
+// verifyChain verifies the presented certificates against the root CAs and server name of the
+// config, and returns the verified chain. A chain only failing by expired certificates is verified
+// as of their expiry, for the expiry to be reported by checkCertExpiry instead.
+func verifyChain(certs []*x509.Certificate, tlsConfig *tls.Config) ([]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates presented")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         tlsConfig.RootCAs,
+		DNSName:       tlsConfig.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	for range len(certs) + 1 {
+		chains, err := certs[0].Verify(opts)
+		if err == nil {
+			return chains[0], nil
+		}
+		var invalidErr x509.CertificateInvalidError
+		if !errors.As(err, &invalidErr) || invalidErr.Reason != x509.Expired || invalidErr.Cert == nil ||
+			(!opts.CurrentTime.IsZero() && !invalidErr.Cert.NotAfter.Before(opts.CurrentTime)) {
+			return nil, fmt.Errorf("failed to verify certificate chain: %w", err)
+		}
+		opts.CurrentTime = invalidErr.Cert.NotAfter
+	}
+	return nil, errors.New("failed to verify certificate chain: expired certificates")
+}
+
+// NewTLSCertEndpoint creates a new TLSCertEndpoint with the given attributes.
+func NewTLSCertEndpoint(u *url.URL, expiryThreshold time.Duration, id string) *TLSCertEndpoint {
+	return &TLSCertEndpoint{
+		URL:             u,
+		ExpiryThreshold: expiryThreshold,
+		ID:              id,
+	}
+}
+
+//
+// TLSTaskResponse
+//
+
+// TLSTaskResponse is a TaskResponse for TLS certificate checks. Its data is the PEM encoded
+// certificate chain presented by the server.
+type TLSTaskResponse struct {
+	remoteAddr net.Addr
+	chain      []*x509.Certificate
+	timestamps requestTimestamps
+	tlsInfo    *TLSInfo
+}
+
+// Close is a no-op, the connection is closed after the check.
+func (t *TLSTaskResponse) Close() error {
+	return nil
+}
+
+// Data returns the PEM encoded certificate chain, leaf first.
+func (t *TLSTaskResponse) Data() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, cert := range t.chain {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Reader returns an io.ReadCloser for the data. Closing is a no-op.
+func (t *TLSTaskResponse) Reader() (io.ReadCloser, error) {
+	data, err := t.Data()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Metadata returns metadata connected to the response.
+func (t *TLSTaskResponse) Metadata() TaskResponseMetadata {
+	return TaskResponseMetadata{
+		RemoteAddr: t.remoteAddr,
+		TimeData:   TimeDataFromTimestamps(t.timestamps),
+		TLS:        t.tlsInfo,
+	}
+}
@@ -0,0 +1,239 @@
+package wadjit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSCertEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &TLSCertEndpoint{}
+}
+
+func TestTLSCertEndpointValidate(t *testing.T) {
+	endpoint := &TLSCertEndpoint{}
+	assert.Error(t, endpoint.Validate(), "expected error for nil URL")
+
+	endpoint = &TLSCertEndpoint{URL: &url.URL{Path: "/no-host"}}
+	assert.Error(t, endpoint.Validate(), "expected error for URL without host")
+
+	endpoint = &TLSCertEndpoint{URL: &url.URL{Host: "example.com"}, TLS: &TLSConfig{PinnedSPKI: []string{"invalid"}}}
+	assert.Error(t, endpoint.Validate(), "expected error for invalid TLS config")
+
+	endpoint = NewTLSCertEndpoint(&url.URL{Host: "example.com"}, 24*time.Hour, "")
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+	assert.Equal(t, "example.com:443", endpoint.address())
+}
+
+func TestTLSCertEndpointExecute(t *testing.T) {
+	pki := newTestPKI(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pki.serverCert}}
+	server.StartTLS()
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	execute := func(t *testing.T, endpoint *TLSCertEndpoint) WatcherResponse {
+		t.Helper()
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		endpoint.Task().Execute()
+		select {
+		case resp := <-responseChan:
+			assert.Equal(t, endpoint.ID, resp.TaskID)
+			return resp
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+		return WatcherResponse{}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		endpoint := NewTLSCertEndpoint(serverURL, time.Minute, "an-id")
+		endpoint.TLS = &TLSConfig{RootCAsPEM: pki.caPEM}
+		resp := execute(t, endpoint)
+		require.NoError(t, resp.Err)
+
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), "-----BEGIN CERTIFICATE-----"))
+
+		md := resp.Metadata()
+		require.NotNil(t, md.TLS)
+		require.Len(t, md.TLS.Chain, 1)
+		assert.Equal(t, "CN=127.0.0.1", md.TLS.Chain[0].Subject)
+		assert.NotNil(t, md.TimeData.TCPConnect)
+		assert.NotNil(t, md.TimeData.TLSHandshake)
+		assert.NotZero(t, md.TimeData.Latency)
+	})
+
+	t.Run("expiring", func(t *testing.T) {
+		// The test certificates are valid for an hour
+		endpoint := NewTLSCertEndpoint(serverURL, 2*time.Hour, "an-id")
+		endpoint.TLS = &TLSConfig{RootCAsPEM: pki.caPEM}
+		resp := execute(t, endpoint)
+		require.Error(t, resp.Err)
+
+		var expiryErr *CertExpiryError
+		require.True(t, errors.As(resp.Err, &expiryErr), "expected a *CertExpiryError, got %v", resp.Err)
+		assert.Equal(t, "CN=127.0.0.1", expiryErr.Subject)
+		assert.Greater(t, expiryErr.Remaining, time.Duration(0))
+		assert.Less(t, expiryErr.Remaining, 2*time.Hour)
+
+		// The chain is still reported
+		require.NotNil(t, resp.Metadata().TLS)
+	})
+
+	t.Run("unverified chain", func(t *testing.T) {
+		endpoint := NewTLSCertEndpoint(serverURL, time.Minute, "an-id")
+		resp := execute(t, endpoint)
+		assert.ErrorContains(t, resp.Err, "failed to verify certificate chain")
+
+		endpoint = NewTLSCertEndpoint(serverURL, time.Minute, "an-id")
+		endpoint.TLS = &TLSConfig{SkipVerify: true}
+		resp = execute(t, endpoint)
+		assert.NoError(t, resp.Err)
+	})
+
+	t.Run("transport control", func(t *testing.T) {
+		u := &url.URL{Host: "wadjit.test"}
+		endpoint := NewTLSCertEndpoint(u, time.Minute, "an-id")
+		endpoint.TLS = &TLSConfig{RootCAsPEM: pki.caPEM}
+		endpoint.TransportControl = &TransportControl{AddrPort: netip.MustParseAddrPort(serverURL.Host)}
+		resp := execute(t, endpoint)
+		assert.NoError(t, resp.Err)
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		closed := httptest.NewServer(http.HandlerFunc(echoHandler))
+		closedURL, err := url.Parse(closed.URL)
+		require.NoError(t, err)
+		closed.Close()
+
+		endpoint := NewTLSCertEndpoint(closedURL, time.Minute, "an-id")
+		resp := execute(t, endpoint)
+		assert.Error(t, resp.Err)
+		assert.Nil(t, resp.Payload)
+	})
+}
+
+func TestCheckCertExpiry(t *testing.T) {
+	now := time.Now()
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "leaf"}, NotAfter: now.Add(48 * time.Hour)}
+	intermediate := &x509.Certificate{Subject: pkix.Name{CommonName: "intermediate"}, NotAfter: now.Add(12 * time.Hour)}
+	expired := &x509.Certificate{Subject: pkix.Name{CommonName: "expired"}, NotAfter: now.Add(-time.Hour)}
+
+	assert.NoError(t, checkCertExpiry([]*x509.Certificate{leaf, intermediate}, time.Hour, now))
+
+	err := checkCertExpiry([]*x509.Certificate{leaf, intermediate}, 24*time.Hour, now)
+	var expiryErr *CertExpiryError
+	require.True(t, errors.As(err, &expiryErr))
+	assert.Equal(t, "CN=intermediate", expiryErr.Subject)
+	assert.Equal(t, 12*time.Hour, expiryErr.Remaining)
+	assert.Contains(t, err.Error(), "expires in 12h0m0s")
+
+	err = checkCertExpiry([]*x509.Certificate{expired}, 0, now)
+	assert.ErrorContains(t, err, `certificate "CN=expired" expired at`)
+}
+
+// issueTestCert issues a certificate from the template, signed by the parent's key, or self-signed
+// when parent is nil.
+func issueTestCert(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSCertEndpointCheckedChain(t *testing.T) {
+	pki := newTestPKI(t)
+	expiredCA := &x509.Certificate{
+		SerialNumber:          big.NewInt(10),
+		Subject:               pkix.Name{CommonName: "expired cross-sign"},
+		NotBefore:             time.Now().Add(-48 * time.Hour),
+		NotAfter:              time.Now().Add(-24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	execute := func(t *testing.T, cert tls.Certificate, cfg *TLSConfig) WatcherResponse {
+		t.Helper()
+		server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		server.StartTLS()
+		defer server.Close()
+		serverURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		endpoint := NewTLSCertEndpoint(serverURL, time.Minute, "an-id")
+		endpoint.TLS = cfg
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		endpoint.Task().Execute()
+		return <-responseChan
+	}
+
+	t.Run("expired certificate outside the chain", func(t *testing.T) {
+		// The server also sends an expired certificate that is not part of the verified path
+		cert := pki.serverCert
+		extra := issueTestCert(t, expiredCA, nil, nil)
+		cert.Certificate = append(slices.Clone(cert.Certificate), extra.Certificate[0])
+
+		resp := execute(t, cert, &TLSConfig{RootCAsPEM: pki.caPEM})
+		assert.NoError(t, resp.Err)
+		require.Len(t, resp.Metadata().TLS.Chain, 2, "expected the presented chain to be reported")
+
+		resp = execute(t, cert, &TLSConfig{SkipVerify: true})
+		assert.NoError(t, resp.Err)
+	})
+
+	t.Run("expired leaf", func(t *testing.T) {
+		leaf := issueTestCert(t, &x509.Certificate{
+			SerialNumber: big.NewInt(11),
+			Subject:      pkix.Name{CommonName: "expired leaf"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-48 * time.Hour),
+			NotAfter:     time.Now().Add(-time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, pki.caCert, pki.caKey)
+
+		// A single expiry error, rather than a verification error as well
+		resp := execute(t, leaf, &TLSConfig{RootCAsPEM: pki.caPEM})
+		var expiryErr *CertExpiryError
+		require.True(t, errors.As(resp.Err, &expiryErr), "expected a *CertExpiryError, got %v", resp.Err)
+		assert.Equal(t, "CN=expired leaf", expiryErr.Subject)
+		assert.NotContains(t, resp.Err.Error(), "failed to verify")
+		joined, ok := resp.Err.(interface{ Unwrap() []error })
+		require.True(t, ok)
+		assert.Len(t, joined.Unwrap(), 1)
+	})
+}
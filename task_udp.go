@@ -0,0 +1,310 @@
+package wadjit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jkbrsn/go-taskman"
+	"github.com/rs/xid"
+)
+
+// defaultUDPTimeout is the time to wait for a reply to a datagram when no Timeout is set.
+const defaultUDPTimeout = time.Second
+
+// maxUDPDatagramSize is the size of the buffer replies are read into.
+const maxUDPDatagramSize = 64 * 1024
+
+// UDPEndpoint sends a datagram to the target endpoint and waits for a reply, repeating the
+// exchange a number of times per execution to estimate packet loss. Implements the WatcherTask
+// interface and is meant for use in a Watcher.
+type UDPEndpoint struct {
+	// URL is the target, of which only the host and port are used, e.g. udp://example.com:53.
+	URL     *url.URL
+	Payload []byte
+	ID      string
+
+	// Attempts is the number of datagrams sent per execution, each from a new socket. Defaults
+	// to 1.
+	Attempts int
+	// Timeout is the time to wait for the reply to each datagram. Defaults to one second.
+	Timeout time.Duration
+	// Assertions are checked against the last reply received, the first failure being set as the
+	// response's error.
+	Assertions []ByteAssertion
+
+	// TransportControl facilitates DNS-bypass when non-nil. TLS settings are ignored.
+	TransportControl *TransportControl
+
+	watcherID string
+	respChan  chan<- WatcherResponse
+}
+
+// PacketStats contains the outcome of the datagram exchanges of a UDPEndpoint execution.
+type PacketStats struct {
+	Sent     int
+	Received int
+	// LossPercent is the percentage of datagrams sent without a reply within the timeout.
+	LossPercent float64
+
+	// RTTs holds the round-trip time of each reply received, in the order sent.
+	RTTs   []time.Duration
+	MinRTT time.Duration
+	AvgRTT time.Duration
+	MaxRTT time.Duration
+}
+
+// Close closes the UDPEndpoint.
+func (e *UDPEndpoint) Close() error {
+	return nil
+}
+
+// Initialize sets up the UDPEndpoint to be able to send on its responses.
+func (e *UDPEndpoint) Initialize(watcherID string, responseChannel chan<- WatcherResponse) error {
+	e.watcherID = watcherID
+	e.respChan = responseChannel
+
+	if e.Attempts == 0 {
+		e.Attempts = 1
+	}
+	if e.Timeout == 0 {
+		e.Timeout = defaultUDPTimeout
+	}
+
+	return nil
+}
+
+// Task returns a taskman.Task that probes the endpoint.
+func (e *UDPEndpoint) Task() taskman.Task {
+	return &udpProbe{endpoint: e}
+}
+
+// Validate checks that the UDPEndpoint is ready to be initialized.
+func (e *UDPEndpoint) Validate() error {
+	if e.URL == nil {
+		return errors.New("URL is nil")
+	}
+	if e.URL.Hostname() == "" || e.URL.Port() == "" {
+		return errors.New("URL must have a host and a port")
+	}
+	if _, err := strconv.ParseUint(e.URL.Port(), 10, 16); err != nil {
+		return fmt.Errorf("invalid port: %w", err)
+	}
+	if len(e.Payload) == 0 {
+		return errors.New("Payload is empty")
+	}
+	if e.Attempts < 0 {
+		return errors.New("Attempts is negative")
+	}
+	if e.Timeout < 0 {
+		return errors.New("Timeout is negative")
+	}
+	if e.ID == "" {
+		// Set random ID if nil
+		e.ID = xid.New().String()
+	}
+	if e.TransportControl != nil {
+		if err := e.TransportControl.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the address to send datagrams to, storing the DNS lookup timestamps in times
+// when the host is resolved.
+func (e *UDPEndpoint) resolve(ctx context.Context, times *requestTimestamps) (netip.AddrPort, error) {
+	if e.TransportControl != nil {
+		return e.TransportControl.AddrPort, nil
+	}
+	port, err := strconv.ParseUint(e.URL.Port(), 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port: %w", err)
+	}
+	if addr, err := netip.ParseAddr(e.URL.Hostname()); err == nil {
+		return netip.AddrPortFrom(addr, uint16(port)), nil
+	}
+
+	times.dnsStart = time.Now()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", e.URL.Hostname())
+	times.dnsDone = time.Now()
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("failed to resolve %q: %w", e.URL.Hostname(), err)
+	}
+	if len(addrs) == 0 {
+		return netip.AddrPort{}, fmt.Errorf("no addresses found for %q", e.URL.Hostname())
+	}
+	return netip.AddrPortFrom(addrs[0].Unmap(), uint16(port)), nil
+}
+
+// udpProbe is an implementation of taskman.Task that exchanges datagrams with a UDPEndpoint.
+type udpProbe struct {
+	endpoint *UDPEndpoint
+}
+
+// Execute sends the configured number of datagrams and waits for their replies. A response is
+// sent with the packet stats and the last reply, its error set if no reply was received or an
+// assertion failed. Except for the DNS lookup, the timing data is that of the attempt whose reply
+// is reported.
+func (p *udpProbe) Execute() error {
+	// Clone the URL to avoid downstream mutation
+	urlClone := *p.endpoint.URL
+
+	// TODO: move timeout to configuration
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	timestamps := requestTimestamps{}
+	addr, err := p.endpoint.resolve(ctx, &timestamps)
+	if err != nil {
+		p.endpoint.respChan <- errorResponse(err, p.endpoint.ID, p.endpoint.watcherID, &urlClone)
+		return err
+	}
+
+	stats := PacketStats{}
+	var reply []byte
+	var remoteAddr net.Addr
+	var lastErr error
+	for range p.endpoint.Attempts {
+		sentAt, data, raddr, err := exchangeDatagram(addr, p.endpoint.Payload, p.endpoint.Timeout)
+		stats.Sent++
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		receivedAt := time.Now()
+		timestamps.start = sentAt
+		timestamps.wroteDone = sentAt
+		timestamps.firstByte = receivedAt
+		stats.Received++
+		stats.RTTs = append(stats.RTTs, receivedAt.Sub(sentAt))
+		reply = data
+		remoteAddr = raddr
+	}
+	stats.summarize()
+
+	// Without any reply, the error of the last attempt is the response's error
+	var respErr error
+	if stats.Received == 0 {
+		respErr = fmt.Errorf("no reply to %d datagrams: %w", stats.Sent, lastErr)
+	} else {
+		respErr = checkAssertions(p.endpoint.Assertions, reply)
+	}
+
+	p.endpoint.respChan <- WatcherResponse{
+		TaskID:    p.endpoint.ID,
+		WatcherID: p.endpoint.watcherID,
+		URL:       &urlClone,
+		Err:       respErr,
+		Payload: &UDPTaskResponse{
+			remoteAddr: remoteAddr,
+			data:       reply,
+			timestamps: timestamps,
+			stats:      stats,
+		},
+	}
+
+	if stats.Received == 0 {
+		return respErr
+	}
+	return nil
+}
+
+// exchangeDatagram sends the payload from a new socket, and waits for a reply within the timeout.
+// Returns the time the datagram was sent, along with the reply and its source.
+func exchangeDatagram(addr netip.AddrPort, payload []byte, timeout time.Duration) (time.Time, []byte, net.Addr, error) {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(addr))
+	if err != nil {
+		return time.Now(), nil, nil, err
+	}
+	defer conn.Close()
+
+	sentAt := time.Now()
+	if err := conn.SetDeadline(sentAt.Add(timeout)); err != nil {
+		return sentAt, nil, nil, err
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return sentAt, nil, nil, fmt.Errorf("failed to write datagram: %w", err)
+	}
+
+	buf := make([]byte, maxUDPDatagramSize)
+	n, raddr, err := conn.ReadFromUDPAddrPort(buf)
+	if err != nil {
+		return sentAt, nil, nil, fmt.Errorf("failed to read reply: %w", err)
+	}
+	return sentAt, buf[:n], net.UDPAddrFromAddrPort(raddr), nil
+}
+
+// summarize calculates the loss percentage and the RTT aggregates.
+func (s *PacketStats) summarize() {
+	if s.Sent > 0 {
+		s.LossPercent = float64(s.Sent-s.Received) / float64(s.Sent) * 100
+	}
+	if len(s.RTTs) == 0 {
+		return
+	}
+	var total time.Duration
+	s.MinRTT, s.MaxRTT = s.RTTs[0], s.RTTs[0]
+	for _, rtt := range s.RTTs {
+		total += rtt
+		s.MinRTT = min(s.MinRTT, rtt)
+		s.MaxRTT = max(s.MaxRTT, rtt)
+	}
+	s.AvgRTT = total / time.Duration(len(s.RTTs))
+}
+
+// NewUDPEndpoint creates a new UDPEndpoint with the given attributes.
+func NewUDPEndpoint(u *url.URL, payload []byte, attempts int, timeout time.Duration, id string) *UDPEndpoint {
+	return &UDPEndpoint{
+		URL:      u,
+		Payload:  payload,
+		Attempts: attempts,
+		Timeout:  timeout,
+		ID:       id,
+	}
+}
+
+//
+// UDPTaskResponse
+//
+
+// UDPTaskResponse is a TaskResponse for UDP probes, holding the last reply received, if any.
+type UDPTaskResponse struct {
+	remoteAddr net.Addr
+	data       []byte
+	timestamps requestTimestamps
+	stats      PacketStats
+}
+
+// Close is a no-op, the sockets are closed after the exchanges.
+func (u *UDPTaskResponse) Close() error {
+	return nil
+}
+
+// Data returns the last reply received.
+func (u *UDPTaskResponse) Data() ([]byte, error) {
+	return u.data, nil
+}
+
+// Reader returns an io.ReadCloser for the data. Closing is a no-op.
+func (u *UDPTaskResponse) Reader() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(u.data)), nil
+}
+
+// Metadata returns metadata connected to the response.
+func (u *UDPTaskResponse) Metadata() TaskResponseMetadata {
+	stats := u.stats
+	return TaskResponseMetadata{
+		RemoteAddr: u.remoteAddr,
+		Size:       int64(len(u.data)),
+		TimeData:   TimeDataFromTimestamps(u.timestamps),
+		Packets:    &stats,
+	}
+}
@@ -0,0 +1,162 @@
+package wadjit
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpEchoServer starts a UDP server echoing back the datagrams it receives, except for every
+// dropEvery-th datagram which is dropped. A dropEvery of zero drops nothing.
+func udpEchoServer(t *testing.T, dropEvery int64) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	var received atomic.Int64
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if count := received.Add(1); dropEvery > 0 && count%dropEvery == 0 {
+				continue
+			}
+			if _, err := conn.WriteToUDP(buf[:n], addr); err != nil {
+				return
+			}
+		}
+	}()
+
+	return conn
+}
+
+func TestUDPEndpointImplementsWatcherTask(t *testing.T) {
+	var _ WatcherTask = &UDPEndpoint{}
+}
+
+func TestUDPEndpointValidate(t *testing.T) {
+	u := &url.URL{Scheme: "udp", Host: "example.com:53"}
+
+	endpoint := &UDPEndpoint{Payload: []byte("ping")}
+	assert.Error(t, endpoint.Validate(), "expected error for nil URL")
+
+	endpoint = &UDPEndpoint{URL: &url.URL{Scheme: "udp", Host: "example.com"}, Payload: []byte("ping")}
+	assert.Error(t, endpoint.Validate(), "expected error for URL without port")
+
+	endpoint = &UDPEndpoint{URL: u}
+	assert.Error(t, endpoint.Validate(), "expected error for empty payload")
+
+	endpoint = &UDPEndpoint{URL: u, Payload: []byte("ping"), Attempts: -1}
+	assert.Error(t, endpoint.Validate(), "expected error for negative attempts")
+
+	endpoint = NewUDPEndpoint(u, []byte("ping"), 0, 0, "")
+	assert.NoError(t, endpoint.Validate())
+	assert.NotEmpty(t, endpoint.ID)
+	require.NoError(t, endpoint.Initialize("a-watcher-id", make(chan WatcherResponse)))
+	assert.Equal(t, 1, endpoint.Attempts)
+	assert.Equal(t, defaultUDPTimeout, endpoint.Timeout)
+}
+
+func TestUDPEndpointExecute(t *testing.T) {
+	execute := func(t *testing.T, endpoint *UDPEndpoint) WatcherResponse {
+		t.Helper()
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		endpoint.Task().Execute()
+		select {
+		case resp := <-responseChan:
+			assert.Equal(t, endpoint.ID, resp.TaskID)
+			return resp
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+		return WatcherResponse{}
+	}
+
+	t.Run("all replies", func(t *testing.T) {
+		server := udpEchoServer(t, 0)
+		defer server.Close()
+		u := &url.URL{Scheme: "udp", Host: server.LocalAddr().String()}
+
+		endpoint := NewUDPEndpoint(u, []byte("ping"), 3, 500*time.Millisecond, "an-id")
+		endpoint.Assertions = []ByteAssertion{ExpectEqual([]byte("ping"))}
+		resp := execute(t, endpoint)
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(data))
+
+		md := resp.Metadata()
+		assert.Equal(t, server.LocalAddr().String(), md.RemoteAddr.String())
+		require.NotNil(t, md.Packets)
+		assert.Equal(t, 3, md.Packets.Sent)
+		assert.Equal(t, 3, md.Packets.Received)
+		assert.Zero(t, md.Packets.LossPercent)
+		assert.Len(t, md.Packets.RTTs, 3)
+		assert.LessOrEqual(t, md.Packets.MinRTT, md.Packets.AvgRTT)
+		assert.LessOrEqual(t, md.Packets.AvgRTT, md.Packets.MaxRTT)
+		assert.NotZero(t, md.TimeData.Latency)
+	})
+
+	t.Run("packet loss", func(t *testing.T) {
+		server := udpEchoServer(t, 2)
+		defer server.Close()
+		u := &url.URL{Scheme: "udp", Host: "wadjit.test:53"}
+
+		endpoint := NewUDPEndpoint(u, []byte("ping"), 4, 100*time.Millisecond, "an-id")
+		endpoint.TransportControl = &TransportControl{
+			AddrPort: netip.MustParseAddrPort(server.LocalAddr().String()),
+		}
+		resp := execute(t, endpoint)
+		require.NoError(t, resp.Err)
+
+		md := resp.Metadata()
+		require.NotNil(t, md.Packets)
+		assert.Equal(t, 4, md.Packets.Sent)
+		assert.Equal(t, 2, md.Packets.Received)
+		assert.Equal(t, 50.0, md.Packets.LossPercent)
+
+		// The timing data is that of the last reply, not spanning the lost datagrams
+		last := md.Packets.RTTs[len(md.Packets.RTTs)-1]
+		assert.Equal(t, last, md.TimeData.Latency)
+		require.NotNil(t, md.TimeData.ServerProcessing)
+		assert.Equal(t, last, *md.TimeData.ServerProcessing)
+	})
+
+	t.Run("no reply", func(t *testing.T) {
+		server := udpEchoServer(t, 1)
+		defer server.Close()
+		u := &url.URL{Scheme: "udp", Host: server.LocalAddr().String()}
+
+		resp := execute(t, NewUDPEndpoint(u, []byte("ping"), 2, 50*time.Millisecond, "an-id"))
+		assert.ErrorContains(t, resp.Err, "no reply to 2 datagrams")
+
+		md := resp.Metadata()
+		require.NotNil(t, md.Packets)
+		assert.Equal(t, 2, md.Packets.Sent)
+		assert.Zero(t, md.Packets.Received)
+		assert.Equal(t, 100.0, md.Packets.LossPercent)
+	})
+
+	t.Run("failed assertion", func(t *testing.T) {
+		server := udpEchoServer(t, 0)
+		defer server.Close()
+		u := &url.URL{Scheme: "udp", Host: server.LocalAddr().String()}
+
+		endpoint := NewUDPEndpoint(u, []byte("ping"), 1, 500*time.Millisecond, "an-id")
+		endpoint.Assertions = []ByteAssertion{ExpectEqual([]byte("pong"))}
+		resp := execute(t, endpoint)
+		var assertionErr *AssertionError
+		assert.ErrorAs(t, resp.Err, &assertionErr)
+	})
+}
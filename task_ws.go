@@ -2,18 +2,20 @@ package wadjit
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/bytedance/sonic"
 	"github.com/gorilla/websocket"
-	"github.com/jkbrsn/go-jsonrpc"
 	"github.com/jkbrsn/go-taskman"
 	"github.com/rs/xid"
 )
@@ -29,12 +31,44 @@ type WSEndpoint struct {
 	URL     *url.URL
 	ID      string
 
+	// TransportControl facilitates DNS-bypass when non-nil.
+	TransportControl *TransportControl
+	// TLS configures client certificates, root CAs, versions and pinning when non-nil.
+	TLS *TLSConfig
+	// Proxy routes the connection through an HTTP CONNECT or SOCKS5 proxy when non-nil.
+	Proxy *ProxyConfig
+	// Auth authenticates the upgrade request of each connection when non-nil.
+	Auth Authenticator
+
+	// Correlator links responses to requests in the persistent modes. Set to a JSONRPCCorrelator
+	// by Initialize when nil in PersistentJSONRPC mode, and required in PersistentCorrelated mode.
+	Correlator WSCorrelator
+
+	// Secrets enables secret references in the URL, headers and payload when non-nil, resolved by
+	// Initialize and redacted from the responses.
+	Secrets *SecretConfig
+	// Values holds the extractors of the values set on successful responses, by name. A failed
+	// extraction is set as the response's error.
+	Values map[string]ValueExtractor
+	// Template renders the URL, headers and payload of the messages sent when non-nil. In the
+	// persistent modes, the URL and headers are rendered when connecting, and the payload for each
+	// message. The URL template must keep the host of URL when TLS or TransportControl is set.
+	Template *RequestTemplate
+
 	// Set internally
 	conn         *websocket.Conn
 	remoteAddr   net.Addr
+	tlsConfig    *tls.Config
+	tlsInfo      *TLSInfo
+	template     *templateState
+	secrets      *secretState
 	inflightMsgs sync.Map // Key string to value wsInflightMessage
 	wg           sync.WaitGroup
 
+	// dialTimestamps holds the connection phase timestamps of the latest (re)connect, until they
+	// are reported with the first response received on the new connection.
+	dialTimestamps *requestTimestamps
+
 	// Set by Initialize
 	watcherID string
 	respChan  chan<- WatcherResponse
@@ -56,6 +90,10 @@ const (
 	// each message, which is used to link an incoming response to the request. This allows for
 	// reuse of the same connection for multiple messages while keeping message integrity.
 	PersistentJSONRPC
+	// Persistent correlated mode is the generalization of the persistent JSON RPC mode, where the
+	// endpoint's Correlator defines how the temporary ID is set on each message and how it is read
+	// back from the response. This allows for any request/response protocol with a correlation ID.
+	PersistentCorrelated
 )
 
 // wsInflightMessage stores metadata about a message that is currently in-flight.
@@ -63,8 +101,18 @@ type wsInflightMessage struct {
 	inflightID string
 	originalID any
 	timeSent   time.Time
+	// batchIDs are the inflight IDs of all elements of the batch the message was sent in, nil
+	// for a single message.
+	batchIDs []string
+	// completed is the time the operation was completed after its first response, by a
+	// WSStreamCorrelator, zero until then.
+	completed time.Time
 }
 
+// completedRetention is the time an operation completed by a WSStreamCorrelator is kept, for its
+// late messages to be dropped, when the server does not end it.
+const completedRetention = time.Minute
+
 // Close closes the WebSocket connection, and cancels its context.
 func (e *WSEndpoint) Close() error {
 	e.lock()
@@ -103,10 +151,38 @@ func (e *WSEndpoint) Initialize(watcherID string, responseChannel chan<- Watcher
 	e.watcherID = watcherID
 	e.respChan = responseChannel
 	e.ctx, e.cancel = context.WithCancel(context.Background())
+	tlsConfig, err := clientTLSConfig(e.TLS, e.TransportControl, e.URL.Hostname())
+	e.tlsConfig = tlsConfig
 	e.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to build TLS config: %w", err)
+	}
+	if e.Template != nil {
+		template, err := e.Template.compile()
+		if err != nil {
+			return err
+		}
+		e.mu.Lock()
+		e.template = template
+		e.mu.Unlock()
+	}
+	if e.Secrets != nil {
+		secrets, err := newSecretState(e.Secrets, e.URL, e.Header, e.Payload)
+		if err != nil {
+			return err
+		}
+		e.mu.Lock()
+		e.secrets = secrets
+		e.mu.Unlock()
+	}
 
 	switch e.Mode {
-	case PersistentJSONRPC:
+	case PersistentJSONRPC, PersistentCorrelated:
+		e.mu.Lock()
+		if e.Correlator == nil && e.Mode == PersistentJSONRPC {
+			e.Correlator = JSONRPCCorrelator{}
+		}
+		e.mu.Unlock()
 		err := e.connect()
 		if err != nil {
 			return fmt.Errorf("failed to connect when initializing: %w", err)
@@ -130,9 +206,8 @@ func (e *WSEndpoint) Task() taskman.Task {
 		return &wsOneHit{
 			wsEndpoint: e,
 		}
-	case PersistentJSONRPC:
+	case PersistentJSONRPC, PersistentCorrelated:
 		return &wsPersistent{
-			protocol:   JSONRPC,
 			wsEndpoint: e,
 		}
 	default:
@@ -143,6 +218,32 @@ func (e *WSEndpoint) Task() taskman.Task {
 	}
 }
 
+// RotateSecrets resolves the secret references of the WebSocket endpoint again, for rotated
+// secrets to be used by the next message, and connection. Does nothing if the endpoint has no
+// SecretConfig.
+func (e *WSEndpoint) RotateSecrets() error {
+	if e.secrets == nil {
+		return nil
+	}
+	return e.secrets.resolve()
+}
+
+// withValues sets the values extracted from the payload of a successful response, with any
+// secrets redacted.
+func (e *WSEndpoint) withValues(resp WatcherResponse) WatcherResponse {
+	if resp.Err != nil || len(e.Values) == 0 {
+		return resp
+	}
+	resp.Values, resp.Err = extractValues(e.Values, resp.Payload)
+	resp.Values = redactValues(resp.Values, e.secrets.current())
+	return resp
+}
+
+// setTemplateVars sets the variables the template of the WebSocket endpoint is rendered with.
+func (e *WSEndpoint) setTemplateVars(vars map[string]string) {
+	e.template.setVars(vars)
+}
+
 // Validate checks that the WSEndpoint is ready to be initialized.
 func (e *WSEndpoint) Validate() error {
 	if e.URL == nil {
@@ -156,6 +257,43 @@ func (e *WSEndpoint) Validate() error {
 		// Set random ID if nil
 		e.ID = xid.New().String()
 	}
+	if e.TransportControl != nil {
+		if err := e.TransportControl.validate(); err != nil {
+			return err
+		}
+	}
+	if e.TLS != nil {
+		if err := e.TLS.validate(); err != nil {
+			return err
+		}
+	}
+	if e.Proxy != nil {
+		if err := e.Proxy.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateAuth(e.Auth); err != nil {
+		return err
+	}
+	if e.Secrets != nil {
+		if err := e.Secrets.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateValueExtractors(e.Values); err != nil {
+		return err
+	}
+	if e.Template != nil {
+		if err := e.Template.validate(); err != nil {
+			return err
+		}
+		if err := e.Template.validateHost(e.URL, e.TLS, e.TransportControl); err != nil {
+			return err
+		}
+	}
+	if e.Mode == PersistentCorrelated && e.Correlator == nil {
+		return errors.New("Correlator is nil in PersistentCorrelated mode")
+	}
 	return nil
 }
 
@@ -176,7 +314,7 @@ func (e *WSEndpoint) closeConn() error {
 // connect establishes a connection to the WebSocket endpoint. If already connected,
 // this function does nothing.
 func (e *WSEndpoint) connect() error {
-	if e.Mode != PersistentJSONRPC {
+	if !e.persistent() {
 		return errors.New("cannot establish long lived connection for non-long mode")
 	}
 	e.mu.Lock()
@@ -188,12 +326,18 @@ func (e *WSEndpoint) connect() error {
 	}
 
 	// Establish the connection
-	conn, _, err := websocket.DefaultDialer.Dial(e.URL.String(), e.Header)
+	u, header, secrets, err := e.target()
 	if err != nil {
 		return err
 	}
+	conn, timestamps, tlsInfo, err := e.dial(u, header)
+	if err != nil {
+		return redactError(err, secrets)
+	}
 	e.conn = conn
 	e.remoteAddr = conn.RemoteAddr()
+	e.tlsInfo = tlsInfo
+	e.dialTimestamps = &timestamps
 
 	// Start the read pump for incoming messages
 	e.wg.Add(1)
@@ -202,6 +346,111 @@ func (e *WSEndpoint) connect() error {
 	return nil
 }
 
+// target returns the URL and header to connect with in the persistent modes, with the secret
+// references resolved and the template rendered with the data of the latest execution, along with
+// the secrets resolved for errors to be redacted of.
+func (e *WSEndpoint) target() (*url.URL, http.Header, []string, error) {
+	values, err := e.secrets.values(e.URL, e.Header, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rendered, err := e.template.render(e.template.current(e.ID, e.watcherID), values.url, values.header, nil)
+	if err != nil {
+		return nil, nil, nil, redactError(err, values.secrets)
+	}
+	return rendered.url, rendered.header, values.secrets, nil
+}
+
+// dial establishes a new connection to the WebSocket endpoint at u, with the given header, and
+// performs the correlator's handshake if it has one. Returns the connection along with the
+// timestamps of the DNS lookup, TCP connect, TLS handshake and HTTP upgrade phases of the dial,
+// and the TLS connection info for wss URLs.
+func (e *WSEndpoint) dial(u *url.URL, header http.Header) (*websocket.Conn, requestTimestamps, *TLSInfo, error) {
+	dialer := *websocket.DefaultDialer
+	if tc := e.TransportControl; tc != nil {
+		// Override name–resolution only, the TLS handshake is done by the dialer with correct SNI
+		dialer.NetDialContext = tc.dialContext()
+	}
+	if e.Proxy != nil {
+		// Tunnel through the proxy, which takes the place of any proxy from the environment
+		dialer.Proxy = nil
+		dialer.NetDialContext = e.Proxy.dialContext(e.TransportControl)
+	}
+	if e.tlsConfig != nil {
+		dialer.TLSClientConfig = e.tlsConfig
+	}
+	handshaker, isHandshaker := e.Correlator.(WSHandshaker)
+	if isHandshaker {
+		dialer.Subprotocols = handshaker.Subprotocols()
+	}
+
+	timestamps := requestTimestamps{}
+	var tlsState tls.ConnectionState
+	ctx := httptrace.WithClientTrace(e.ctx, traceWSDial(&timestamps, &tlsState))
+	if e.Proxy != nil {
+		ctx = withProxyTimes(ctx, &timestamps)
+	}
+
+	if e.Auth != nil {
+		// Authenticate a stand-in of the upgrade request, which the dialer builds from the header
+		// TODO: move timeout to configuration
+		authCtx, cancel := context.WithTimeout(e.ctx, 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(authCtx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, timestamps, nil, err
+		}
+		req.Header = header.Clone()
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		if err := e.Auth.Authenticate(authCtx, req, nil); err != nil {
+			return nil, timestamps, nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
+		header = req.Header
+	}
+
+	timestamps.start = time.Now()
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		// Drop a cached token that was refused, for the next dial to get a new one
+		if invalidator, ok := e.Auth.(tokenInvalidator); ok && resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			invalidator.invalidate()
+		}
+		return nil, timestamps, nil, err
+	}
+	timestamps.upgradeDone = time.Now()
+
+	if isHandshaker {
+		if err := handshaker.Handshake(conn); err != nil {
+			conn.Close()
+			return nil, timestamps, nil, fmt.Errorf("handshake failed: %w", err)
+		}
+	}
+
+	return conn, timestamps, newTLSInfo(&tlsState), nil
+}
+
+// traceWSDial traces the dial of a WebSocket connection and stores the timestamps in the provided
+// times. The DNS and connect phases are reported by the net.Dialer used by the websocket.Dialer,
+// and the upgrade phase starts when the connection is ready for the HTTP upgrade request. The state
+// of a completed TLS handshake is stored in tlsState.
+func traceWSDial(times *requestTimestamps, tlsState *tls.ConnectionState) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { times.dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { times.dnsDone = time.Now() },
+		ConnectStart:      func(_, _ string) { times.connStart = time.Now() },
+		ConnectDone:       func(_, _ string, _ error) { times.connDone = time.Now() },
+		GotConn:           func(httptrace.GotConnInfo) { times.upgradeStart = time.Now() },
+		TLSHandshakeStart: func() { times.tlsStart = time.Now() },
+		TLSHandshakeDone: func(cs tls.ConnectionState, _ error) {
+			times.tlsDone = time.Now()
+			times.upgradeStart = times.tlsDone
+			*tlsState = cs
+		},
+	}
+}
+
 // nilConn checks if the WebSocket connection is nil or closed.
 func (e *WSEndpoint) nilConn() bool {
 	e.mu.Lock()
@@ -215,7 +464,7 @@ func (e *WSEndpoint) nilConn() bool {
 
 // reconnect closes the current connection and establishes a new one.
 func (e *WSEndpoint) reconnect() error {
-	if e.Mode != PersistentJSONRPC {
+	if !e.persistent() {
 		return errors.New("can only reconnect for long-lived connections")
 	}
 
@@ -235,11 +484,18 @@ func (e *WSEndpoint) reconnect() error {
 	e.conn = nil
 
 	// Establish a new connection
-	conn, _, err := websocket.DefaultDialer.Dial(e.URL.String(), e.Header)
+	u, header, secrets, err := e.target()
+	if err != nil {
+		return err
+	}
+	conn, timestamps, tlsInfo, err := e.dial(u, header)
 	if err != nil {
-		return fmt.Errorf("failed to dial when reconnecting: %w", err)
+		return redactError(fmt.Errorf("failed to dial when reconnecting: %w", err), secrets)
 	}
 	e.conn = conn
+	e.remoteAddr = conn.RemoteAddr()
+	e.tlsInfo = tlsInfo
+	e.dialTimestamps = &timestamps
 
 	// Restart the read pump for incoming messages
 	e.wg.Add(1)
@@ -248,6 +504,11 @@ func (e *WSEndpoint) reconnect() error {
 	return nil
 }
 
+// persistent checks if the endpoint is configured for one of the persistent connection modes.
+func (e *WSEndpoint) persistent() bool {
+	return e.Mode == PersistentJSONRPC || e.Mode == PersistentCorrelated
+}
+
 // lock and unlock provide exclusive access to the connection's mutex.
 func (e *WSEndpoint) lock() {
 	e.mu.Lock()
@@ -297,74 +558,198 @@ func (e *WSEndpoint) readPump(wg *sync.WaitGroup) {
 				firstByte: time.Now(),
 			}
 
-			if e.Mode == PersistentJSONRPC {
-				// 1. Unmarshal p into a JSON-RPC response interface
-				jsonRPCResp, err := jsonrpc.DecodeResponse(p)
-				if err != nil {
-					// Send an error response
-					e.respChan <- errorResponse(fmt.Errorf("failed parsing jsonrpc.Response from bytes: %w", err), e.ID, e.watcherID, &urlClone)
-					return
-				}
-
-				// 2. Check the ID against the inflight messages map
-				if !jsonRPCResp.IsEmpty() {
-					responseID := jsonRPCResp.IDString()
-					if responseID == "" {
-						// Send an error response
-						e.respChan <- errorResponse(fmt.Errorf("found nil response ID, error: %s", jsonRPCResp.Result), e.ID, e.watcherID, &urlClone)
-						return
-					}
-
-					// 3. If the ID is known, get the inflight map metadata and delete the ID in the map
-					if inflightMsg, ok := e.inflightMsgs.Load(responseID); ok {
-						inflightMsg := inflightMsg.(wsInflightMessage)
-						e.inflightMsgs.Delete(responseID)
-
-						// Get start time from inflight message
-						timestamps.start = inflightMsg.timeSent
-
-						// 4. Restore original ID and marshal the JSON-RPC interface back into a byte slice
-						jsonRPCResp.ID = inflightMsg.originalID
-						p, err = jsonRPCResp.MarshalJSON()
-						if err != nil {
-							// Send an error response
-							e.respChan <- errorResponse(fmt.Errorf("failed re-marshalling JSON-RPC response: %w", err), e.ID, e.watcherID, &urlClone)
-							return
-						}
-						// 5. set metadata to the taskresponse: original id, duration between time sent and time received
-						taskResponse := NewWSTaskResponse(e.remoteAddr, p)
-						taskResponse.timestamps = timestamps
-
-						// Send the message to the read channel
-						response := WatcherResponse{
-							TaskID:    e.ID,
-							WatcherID: e.watcherID,
-							URL:       &urlClone,
-							Err:       nil,
-							Payload:   taskResponse,
-						}
-						e.respChan <- response
-					} else {
-						e.respChan <- errorResponse(errors.New("unknown response ID: "+jsonRPCResp.IDString()), e.ID, e.watcherID, &urlClone)
-					}
-				} else {
-					e.respChan <- errorResponse(errors.New("empty JSON-RPC response"), e.ID, e.watcherID, &urlClone)
-				}
+			if e.Correlator != nil {
+				e.handleCorrelated(p, timestamps, &urlClone)
 			} else {
 				// Send the message to the read channel
+				e.template.setPrevious(&PreviousResponse{Body: p})
 				response := WatcherResponse{
 					TaskID:    e.ID,
 					WatcherID: e.watcherID,
 					URL:       &urlClone,
 					Err:       nil,
-					Payload:   NewWSTaskResponse(e.remoteAddr, p),
+					Payload:   &WSTaskResponse{remoteAddr: e.remoteAddr, data: p, tlsInfo: e.tlsInfo},
 				}
-				e.respChan <- response
+				e.respChan <- e.withValues(response)
 			}
 		}
 	}
 }
 
+// handleCorrelated links an incoming message to its inflight request using the endpoint's
+// Correlator, and sends the message with its original ID restored on the response channel.
+func (e *WSEndpoint) handleCorrelated(p []byte, timestamps requestTimestamps, urlClone *url.URL) {
+	batchCorrelator, isBatchCorrelator := e.Correlator.(WSBatchCorrelator)
+	isBatch := isBatchCorrelator && batchCorrelator.IsBatch(p)
+	streamCorrelator, isStream := e.Correlator.(WSStreamCorrelator)
+	isStream = isStream && !isBatch
+
+	// 1. Extract the correlation IDs from the message
+	var responseIDs []string
+	if isBatch {
+		ids, err := batchCorrelator.ExtractBatch(p)
+		if err != nil {
+			e.respChan <- errorResponse(err, e.ID, e.watcherID, urlClone)
+			return
+		}
+		responseIDs = ids
+	} else {
+		id, err := e.Correlator.Extract(p)
+		if err != nil {
+			e.respChan <- errorResponse(err, e.ID, e.watcherID, urlClone)
+			return
+		}
+		if id == "" {
+			// Not a response to a request, ignore after replying if the protocol requires it
+			if isStream {
+				if err := e.reply(streamCorrelator.Reply(p)); err != nil {
+					e.respChan <- errorResponse(err, e.ID, e.watcherID, urlClone)
+				}
+			}
+			return
+		}
+		if isStream {
+			if value, ok := e.inflightMsgs.Load(id); ok && !value.(wsInflightMessage).completed.IsZero() {
+				// A late message of an operation completed after its first response
+				if streamCorrelator.Done(p) {
+					e.inflightMsgs.Delete(id)
+				}
+				return
+			}
+		}
+		responseIDs = []string{id}
+	}
+
+	// 2. Check all IDs against the inflight messages map. A message with an unknown ID fails as a
+	// whole, and the inflight messages it answers are not waited for any longer
+	inflightMsgs := make([]wsInflightMessage, 0, len(responseIDs))
+	var unknownID string
+	for _, responseID := range responseIDs {
+		value, ok := e.inflightMsgs.Load(responseID)
+		if !ok {
+			unknownID = responseID
+			continue
+		}
+		inflightMsgs = append(inflightMsgs, value.(wsInflightMessage))
+	}
+	if unknownID != "" {
+		for _, inflightMsg := range inflightMsgs {
+			e.inflightMsgs.Delete(inflightMsg.inflightID)
+			for _, batchID := range inflightMsg.batchIDs {
+				e.inflightMsgs.Delete(batchID)
+			}
+		}
+		e.respChan <- errorResponse(errors.New("unknown response ID: "+unknownID), e.ID, e.watcherID, urlClone)
+		return
+	}
+	// An operation which may send more messages is kept as completed, for those to be dropped
+	complete := isStream && !streamCorrelator.Done(p)
+	originalIDs := make(map[string]any, len(responseIDs))
+	for _, inflightMsg := range inflightMsgs {
+		if complete {
+			inflightMsg.completed = time.Now()
+			e.inflightMsgs.Store(inflightMsg.inflightID, inflightMsg)
+		} else {
+			e.inflightMsgs.Delete(inflightMsg.inflightID)
+		}
+		// Elements missing from a partial batch response are not waited for any longer
+		for _, batchID := range inflightMsg.batchIDs {
+			e.inflightMsgs.Delete(batchID)
+		}
+		originalIDs[inflightMsg.inflightID] = inflightMsg.originalID
+
+		// Get start time from the earliest inflight message
+		if timestamps.start.IsZero() || inflightMsg.timeSent.Before(timestamps.start) {
+			timestamps.start = inflightMsg.timeSent
+		}
+	}
+
+	if complete {
+		// Tell the server to stop sending messages for the operation
+		if err := e.reply(streamCorrelator.Complete(responseIDs[0])); err != nil {
+			e.respChan <- errorResponse(err, e.ID, e.watcherID, urlClone)
+		}
+		e.pruneCompleted()
+	}
+
+	// Report the connection phases with the first response on a new connection
+	e.mu.Lock()
+	if dial := e.dialTimestamps; dial != nil {
+		timestamps.dnsStart, timestamps.dnsDone = dial.dnsStart, dial.dnsDone
+		timestamps.connStart, timestamps.connDone = dial.connStart, dial.connDone
+		timestamps.tlsStart, timestamps.tlsDone = dial.tlsStart, dial.tlsDone
+		timestamps.upgradeStart, timestamps.upgradeDone = dial.upgradeStart, dial.upgradeDone
+		e.dialTimestamps = nil
+	}
+	e.mu.Unlock()
+
+	// 3. Restore the original IDs on the message
+	var err error
+	if isBatch {
+		p, err = batchCorrelator.RestoreBatch(p, originalIDs)
+	} else {
+		p, err = e.Correlator.Restore(p, originalIDs[responseIDs[0]])
+	}
+	if err != nil {
+		e.respChan <- errorResponse(err, e.ID, e.watcherID, urlClone)
+		return
+	}
+
+	// 4. Set metadata to the task response and send it on the response channel
+	taskResponse := NewWSTaskResponse(e.remoteAddr, p)
+	taskResponse.timestamps = timestamps
+	taskResponse.tlsInfo = e.tlsInfo
+	// JSON-RPC error objects are only exposed in the metadata, the response itself succeeded
+	if _, ok := e.Correlator.(JSONRPCCorrelator); ok {
+		taskResponse.jsonRPCResults, err = decodeJSONRPCResults(p)
+		if err != nil {
+			e.respChan <- errorResponse(err, e.ID, e.watcherID, urlClone)
+			return
+		}
+	}
+	e.template.setPrevious(&PreviousResponse{Body: p})
+	e.respChan <- e.withValues(WatcherResponse{
+		TaskID:    e.ID,
+		WatcherID: e.watcherID,
+		URL:       urlClone,
+		Err:       nil,
+		Payload:   taskResponse,
+	})
+}
+
+// reply writes a message produced by the endpoint's Correlator to the connection. A nil message is
+// not written.
+func (e *WSEndpoint) reply(msg []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	if msg == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return errors.New("failed to write reply: connection closed")
+	}
+	if err := e.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		return fmt.Errorf("failed to write reply: %w", err)
+	}
+	return nil
+}
+
+// pruneCompleted removes the operations completed longer than completedRetention ago from the
+// inflight messages.
+func (e *WSEndpoint) pruneCompleted() {
+	e.inflightMsgs.Range(func(key, value any) bool {
+		completed := value.(wsInflightMessage).completed
+		if !completed.IsZero() && time.Since(completed) > completedRetention {
+			e.inflightMsgs.Delete(key)
+		}
+		return true
+	})
+}
+
 // wsOneHit is an implementation of taskman.Task that sets up a short-lived WebSocket connection
 // to send a message to the endpoint. This is useful for endpoints that require a new connection
 // for each message, or for situations where there is no way to link the response to the request.
@@ -384,39 +769,44 @@ func (oh *wsOneHit) Execute() error {
 	oh.wsEndpoint.lock()
 	defer oh.wsEndpoint.unlock()
 
-	// Clone the URL to avoid downstream mutation
-	urlClone := *oh.wsEndpoint.URL
+	// Resolve the secrets of the message, if any, and render its template
+	e := oh.wsEndpoint
+	values, err := e.secrets.values(e.URL, e.Header, e.Payload)
+	secrets := values.secrets
+	if err != nil {
+		e.respChan <- errorResponse(err, e.ID, e.watcherID, redactURL(values.url, secrets))
+		return err
+	}
+	template := e.template
+	rendered, err := template.render(template.next(e.ID, e.watcherID), values.url, values.header, values.payload)
+	// The URL of the response is a copy, with any secrets redacted
+	urlClone := redactURL(rendered.url, secrets)
+	if err != nil {
+		err = redactError(err, secrets)
+		e.respChan <- errorResponse(err, e.ID, e.watcherID, urlClone)
+		return err
+	}
 
 	select {
 	case <-oh.wsEndpoint.ctx.Done():
 		// Endpoint shutting down, do nothing
 		return nil
 	default:
-		timestamps := requestTimestamps{}
-
 		// 1. Establish a new connection
-		timestamps.start = time.Now()
-		// TODO: move DNS, conn and TLS timings into the dialer, perhaps reuse wsstat library?
-		timestamps.dnsStart = time.Now()
-		timestamps.connStart = time.Now()
-		timestamps.tlsStart = time.Now()
-		conn, _, err := websocket.DefaultDialer.Dial(urlClone.String(), oh.wsEndpoint.Header)
+		conn, timestamps, tlsInfo, err := oh.wsEndpoint.dial(rendered.url, rendered.header)
 		if err != nil {
-			err = fmt.Errorf("failed to dial: %w", err)
-			oh.wsEndpoint.respChan <- errorResponse(err, oh.wsEndpoint.ID, oh.wsEndpoint.watcherID, &urlClone)
+			err = redactError(fmt.Errorf("failed to dial: %w", err), secrets)
+			oh.wsEndpoint.respChan <- errorResponse(err, oh.wsEndpoint.ID, oh.wsEndpoint.watcherID, urlClone)
 			return err
 		}
 		remoteAddr := conn.RemoteAddr()
 		defer conn.Close()
-		timestamps.dnsDone = time.Now()
-		timestamps.connDone = time.Now()
-		timestamps.tlsDone = time.Now()
 
 		// 2. Write message to connection
-		if err := conn.WriteMessage(websocket.TextMessage, oh.wsEndpoint.Payload); err != nil {
+		if err := conn.WriteMessage(websocket.TextMessage, rendered.payload); err != nil {
 			// An error is unexpected, since the connection was just established
-			err = fmt.Errorf("failed to write message: %w", err)
-			oh.wsEndpoint.respChan <- errorResponse(err, oh.wsEndpoint.ID, oh.wsEndpoint.watcherID, &urlClone)
+			err = redactError(fmt.Errorf("failed to write message: %w", err), secrets)
+			oh.wsEndpoint.respChan <- errorResponse(err, oh.wsEndpoint.ID, oh.wsEndpoint.watcherID, urlClone)
 			return err
 		}
 		timestamps.wroteDone = time.Now()
@@ -425,8 +815,8 @@ func (oh *wsOneHit) Execute() error {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			// An error is unexpected, since the connection was just established
-			err = fmt.Errorf("failed to read message: %w", err)
-			oh.wsEndpoint.respChan <- errorResponse(err, oh.wsEndpoint.ID, oh.wsEndpoint.watcherID, &urlClone)
+			err = redactError(fmt.Errorf("failed to read message: %w", err), secrets)
+			oh.wsEndpoint.respChan <- errorResponse(err, oh.wsEndpoint.ID, oh.wsEndpoint.watcherID, urlClone)
 			return err
 		}
 		timestamps.firstByte = time.Now() // TODO: can we properly get this at the first byte instead of after read?
@@ -435,15 +825,17 @@ func (oh *wsOneHit) Execute() error {
 		// 4. Create a task response
 		taskResponse := NewWSTaskResponse(remoteAddr, message)
 		taskResponse.timestamps = timestamps
+		taskResponse.tlsInfo = tlsInfo
+		template.setPrevious(&PreviousResponse{Body: message})
 
 		// 5. Send the response message on the channel
-		oh.wsEndpoint.respChan <- WatcherResponse{
+		oh.wsEndpoint.respChan <- oh.wsEndpoint.withValues(WatcherResponse{
 			TaskID:    oh.wsEndpoint.ID,
 			WatcherID: oh.wsEndpoint.watcherID,
-			URL:       &urlClone,
+			URL:       urlClone,
 			Err:       nil,
 			Payload:   taskResponse,
-		}
+		})
 
 		// 6. Close the connection gracefully
 		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
@@ -460,26 +852,16 @@ func (oh *wsOneHit) Execute() error {
 }
 
 // wsPersistent is an implementation of taskman.Task that sends a message on a persistent
-// WebSocket connection.
+// WebSocket connection, using the endpoint's Correlator to link the response to the message.
 type wsPersistent struct {
 	wsEndpoint *WSEndpoint
-	protocol   wsPersistentProtocol
 }
 
-// wsPersistentProtocol is an enum for the communication protocol used by the long-lived
-// WebSocket connection.
-type wsPersistentProtocol int
-
-const (
-	UnknownProtocol wsPersistentProtocol = iota
-	JSONRPC
-)
-
 // Execute sends a message to the WebSocket endpoint.
 // Note: for concurrency safety, the connection's WriteMessage method is used exclusively here.
 func (ll *wsPersistent) Execute() error {
-	if ll.protocol != JSONRPC {
-		return errors.New("unsupported protocol")
+	if ll.wsEndpoint.Correlator == nil {
+		return errors.New("no correlator set for persistent connection")
 	}
 
 	// If the connection is closed, try to reconnect
@@ -500,51 +882,54 @@ func (ll *wsPersistent) Execute() error {
 		// Endpoint shutting down, do nothing
 		return nil
 	default:
-		// Prepare shadowed variables for the message
-		var payload []byte
-		var err error
-
-		// 1. Unmarshal the msg into a JSON-RPC interface
-		jsonRPCReq := &jsonrpc.Request{}
-		if len(ll.wsEndpoint.Payload) > 0 {
-			// TODO: optimize this to only get the ID?
-			err := jsonRPCReq.UnmarshalJSON(ll.wsEndpoint.Payload)
-			if err != nil {
-				err = fmt.Errorf("failed to unmarshal JSON-RPC message: %w", err)
-				ll.wsEndpoint.respChan <- errorResponse(err, ll.wsEndpoint.ID, ll.wsEndpoint.watcherID, &urlClone)
-				return err
-			}
+		// 1. Render the payload, then generate random IDs and stamp them on the message, keeping
+		// the original IDs
+		template := ll.wsEndpoint.template
+		data := template.next(ll.wsEndpoint.ID, ll.wsEndpoint.watcherID)
+		values, err := ll.wsEndpoint.secrets.values(ll.wsEndpoint.URL, nil, ll.wsEndpoint.Payload)
+		if err != nil {
+			ll.wsEndpoint.respChan <- errorResponse(err, ll.wsEndpoint.ID, ll.wsEndpoint.watcherID, &urlClone)
+			return err
 		}
-
-		// 2. Generate a random ID and extract the original ID from the JSON-RPC interface
-		inflightID := xid.New().String()
-		var originalID any
-		if !jsonRPCReq.IsEmpty() {
-			originalID = jsonRPCReq.ID
-			jsonRPCReq.ID = inflightID
+		rendered, err := template.render(data, values.url, nil, values.payload)
+		if err != nil {
+			err = redactError(err, values.secrets)
+			ll.wsEndpoint.respChan <- errorResponse(err, ll.wsEndpoint.ID, ll.wsEndpoint.watcherID, &urlClone)
+			return err
 		}
-
-		// 3. store the id in a "inflight map" in WSEndpoint, with metadata: original id, time sent
-		inflightMsg := wsInflightMessage{
-			inflightID: inflightID,
-			originalID: originalID,
+		var payload []byte
+		var originalIDs map[string]any
+		batchCorrelator, ok := ll.wsEndpoint.Correlator.(WSBatchCorrelator)
+		isBatch := ok && batchCorrelator.IsBatch(rendered.payload)
+		if isBatch {
+			newID := func() string { return xid.New().String() }
+			payload, originalIDs, err = batchCorrelator.StampBatch(rendered.payload, newID)
+		} else {
+			inflightID := xid.New().String()
+			var originalID any
+			payload, originalID, err = ll.wsEndpoint.Correlator.Stamp(rendered.payload, inflightID)
+			originalIDs = map[string]any{inflightID: originalID}
 		}
-
-		// 4. Marshal the updated JSON-RPC interface back into text message
-		payload, err = sonic.Marshal(jsonRPCReq)
 		if err != nil {
-			err = fmt.Errorf("failed to marshal JSON-RPC message: %w", err)
+			err = redactError(err, values.secrets)
 			ll.wsEndpoint.respChan <- errorResponse(err, ll.wsEndpoint.ID, ll.wsEndpoint.watcherID, &urlClone)
 			return err
 		}
-		inflightMsg.timeSent = time.Now()
 
-		// 5. Store the inflight message in the WSEndpoint
-		ll.wsEndpoint.inflightMsgs.Store(inflightID, inflightMsg)
-
-		// If the payload is nil, use the endpoint's payload
-		if payload == nil {
-			payload = ll.wsEndpoint.Payload
+		// 2. Store the inflight messages in the WSEndpoint, with metadata: original id, time sent
+		// and the IDs of the other elements of a batch
+		var batchIDs []string
+		if isBatch {
+			batchIDs = slices.Collect(maps.Keys(originalIDs))
+		}
+		timeSent := time.Now()
+		for inflightID, originalID := range originalIDs {
+			ll.wsEndpoint.inflightMsgs.Store(inflightID, wsInflightMessage{
+				inflightID: inflightID,
+				originalID: originalID,
+				timeSent:   timeSent,
+				batchIDs:   batchIDs,
+			})
 		}
 
 		// Write message to connection
@@ -559,6 +944,7 @@ func (ll *wsPersistent) Execute() error {
 				// This is unexpected
 				err = fmt.Errorf("unexpected websocket write error: %w", err)
 			}
+			err = redactError(err, values.secrets)
 
 			// Close the connection
 			ll.wsEndpoint.closeConn()
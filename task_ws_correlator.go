@@ -0,0 +1,698 @@
+package wadjit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gorilla/websocket"
+	"github.com/jkbrsn/go-jsonrpc"
+)
+
+// WSCorrelator links messages sent on a persistent WebSocket connection to the responses read
+// from it. This allows several requests to share one connection while keeping message integrity.
+type WSCorrelator interface {
+	// Stamp sets the correlation ID on an outgoing message. Returns the stamped message and the
+	// original ID of the message, which is restored on the response.
+	Stamp(msg []byte, correlationID string) ([]byte, any, error)
+
+	// Extract returns the correlation ID of an incoming message. An empty ID together with a nil
+	// error signals a message that is not a response to a request, e.g. a keep-alive, and which
+	// should be ignored.
+	Extract(msg []byte) (string, error)
+
+	// Restore sets the original ID back on an incoming message.
+	Restore(msg []byte, originalID any) ([]byte, error)
+}
+
+// WSHandshaker is an optional interface for a WSCorrelator whose protocol requires messages to be
+// exchanged on a new connection before any requests can be sent.
+type WSHandshaker interface {
+	// Subprotocols returns the WebSocket subprotocols to request when dialing. May return nil.
+	Subprotocols() []string
+
+	// Handshake is called on each new connection, before the connection is used for requests.
+	Handshake(conn *websocket.Conn) error
+}
+
+// WSBatchCorrelator is an optional interface for a WSCorrelator whose protocol allows several
+// requests to be sent in a single message, e.g. JSON-RPC batches. Each request in a batch gets its
+// own correlation ID.
+type WSBatchCorrelator interface {
+	// IsBatch checks if a message, outgoing or incoming, is a batch.
+	IsBatch(msg []byte) bool
+
+	// StampBatch sets a correlation ID, generated by newID, on each request of a batch message.
+	// Returns the stamped message and a map of the correlation IDs to the original IDs.
+	StampBatch(msg []byte, newID func() string) ([]byte, map[string]any, error)
+
+	// ExtractBatch returns the correlation IDs of the responses in a batch message.
+	ExtractBatch(msg []byte) ([]string, error)
+
+	// RestoreBatch sets the original IDs back on the responses in a batch message.
+	RestoreBatch(msg []byte, originalIDs map[string]any) ([]byte, error)
+}
+
+// WSStreamCorrelator is an optional interface for a WSCorrelator whose protocol may answer a
+// request with several messages, e.g. GraphQL subscriptions, or send messages that expect a reply,
+// e.g. keep-alive pings. Only the first response to a request is reported: the operation is then
+// completed, and its later messages are dropped.
+type WSStreamCorrelator interface {
+	// Complete returns the message that tells the server to stop sending responses to the request
+	// with the correlation ID.
+	Complete(correlationID string) ([]byte, error)
+
+	// Done checks if an incoming message is the last one of its operation.
+	Done(msg []byte) bool
+
+	// Reply returns the message to write back for an incoming message that is not a response to a
+	// request, e.g. a "pong" for a "ping". Returns nil if no reply is needed.
+	Reply(msg []byte) ([]byte, error)
+}
+
+// handshakeTimeout is the time allowed for a WSHandshaker to complete its handshake.
+const handshakeTimeout = 5 * time.Second
+
+//
+// JSON-RPC
+//
+
+// JSONRPCCorrelator correlates JSON-RPC 2.0 requests and responses using the "id" member.
+type JSONRPCCorrelator struct{}
+
+// Stamp replaces the ID of a JSON-RPC request with the correlation ID.
+func (JSONRPCCorrelator) Stamp(msg []byte, correlationID string) ([]byte, any, error) {
+	if len(msg) == 0 {
+		return nil, nil, errors.New("empty JSON-RPC message")
+	}
+	req := &jsonrpc.Request{}
+	if err := req.UnmarshalJSON(msg); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal JSON-RPC message: %w", err)
+	}
+	originalID := req.ID
+	req.ID = correlationID
+
+	stamped, err := sonic.Marshal(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal JSON-RPC message: %w", err)
+	}
+	return stamped, originalID, nil
+}
+
+// Extract returns the ID of a JSON-RPC response.
+func (JSONRPCCorrelator) Extract(msg []byte) (string, error) {
+	resp, err := jsonrpc.DecodeResponse(msg)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing jsonrpc.Response from bytes: %w", err)
+	}
+	if resp.IsEmpty() {
+		return "", errors.New("empty JSON-RPC response")
+	}
+	id := resp.IDString()
+	if id == "" {
+		return "", fmt.Errorf("found nil response ID, error: %s", resp.Result)
+	}
+	return id, nil
+}
+
+// Restore sets the original ID on a JSON-RPC response.
+func (JSONRPCCorrelator) Restore(msg []byte, originalID any) ([]byte, error) {
+	resp, err := jsonrpc.DecodeResponse(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing jsonrpc.Response from bytes: %w", err)
+	}
+	resp.ID = originalID
+	restored, err := resp.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed re-marshalling JSON-RPC response: %w", err)
+	}
+	return restored, nil
+}
+
+// IsBatch checks if the message is a JSON-RPC batch.
+func (JSONRPCCorrelator) IsBatch(msg []byte) bool {
+	return isJSONArray(msg)
+}
+
+// StampBatch replaces the ID of each request in a JSON-RPC batch with a new correlation ID.
+// Notifications, i.e. requests without an ID, are left untouched since they get no response.
+func (JSONRPCCorrelator) StampBatch(msg []byte, newID func() string) ([]byte, map[string]any, error) {
+	elements, err := splitJSONArray(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	originalIDs := make(map[string]any, len(elements))
+	for i := range elements {
+		req := &jsonrpc.Request{}
+		if err := req.UnmarshalJSON(elements[i]); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal JSON-RPC message at index %d: %w", i, err)
+		}
+		if req.ID == nil {
+			continue // Notification
+		}
+		correlationID := newID()
+		originalIDs[correlationID] = req.ID
+		req.ID = correlationID
+
+		if elements[i], err = sonic.Marshal(req); err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal JSON-RPC message at index %d: %w", i, err)
+		}
+	}
+	return joinJSONArray(elements), originalIDs, nil
+}
+
+// ExtractBatch returns the IDs of the responses in a JSON-RPC batch response. Responses without
+// an ID, e.g. to an invalid request, cannot be correlated and are skipped.
+func (JSONRPCCorrelator) ExtractBatch(msg []byte) ([]string, error) {
+	elements, err := splitJSONArray(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(elements))
+	for i := range elements {
+		resp, err := jsonrpc.DecodeResponse(elements[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing jsonrpc.Response at index %d: %w", i, err)
+		}
+		if id := resp.IDString(); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("found no response IDs in JSON-RPC batch")
+	}
+	return ids, nil
+}
+
+// RestoreBatch sets the original ID on each response in a JSON-RPC batch response.
+func (JSONRPCCorrelator) RestoreBatch(msg []byte, originalIDs map[string]any) ([]byte, error) {
+	elements, err := splitJSONArray(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range elements {
+		resp, err := jsonrpc.DecodeResponse(elements[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing jsonrpc.Response at index %d: %w", i, err)
+		}
+		originalID, ok := originalIDs[resp.IDString()]
+		if !ok {
+			continue
+		}
+		resp.ID = originalID
+		if elements[i], err = resp.MarshalJSON(); err != nil {
+			return nil, fmt.Errorf("failed re-marshalling JSON-RPC response at index %d: %w", i, err)
+		}
+	}
+	return joinJSONArray(elements), nil
+}
+
+//
+// Custom JSON
+//
+
+// JSONFieldCorrelator correlates JSON object messages of a custom protocol, using a field that
+// the server echoes from the request onto the response. Fields are given as dot-separated paths,
+// e.g. "meta.requestId".
+type JSONFieldCorrelator struct {
+	// RequestField is the path of the correlation field in outgoing messages.
+	RequestField string
+	// ResponseField is the path of the correlation field in incoming messages. Defaults to
+	// RequestField when empty.
+	ResponseField string
+}
+
+// Stamp sets the request field of a JSON object message to the correlation ID.
+func (c JSONFieldCorrelator) Stamp(msg []byte, correlationID string) ([]byte, any, error) {
+	obj, err := decodeJSONObject(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	originalID := setJSONPath(obj, c.RequestField, correlationID)
+	stamped, err := json.Marshal(obj)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal JSON message: %w", err)
+	}
+	return stamped, originalID, nil
+}
+
+// Extract returns the value of the response field of a JSON object message. Messages without the
+// field are ignored.
+func (c JSONFieldCorrelator) Extract(msg []byte) (string, error) {
+	obj, err := decodeJSONObject(msg)
+	if err != nil {
+		return "", err
+	}
+	value, ok := getJSONPath(obj, c.responseField())
+	if !ok || value == nil {
+		return "", nil
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Restore sets the response field of a JSON object message to the original ID.
+func (c JSONFieldCorrelator) Restore(msg []byte, originalID any) ([]byte, error) {
+	obj, err := decodeJSONObject(msg)
+	if err != nil {
+		return nil, err
+	}
+	if originalID == nil {
+		deleteJSONPath(obj, c.responseField())
+	} else {
+		setJSONPath(obj, c.responseField(), originalID)
+	}
+	restored, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON message: %w", err)
+	}
+	return restored, nil
+}
+
+// responseField returns the response field path, falling back to the request field path.
+func (c JSONFieldCorrelator) responseField() string {
+	if c.ResponseField == "" {
+		return c.RequestField
+	}
+	return c.ResponseField
+}
+
+// decodeJSONObject decodes a JSON object, keeping numbers as json.Number to avoid precision loss.
+func decodeJSONObject(msg []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON message: %w", err)
+	}
+	if obj == nil {
+		return nil, errors.New("JSON message is not an object")
+	}
+	return obj, nil
+}
+
+// getJSONPath returns the value at the dot-separated path in obj.
+func getJSONPath(obj map[string]any, path string) (any, bool) {
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		value, ok := obj[key]
+		if !ok {
+			return nil, false
+		}
+		if i == len(keys)-1 {
+			return value, true
+		}
+		if obj, ok = value.(map[string]any); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// setJSONPath sets the value at the dot-separated path in obj, creating intermediate objects as
+// needed. Returns the previous value, or nil if there was none.
+func setJSONPath(obj map[string]any, path string, value any) any {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			obj[key] = next
+		}
+		obj = next
+	}
+	last := keys[len(keys)-1]
+	previous := obj[last]
+	obj[last] = value
+	return previous
+}
+
+// deleteJSONPath removes the value at the dot-separated path in obj, if present.
+func deleteJSONPath(obj map[string]any, path string) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			return
+		}
+		obj = next
+	}
+	delete(obj, keys[len(keys)-1])
+}
+
+//
+// GraphQL over WebSocket
+//
+
+// GraphQLWSCorrelator correlates operations of the graphql-transport-ws protocol. The payload may
+// be either a complete "subscribe" message, or only the operation, e.g. {"query":"{ hello }"},
+// in which case it is wrapped in a "subscribe" message.
+// Note: only the first result of an operation is reported. The operation is then completed, and
+// any later results are dropped.
+type GraphQLWSCorrelator struct {
+	// InitPayload is sent as the payload of the "connection_init" message, may be nil.
+	InitPayload json.RawMessage
+}
+
+// graphQLWSMessage is a message of the graphql-transport-ws protocol.
+type graphQLWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Subprotocols returns the graphql-transport-ws subprotocol.
+func (GraphQLWSCorrelator) Subprotocols() []string {
+	return []string{"graphql-transport-ws"}
+}
+
+// Handshake initializes the connection, and waits for the server to acknowledge it.
+func (c GraphQLWSCorrelator) Handshake(conn *websocket.Conn) error {
+	deadline := time.Now().Add(handshakeTimeout)
+	_ = conn.SetWriteDeadline(deadline)
+	_ = conn.SetReadDeadline(deadline)
+	defer conn.SetWriteDeadline(time.Time{})
+	defer conn.SetReadDeadline(time.Time{})
+
+	if err := conn.WriteJSON(graphQLWSMessage{Type: "connection_init", Payload: c.InitPayload}); err != nil {
+		return fmt.Errorf("failed to write connection_init: %w", err)
+	}
+	for {
+		var msg graphQLWSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return fmt.Errorf("failed to read connection_ack: %w", err)
+		}
+		switch msg.Type {
+		case "connection_ack":
+			return nil
+		case "ping":
+			if err := conn.WriteJSON(graphQLWSMessage{Type: "pong"}); err != nil {
+				return fmt.Errorf("failed to write pong: %w", err)
+			}
+		default:
+			return fmt.Errorf("unexpected message type during handshake: %q", msg.Type)
+		}
+	}
+}
+
+// Stamp sets the ID of a "subscribe" message to the correlation ID.
+func (GraphQLWSCorrelator) Stamp(msg []byte, correlationID string) ([]byte, any, error) {
+	var gqlMsg graphQLWSMessage
+	if err := json.Unmarshal(msg, &gqlMsg); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal GraphQL message: %w", err)
+	}
+	if gqlMsg.Type == "" {
+		// The payload is the bare operation
+		gqlMsg = graphQLWSMessage{Type: "subscribe", Payload: msg}
+	}
+	var originalID any
+	if gqlMsg.ID != "" {
+		originalID = gqlMsg.ID
+	}
+	gqlMsg.ID = correlationID
+
+	stamped, err := json.Marshal(gqlMsg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal GraphQL message: %w", err)
+	}
+	return stamped, originalID, nil
+}
+
+// Extract returns the ID of "next", "error" and "complete" messages. Other messages are ignored.
+func (GraphQLWSCorrelator) Extract(msg []byte) (string, error) {
+	var gqlMsg graphQLWSMessage
+	if err := json.Unmarshal(msg, &gqlMsg); err != nil {
+		return "", fmt.Errorf("failed to unmarshal GraphQL message: %w", err)
+	}
+	switch gqlMsg.Type {
+	case "next", "error", "complete":
+		if gqlMsg.ID == "" {
+			return "", fmt.Errorf("found empty ID on GraphQL %q message", gqlMsg.Type)
+		}
+		return gqlMsg.ID, nil
+	default:
+		// E.g. "ping" and "pong"
+		return "", nil
+	}
+}
+
+// Complete returns a "complete" message for the operation with the correlation ID.
+func (GraphQLWSCorrelator) Complete(correlationID string) ([]byte, error) {
+	return json.Marshal(graphQLWSMessage{ID: correlationID, Type: "complete"})
+}
+
+// Done checks if a message is an "error" or "complete" message, either of which ends an operation.
+func (GraphQLWSCorrelator) Done(msg []byte) bool {
+	var gqlMsg graphQLWSMessage
+	if err := json.Unmarshal(msg, &gqlMsg); err != nil {
+		return false
+	}
+	return gqlMsg.Type == "error" || gqlMsg.Type == "complete"
+}
+
+// Reply returns a "pong" message for a "ping" message, and nil for other messages.
+func (GraphQLWSCorrelator) Reply(msg []byte) ([]byte, error) {
+	var gqlMsg graphQLWSMessage
+	if err := json.Unmarshal(msg, &gqlMsg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal GraphQL message: %w", err)
+	}
+	if gqlMsg.Type != "ping" {
+		return nil, nil
+	}
+	return json.Marshal(graphQLWSMessage{Type: "pong"})
+}
+
+// Restore sets the original ID on a GraphQL message.
+func (GraphQLWSCorrelator) Restore(msg []byte, originalID any) ([]byte, error) {
+	var gqlMsg graphQLWSMessage
+	if err := json.Unmarshal(msg, &gqlMsg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal GraphQL message: %w", err)
+	}
+	gqlMsg.ID = ""
+	if originalID != nil {
+		gqlMsg.ID = fmt.Sprint(originalID)
+	}
+	restored, err := json.Marshal(gqlMsg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal GraphQL message: %w", err)
+	}
+	return restored, nil
+}
+
+//
+// STOMP
+//
+
+// STOMPCorrelator correlates STOMP frames using receipts: the "receipt" header of an outgoing
+// frame is set to the correlation ID, and the server answers with a RECEIPT, or ERROR, frame
+// carrying it in the "receipt-id" header.
+type STOMPCorrelator struct {
+	// Host is the virtual host sent in the CONNECT frame. Defaults to "/" when empty.
+	Host string
+	// Login and Passcode are sent in the CONNECT frame when set.
+	Login    string
+	Passcode string
+}
+
+// stompFrame is a single STOMP frame.
+type stompFrame struct {
+	command string
+	headers [][2]string
+	body    []byte
+}
+
+// header returns the value of the first header with the given name.
+func (f *stompFrame) header(name string) (string, bool) {
+	for _, h := range f.headers {
+		if h[0] == name {
+			return h[1], true
+		}
+	}
+	return "", false
+}
+
+// setHeader replaces all headers with the given name with a single header.
+func (f *stompFrame) setHeader(name, value string) {
+	f.delHeader(name)
+	f.headers = append(f.headers, [2]string{name, value})
+}
+
+// delHeader removes all headers with the given name.
+func (f *stompFrame) delHeader(name string) {
+	headers := f.headers[:0]
+	for _, h := range f.headers {
+		if h[0] != name {
+			headers = append(headers, h)
+		}
+	}
+	f.headers = headers
+}
+
+// marshal encodes the frame in the STOMP wire format.
+func (f *stompFrame) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(f.command)
+	buf.WriteByte('\n')
+	for _, h := range f.headers {
+		buf.WriteString(h[0])
+		buf.WriteByte(':')
+		buf.WriteString(h[1])
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// parseSTOMPFrame decodes a frame from the STOMP wire format. Returns a nil frame for heart-beats.
+func parseSTOMPFrame(msg []byte) (*stompFrame, error) {
+	msg = bytes.TrimLeft(msg, "\r\n")
+	if len(msg) == 0 {
+		return nil, nil
+	}
+	head, body, found := bytes.Cut(msg, []byte("\n\n"))
+	if !found {
+		if head, body, found = bytes.Cut(msg, []byte("\r\n\r\n")); !found {
+			return nil, errors.New("malformed STOMP frame: missing end of headers")
+		}
+	}
+	if i := bytes.IndexByte(body, 0); i >= 0 {
+		body = body[:i]
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(head), "\r\n", "\n"), "\n")
+	frame := &stompFrame{command: lines[0], body: body}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed STOMP header: %q", line)
+		}
+		frame.headers = append(frame.headers, [2]string{name, value})
+	}
+	return frame, nil
+}
+
+// Subprotocols returns the STOMP 1.2 subprotocol.
+func (STOMPCorrelator) Subprotocols() []string {
+	return []string{"v12.stomp"}
+}
+
+// Handshake sends a CONNECT frame, and waits for the server's CONNECTED frame.
+func (c STOMPCorrelator) Handshake(conn *websocket.Conn) error {
+	deadline := time.Now().Add(handshakeTimeout)
+	_ = conn.SetWriteDeadline(deadline)
+	_ = conn.SetReadDeadline(deadline)
+	defer conn.SetWriteDeadline(time.Time{})
+	defer conn.SetReadDeadline(time.Time{})
+
+	host := c.Host
+	if host == "" {
+		host = "/"
+	}
+	connect := &stompFrame{command: "CONNECT"}
+	connect.setHeader("accept-version", "1.2")
+	connect.setHeader("host", host)
+	connect.setHeader("heart-beat", "0,0")
+	if c.Login != "" {
+		connect.setHeader("login", c.Login)
+		connect.setHeader("passcode", c.Passcode)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, connect.marshal()); err != nil {
+		return fmt.Errorf("failed to write CONNECT frame: %w", err)
+	}
+
+	for {
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("failed to read CONNECTED frame: %w", err)
+		}
+		frame, err := parseSTOMPFrame(p)
+		if err != nil {
+			return err
+		}
+		if frame == nil {
+			continue // Heart-beat
+		}
+		switch frame.command {
+		case "CONNECTED":
+			return nil
+		case "ERROR":
+			message, _ := frame.header("message")
+			return fmt.Errorf("STOMP connection refused: %s", message)
+		default:
+			return fmt.Errorf("unexpected STOMP frame during handshake: %q", frame.command)
+		}
+	}
+}
+
+// Stamp sets the "receipt" header of a STOMP frame to the correlation ID.
+func (STOMPCorrelator) Stamp(msg []byte, correlationID string) ([]byte, any, error) {
+	frame, err := parseSTOMPFrame(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if frame == nil {
+		return nil, nil, errors.New("empty STOMP frame")
+	}
+	var originalID any
+	if receipt, ok := frame.header("receipt"); ok {
+		originalID = receipt
+	}
+	frame.setHeader("receipt", correlationID)
+	return frame.marshal(), originalID, nil
+}
+
+// Extract returns the "receipt-id" header of RECEIPT and ERROR frames. Other frames are ignored.
+func (STOMPCorrelator) Extract(msg []byte) (string, error) {
+	frame, err := parseSTOMPFrame(msg)
+	if err != nil {
+		return "", err
+	}
+	if frame == nil {
+		return "", nil // Heart-beat
+	}
+	switch frame.command {
+	case "RECEIPT":
+		receiptID, ok := frame.header("receipt-id")
+		if !ok {
+			return "", errors.New("found RECEIPT frame without receipt-id")
+		}
+		return receiptID, nil
+	case "ERROR":
+		receiptID, ok := frame.header("receipt-id")
+		if !ok {
+			message, _ := frame.header("message")
+			return "", fmt.Errorf("STOMP error frame: %s", message)
+		}
+		return receiptID, nil
+	default:
+		return "", nil
+	}
+}
+
+// Restore sets the "receipt-id" header of a STOMP frame to the original receipt.
+func (STOMPCorrelator) Restore(msg []byte, originalID any) ([]byte, error) {
+	frame, err := parseSTOMPFrame(msg)
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil {
+		return msg, nil
+	}
+	if originalID == nil {
+		frame.delHeader("receipt-id")
+	} else {
+		frame.setHeader("receipt-id", fmt.Sprint(originalID))
+	}
+	return frame.marshal(), nil
+}
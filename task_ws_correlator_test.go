@@ -0,0 +1,381 @@
+package wadjit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelatorsImplementWSCorrelator(t *testing.T) {
+	var _ WSCorrelator = JSONRPCCorrelator{}
+	var _ WSCorrelator = JSONFieldCorrelator{}
+	var _ WSCorrelator = GraphQLWSCorrelator{}
+	var _ WSCorrelator = STOMPCorrelator{}
+	var _ WSHandshaker = GraphQLWSCorrelator{}
+	var _ WSHandshaker = STOMPCorrelator{}
+	var _ WSStreamCorrelator = GraphQLWSCorrelator{}
+}
+
+func TestJSONRPCCorrelator(t *testing.T) {
+	c := JSONRPCCorrelator{}
+
+	stamped, originalID, err := c.Stamp([]byte(`{"jsonrpc":"2.0","id":7,"method":"echo"}`), "tmp-id")
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, originalID)
+	assert.Contains(t, string(stamped), `"id":"tmp-id"`)
+
+	id, err := c.Extract([]byte(`{"jsonrpc":"2.0","id":"tmp-id","result":"ok"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "tmp-id", id)
+
+	restored, err := c.Restore([]byte(`{"jsonrpc":"2.0","id":"tmp-id","result":"ok"}`), originalID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":7,"result":"ok"}`, string(restored))
+
+	_, _, err = c.Stamp(nil, "tmp-id")
+	assert.Error(t, err)
+	_, err = c.Extract([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestJSONFieldCorrelator(t *testing.T) {
+	c := JSONFieldCorrelator{RequestField: "meta.reqId", ResponseField: "meta.replyTo"}
+
+	stamped, originalID, err := c.Stamp([]byte(`{"op":"ping","meta":{"reqId":12345678901234567890}}`), "tmp-id")
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("12345678901234567890"), originalID)
+	assert.JSONEq(t, `{"op":"ping","meta":{"reqId":"tmp-id"}}`, string(stamped))
+
+	id, err := c.Extract([]byte(`{"op":"pong","meta":{"replyTo":"tmp-id"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "tmp-id", id)
+
+	// Messages without the field are ignored
+	id, err = c.Extract([]byte(`{"op":"heartbeat"}`))
+	require.NoError(t, err)
+	assert.Empty(t, id)
+
+	restored, err := c.Restore([]byte(`{"op":"pong","meta":{"replyTo":"tmp-id"}}`), originalID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"op":"pong","meta":{"replyTo":12345678901234567890}}`, string(restored))
+
+	// A message without an original ID gets the field removed
+	stamped, originalID, err = c.Stamp([]byte(`{"op":"ping"}`), "tmp-id")
+	require.NoError(t, err)
+	assert.Nil(t, originalID)
+	assert.JSONEq(t, `{"op":"ping","meta":{"reqId":"tmp-id"}}`, string(stamped))
+	restored, err = c.Restore([]byte(`{"op":"pong","meta":{"replyTo":"tmp-id"}}`), originalID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"op":"pong","meta":{}}`, string(restored))
+
+	_, _, err = c.Stamp([]byte(`[1,2]`), "tmp-id")
+	assert.Error(t, err)
+}
+
+func TestGraphQLWSCorrelator(t *testing.T) {
+	c := GraphQLWSCorrelator{}
+
+	t.Run("bare operation", func(t *testing.T) {
+		stamped, originalID, err := c.Stamp([]byte(`{"query":"{ hello }"}`), "tmp-id")
+		require.NoError(t, err)
+		assert.Nil(t, originalID)
+		assert.JSONEq(t, `{"id":"tmp-id","type":"subscribe","payload":{"query":"{ hello }"}}`, string(stamped))
+	})
+
+	t.Run("subscribe message", func(t *testing.T) {
+		msg := `{"id":"op-1","type":"subscribe","payload":{"query":"{ hello }"}}`
+		stamped, originalID, err := c.Stamp([]byte(msg), "tmp-id")
+		require.NoError(t, err)
+		assert.Equal(t, "op-1", originalID)
+		assert.JSONEq(t, `{"id":"tmp-id","type":"subscribe","payload":{"query":"{ hello }"}}`, string(stamped))
+
+		restored, err := c.Restore([]byte(`{"id":"tmp-id","type":"next","payload":{"data":{}}}`), originalID)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id":"op-1","type":"next","payload":{"data":{}}}`, string(restored))
+	})
+
+	t.Run("extract", func(t *testing.T) {
+		id, err := c.Extract([]byte(`{"id":"tmp-id","type":"next","payload":{}}`))
+		require.NoError(t, err)
+		assert.Equal(t, "tmp-id", id)
+
+		id, err = c.Extract([]byte(`{"id":"tmp-id","type":"error","payload":[]}`))
+		require.NoError(t, err)
+		assert.Equal(t, "tmp-id", id)
+
+		id, err = c.Extract([]byte(`{"id":"tmp-id","type":"complete"}`))
+		require.NoError(t, err)
+		assert.Equal(t, "tmp-id", id)
+
+		id, err = c.Extract([]byte(`{"type":"ping"}`))
+		require.NoError(t, err)
+		assert.Empty(t, id)
+
+		_, err = c.Extract([]byte(`{"type":"next"}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		complete, err := c.Complete("tmp-id")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id":"tmp-id","type":"complete"}`, string(complete))
+
+		assert.False(t, c.Done([]byte(`{"id":"tmp-id","type":"next","payload":{}}`)))
+		assert.True(t, c.Done([]byte(`{"id":"tmp-id","type":"error","payload":[]}`)))
+		assert.True(t, c.Done([]byte(`{"id":"tmp-id","type":"complete"}`)))
+
+		reply, err := c.Reply([]byte(`{"type":"ping"}`))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"type":"pong"}`, string(reply))
+		reply, err = c.Reply([]byte(`{"type":"pong"}`))
+		require.NoError(t, err)
+		assert.Nil(t, reply)
+	})
+}
+
+func TestSTOMPCorrelator(t *testing.T) {
+	c := STOMPCorrelator{}
+
+	frame := "SEND\ndestination:/queue/a\nreceipt:r-1\n\nhello\x00"
+	stamped, originalID, err := c.Stamp([]byte(frame), "tmp-id")
+	require.NoError(t, err)
+	assert.Equal(t, "r-1", originalID)
+	assert.Equal(t, "SEND\ndestination:/queue/a\nreceipt:tmp-id\n\nhello\x00", string(stamped))
+
+	id, err := c.Extract([]byte("RECEIPT\nreceipt-id:tmp-id\n\n\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, "tmp-id", id)
+
+	id, err = c.Extract([]byte("\n"))
+	require.NoError(t, err)
+	assert.Empty(t, id, "heart-beats are ignored")
+
+	id, err = c.Extract([]byte("MESSAGE\ndestination:/queue/a\n\nhi\x00"))
+	require.NoError(t, err)
+	assert.Empty(t, id, "non-receipt frames are ignored")
+
+	_, err = c.Extract([]byte("ERROR\nmessage:bad frame\n\n\x00"))
+	assert.ErrorContains(t, err, "bad frame")
+
+	restored, err := c.Restore([]byte("RECEIPT\nreceipt-id:tmp-id\n\n\x00"), originalID)
+	require.NoError(t, err)
+	assert.Equal(t, "RECEIPT\nreceipt-id:r-1\n\n\x00", string(restored))
+}
+
+func TestWSEndpointExecutePersistentCorrelated(t *testing.T) {
+	t.Run("JSON field", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(echoHandler))
+		defer server.Close()
+
+		u, err := url.Parse("ws" + server.URL[4:] + "/ws")
+		require.NoError(t, err)
+		responseChan := make(chan WatcherResponse, 1)
+
+		endpoint := &WSEndpoint{
+			URL:        u,
+			Mode:       PersistentCorrelated,
+			Payload:    []byte(`{"op":"ping","ref":"original"}`),
+			ID:         "an-id",
+			Correlator: JSONFieldCorrelator{RequestField: "ref"},
+		}
+		require.NoError(t, endpoint.Validate())
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		require.NoError(t, endpoint.Task().Execute())
+
+		select {
+		case resp := <-responseChan:
+			require.NoError(t, resp.Err)
+			data, err := resp.Data()
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"op":"ping","ref":"original"}`, string(data))
+			assert.Greater(t, resp.Metadata().TimeData.Latency, time.Duration(0))
+			assert.Equal(t, 0, syncMapLen(&endpoint.inflightMsgs))
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+	})
+
+	t.Run("GraphQL", func(t *testing.T) {
+		server, _ := graphQLWSServer(1)
+		defer server.Close()
+
+		u, err := url.Parse("ws" + server.URL[4:] + "/ws")
+		require.NoError(t, err)
+		responseChan := make(chan WatcherResponse, 1)
+
+		endpoint := &WSEndpoint{
+			URL:        u,
+			Mode:       PersistentCorrelated,
+			Payload:    []byte(`{"query":"{ hello }"}`),
+			ID:         "an-id",
+			Correlator: GraphQLWSCorrelator{},
+		}
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		require.NoError(t, endpoint.Task().Execute())
+
+		select {
+		case resp := <-responseChan:
+			require.NoError(t, resp.Err)
+			data, err := resp.Data()
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"type":"next","payload":{"data":{"hello":"world"}}}`, string(data))
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+
+		// The "complete" message must not produce a response
+		select {
+		case resp := <-responseChan:
+			t.Fatalf("unexpected response: %v", resp)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("GraphQL subscription", func(t *testing.T) {
+		server, received := graphQLWSServer(3)
+		defer server.Close()
+
+		u, err := url.Parse("ws" + server.URL[4:] + "/ws")
+		require.NoError(t, err)
+		responseChan := make(chan WatcherResponse, 4)
+
+		endpoint := &WSEndpoint{
+			URL:        u,
+			Mode:       PersistentCorrelated,
+			Payload:    []byte(`{"query":"subscription { hello }"}`),
+			ID:         "an-id",
+			Correlator: GraphQLWSCorrelator{},
+		}
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		for range 2 {
+			require.NoError(t, endpoint.Task().Execute())
+
+			select {
+			case resp := <-responseChan:
+				require.NoError(t, resp.Err)
+				data, err := resp.Data()
+				require.NoError(t, err)
+				assert.JSONEq(t, `{"type":"next","payload":{"data":{"hello":"world"}}}`, string(data))
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for response")
+			}
+
+			// The ping is answered, and the operation completed after its first result
+			for _, want := range []string{"pong", "complete"} {
+				select {
+				case msg := <-received:
+					assert.Equal(t, want, msg.Type)
+				case <-time.After(1 * time.Second):
+					t.Fatalf("timeout waiting for %q", want)
+				}
+			}
+		}
+
+		// The later results must not produce responses
+		select {
+		case resp := <-responseChan:
+			t.Fatalf("unexpected response: %v", resp)
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.Equal(t, 0, syncMapLen(&endpoint.inflightMsgs))
+	})
+
+	t.Run("missing correlator", func(t *testing.T) {
+		endpoint := &WSEndpoint{
+			URL:  &url.URL{Scheme: "ws", Host: "localhost"},
+			Mode: PersistentCorrelated,
+		}
+		assert.Error(t, endpoint.Validate())
+	})
+}
+
+// graphQLWSServer creates a test server speaking the graphql-transport-ws protocol, which answers
+// every operation with a "ping" message, the given number of "next" messages and a "complete"
+// message. The other messages received from the client after subscribing are sent on the returned
+// channel.
+func graphQLWSServer(results int) (*httptest.Server, <-chan graphQLWSMessage) {
+	gqlUpgrader := upgrader
+	gqlUpgrader.Subprotocols = []string{"graphql-transport-ws"}
+	received := make(chan graphQLWSMessage, 16)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := gqlUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var msg graphQLWSMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case "connection_init":
+				_ = conn.WriteJSON(graphQLWSMessage{Type: "connection_ack"})
+			case "subscribe":
+				_ = conn.WriteJSON(graphQLWSMessage{Type: "ping"})
+				for range results {
+					_ = conn.WriteJSON(graphQLWSMessage{
+						ID:      msg.ID,
+						Type:    "next",
+						Payload: json.RawMessage(`{"data":{"hello":"world"}}`),
+					})
+				}
+				_ = conn.WriteJSON(graphQLWSMessage{ID: msg.ID, Type: "complete"})
+			default:
+				received <- msg
+			}
+		}
+	})), received
+}
+
+func TestJSONRPCCorrelatorBatch(t *testing.T) {
+	c := JSONRPCCorrelator{}
+	batch := []byte(`[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","method":"notify"},{"jsonrpc":"2.0","id":"two","method":"b"}]`)
+	require.True(t, c.IsBatch(batch))
+	require.False(t, c.IsBatch([]byte(`{"jsonrpc":"2.0","id":1,"method":"a"}`)))
+
+	var n int
+	newID := func() string { n++; return "tmp-" + strconv.Itoa(n) }
+	stamped, originalIDs, err := c.StampBatch(batch, newID)
+	require.NoError(t, err)
+	require.Len(t, originalIDs, 2, "notifications are not stamped")
+	assert.EqualValues(t, 1, originalIDs["tmp-1"])
+	assert.Equal(t, "two", originalIDs["tmp-2"])
+
+	var elements []map[string]any
+	require.NoError(t, json.Unmarshal(stamped, &elements))
+	require.Len(t, elements, 3)
+	assert.Equal(t, "tmp-1", elements[0]["id"])
+	assert.Nil(t, elements[1]["id"])
+	assert.Equal(t, "tmp-2", elements[2]["id"])
+
+	// Responses may arrive in any order
+	response := []byte(`[{"jsonrpc":"2.0","id":"tmp-2","result":"b"},{"jsonrpc":"2.0","id":"tmp-1","error":{"code":-32000,"message":"failure"}}]`)
+	ids, err := c.ExtractBatch(response)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tmp-2", "tmp-1"}, ids)
+
+	restored, err := c.RestoreBatch(response, originalIDs)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","id":"two","result":"b"},{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"failure"}}]`, string(restored))
+
+	_, _, err = c.StampBatch([]byte(`[]`), newID)
+	assert.Error(t, err)
+	_, err = c.ExtractBatch([]byte(`[{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid"}}]`))
+	assert.Error(t, err)
+}
@@ -1,9 +1,12 @@
 package wadjit
 
 import (
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -290,6 +293,91 @@ func TestWSEndpointExecutewsPersistent(t *testing.T) {
 	})
 }
 
+func TestWSEndpointExecutewsPersistentJSONRPCError(t *testing.T) {
+	server := jsonRPCServer()
+	defer server.Close()
+
+	url, err := url.Parse("ws" + server.URL[4:] + "/ws")
+	require.NoError(t, err, "failed to parse URL")
+	responseChan := make(chan WatcherResponse, 1)
+
+	endpoint := &WSEndpoint{
+		URL:     url,
+		Header:  make(http.Header),
+		Mode:    PersistentJSONRPC,
+		Payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"fail"}`),
+		ID:      "an-id",
+	}
+	require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+	defer endpoint.Close()
+
+	require.NoError(t, endpoint.Task().Execute())
+
+	select {
+	case resp := <-responseChan:
+		// The error object is in the metadata only, the data remaining readable
+		require.NoError(t, resp.Err)
+		_, err := resp.Data()
+		require.NoError(t, err)
+		metadata := resp.Metadata()
+		require.Len(t, metadata.JSONRPCResults, 1)
+		assert.EqualValues(t, 1, metadata.JSONRPCResults[0].ID)
+		require.NotNil(t, metadata.JSONRPCResults[0].Error)
+		assert.Equal(t, -32000, metadata.JSONRPCResults[0].Error.Code)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for response")
+	}
+}
+
+func TestWSEndpointExecutewsPersistentBatch(t *testing.T) {
+	server := jsonRPCServer()
+	defer server.Close()
+
+	url, err := url.Parse("ws" + server.URL[4:] + "/ws")
+	require.NoError(t, err, "failed to parse URL")
+	responseChan := make(chan WatcherResponse, 1)
+
+	payload := []byte(`[{"jsonrpc":"2.0","id":1,"method":"echo"},{"jsonrpc":"2.0","method":"notify"},` +
+		`{"jsonrpc":"2.0","id":"b","method":"fail"}]`)
+	endpoint := &WSEndpoint{
+		URL:     url,
+		Header:  make(http.Header),
+		Mode:    PersistentJSONRPC,
+		Payload: payload,
+		ID:      "an-id",
+	}
+	require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+	defer endpoint.Close()
+
+	require.NoError(t, endpoint.Task().Execute())
+
+	select {
+	case resp := <-responseChan:
+		require.NoError(t, resp.Err)
+		assert.Equal(t, 0, syncMapLen(&endpoint.inflightMsgs), "all batch elements should be resolved")
+
+		// The original IDs are restored on each element
+		data, err := resp.Data()
+		require.NoError(t, err)
+		var elements []map[string]any
+		require.NoError(t, json.Unmarshal(data, &elements))
+		require.Len(t, elements, 2)
+		assert.EqualValues(t, 1, elements[0]["id"])
+		assert.Equal(t, "b", elements[1]["id"])
+
+		// Per-element outcome is surfaced in the metadata
+		metadata := resp.Metadata()
+		require.Len(t, metadata.JSONRPCResults, 2)
+		assert.Nil(t, metadata.JSONRPCResults[0].Error)
+		assert.NotEmpty(t, metadata.JSONRPCResults[0].Result)
+		require.NotNil(t, metadata.JSONRPCResults[1].Error)
+		assert.Equal(t, -32000, metadata.JSONRPCResults[1].Error.Code)
+		assert.Greater(t, metadata.TimeData.Latency, time.Duration(0))
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for response")
+	}
+}
+
 func TestWSEndpointExecutewsOneHit(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(echoHandler))
 	defer server.Close()
@@ -352,6 +440,144 @@ func TestWSEndpointExecutewsOneHit(t *testing.T) {
 	}
 }
 
+func TestWSEndpointDialTimings(t *testing.T) {
+	server := jsonRPCServer()
+	defer server.Close()
+
+	t.Run("OneHitText", func(t *testing.T) {
+		// Use localhost to incur a DNS lookup
+		u, err := url.Parse("ws://localhost:" + server.URL[strings.LastIndex(server.URL, ":")+1:] + "/ws")
+		require.NoError(t, err)
+		responseChan := make(chan WatcherResponse, 1)
+		endpoint := NewWSEndpoint(u, http.Header{}, OneHitText, []byte(`hello`), "an-id")
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+
+		require.NoError(t, endpoint.Task().Execute())
+		resp := <-responseChan
+		require.NoError(t, resp.Err)
+
+		td := resp.Metadata().TimeData
+		require.NotNil(t, td.DNSLookup)
+		require.NotNil(t, td.TCPConnect)
+		require.NotNil(t, td.Upgrade)
+		assert.Nil(t, td.TLSHandshake, "no TLS in this test")
+		assert.Greater(t, *td.TCPConnect, time.Duration(0))
+		assert.Greater(t, *td.Upgrade, time.Duration(0))
+		assert.Less(t, *td.DNSLookup+*td.TCPConnect+*td.Upgrade, td.Latency)
+	})
+
+	t.Run("PersistentJSONRPC", func(t *testing.T) {
+		u, err := url.Parse("ws" + server.URL[4:] + "/ws")
+		require.NoError(t, err)
+		responseChan := make(chan WatcherResponse, 1)
+		payload := []byte(`{"jsonrpc":"2.0","id":1,"method":"echo"}`)
+		endpoint := NewWSEndpoint(u, http.Header{}, PersistentJSONRPC, payload, "an-id")
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		// The first response on a new connection carries the connection phases
+		require.NoError(t, endpoint.Task().Execute())
+		resp := <-responseChan
+		require.NoError(t, resp.Err)
+		td := resp.Metadata().TimeData
+		assert.Nil(t, td.DNSLookup, "IP literal needs no DNS lookup")
+		require.NotNil(t, td.TCPConnect)
+		require.NotNil(t, td.Upgrade)
+		assert.Greater(t, *td.Upgrade, time.Duration(0))
+
+		// Later responses on the same connection do not
+		require.NoError(t, endpoint.Task().Execute())
+		resp = <-responseChan
+		require.NoError(t, resp.Err)
+		td = resp.Metadata().TimeData
+		assert.Nil(t, td.TCPConnect)
+		assert.Nil(t, td.Upgrade)
+
+		// A reconnect is reported again
+		require.NoError(t, endpoint.reconnect())
+		require.NoError(t, endpoint.Task().Execute())
+		resp = <-responseChan
+		require.NoError(t, resp.Err)
+		td = resp.Metadata().TimeData
+		assert.NotNil(t, td.TCPConnect)
+		assert.NotNil(t, td.Upgrade)
+	})
+}
+
+func TestWSEndpointTransportControl(t *testing.T) {
+	t.Run("bypasses DNS", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(echoHandler))
+		defer server.Close()
+
+		// Fake hostname that will not resolve
+		u, err := url.Parse("ws" + server.URL[4:] + "/ws")
+		require.NoError(t, err)
+		u.Host = "nonexistent.example.com"
+
+		realAddr := server.Listener.Addr().(*net.TCPAddr)
+		endpoint := NewWSEndpoint(u, http.Header{}, OneHitText, []byte(`hello`), "an-id")
+		endpoint.TransportControl = &TransportControl{AddrPort: realAddr.AddrPort()}
+		require.NoError(t, endpoint.Validate())
+
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		require.NoError(t, endpoint.Task().Execute())
+
+		resp := <-responseChan
+		require.NoError(t, resp.Err)
+		md := resp.Metadata()
+		assert.Nil(t, md.TimeData.DNSLookup)
+		assert.Equal(t, realAddr.AddrPort(), md.RemoteAddr.(*net.TCPAddr).AddrPort())
+		assert.Equal(t, "nonexistent.example.com", resp.URL.Hostname())
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(echoHandler))
+		defer server.Close()
+
+		u, err := url.Parse("wss" + server.URL[5:] + "/ws")
+		require.NoError(t, err)
+		u.Host = "geo.example.com"
+
+		realAddr := server.Listener.Addr().(*net.TCPAddr)
+		endpoint := &WSEndpoint{
+			URL:        u,
+			Mode:       PersistentCorrelated,
+			Payload:    []byte(`{"ref":1}`),
+			Correlator: JSONFieldCorrelator{RequestField: "ref"},
+			TransportControl: &TransportControl{
+				AddrPort:      realAddr.AddrPort(),
+				TLSEnabled:    true,
+				SkipTLSVerify: true, // accept self-signed cert from httptest
+			},
+		}
+		require.NoError(t, endpoint.Validate())
+
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("some-watcher-id", responseChan))
+		defer endpoint.Close()
+		require.NoError(t, endpoint.Task().Execute())
+
+		select {
+		case resp := <-responseChan:
+			require.NoError(t, resp.Err)
+			md := resp.Metadata()
+			assert.Nil(t, md.TimeData.DNSLookup)
+			require.NotNil(t, md.TimeData.TLSHandshake)
+			assert.Greater(t, *md.TimeData.TLSHandshake, time.Duration(0))
+			assert.Equal(t, realAddr.AddrPort(), md.RemoteAddr.(*net.TCPAddr).AddrPort())
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+	})
+
+	t.Run("empty address", func(t *testing.T) {
+		endpoint := NewWSEndpoint(&url.URL{Scheme: "ws", Host: "localhost"}, nil, OneHitText, nil, "")
+		endpoint.TransportControl = &TransportControl{}
+		assert.Error(t, endpoint.Validate())
+	})
+}
+
 func TestWSConnReconnect(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(echoHandler))
 	defer server.Close()
@@ -441,3 +667,47 @@ func TestNewWSEndpoint(t *testing.T) {
 		assert.Equal(t, PersistentJSONRPC, endpoint.Mode)
 	})
 }
+
+func TestWSEndpointHandleCorrelatedBatch(t *testing.T) {
+	newEndpoint := func() (*WSEndpoint, chan WatcherResponse) {
+		responseChan := make(chan WatcherResponse, 1)
+		endpoint := &WSEndpoint{ID: "an-id", Correlator: JSONRPCCorrelator{}, respChan: responseChan}
+		batchIDs := []string{"a", "b", "c"}
+		for i, id := range batchIDs {
+			endpoint.inflightMsgs.Store(id, wsInflightMessage{
+				inflightID: id,
+				originalID: float64(i + 1),
+				timeSent:   time.Now(),
+				batchIDs:   batchIDs,
+			})
+		}
+		return endpoint, responseChan
+	}
+	inflight := func(e *WSEndpoint, id string) bool {
+		_, ok := e.inflightMsgs.Load(id)
+		return ok
+	}
+	u := &url.URL{Scheme: "ws", Host: "localhost"}
+
+	t.Run("unknown ID", func(t *testing.T) {
+		endpoint, responseChan := newEndpoint()
+		endpoint.handleCorrelated([]byte(`[{"jsonrpc":"2.0","id":"a","result":1},{"jsonrpc":"2.0","id":"x","result":2}]`),
+			requestTimestamps{}, u)
+		resp := <-responseChan
+		assert.ErrorContains(t, resp.Err, "unknown response ID: x")
+		assert.Equal(t, 0, syncMapLen(&endpoint.inflightMsgs), "expected the batch to be deleted")
+	})
+
+	t.Run("partial response", func(t *testing.T) {
+		endpoint, responseChan := newEndpoint()
+		endpoint.handleCorrelated([]byte(`[{"jsonrpc":"2.0","id":"a","result":1}]`), requestTimestamps{}, u)
+		resp := <-responseChan
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.JSONEq(t, `[{"jsonrpc":"2.0","id":1,"result":1}]`, string(data))
+		for _, id := range []string{"a", "b", "c"} {
+			assert.False(t, inflight(endpoint, id), "expected %s to be deleted", id)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+package wadjit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// RequestTemplate renders the URL, headers and payload of a task's request at each execution,
+// with the text/template package. Templates are executed with a TemplateData, and can use the
+// functions of TemplateFuncs along with any set in Funcs.
+type RequestTemplate struct {
+	// URL replaces the URL of the task when non-empty.
+	URL string
+	// Header holds header values set on the task's header, replacing any values of the same key.
+	Header map[string]string
+	// Payload replaces the payload of the task when non-empty.
+	Payload string
+	// Funcs holds functions in addition to, or overriding, the built-in ones.
+	Funcs template.FuncMap
+}
+
+// TemplateData is the data a RequestTemplate is executed with.
+type TemplateData struct {
+	TaskID    string
+	WatcherID string
+	// Count is the number of the execution, starting at 1.
+	Count uint64
+	// Time is the time of the execution.
+	Time time.Time
+	// Previous is the previous response received by the task, nil before the first.
+	Previous *PreviousResponse
+	// Vars holds the variables extracted by the previous steps of a SequenceEndpoint.
+	Vars map[string]string
+}
+
+// PreviousResponse is a response received by a task, made available to the templates of its next
+// execution.
+type PreviousResponse struct {
+	// StatusCode and Header are those of an HTTP response, unset for other protocols.
+	StatusCode int
+	Header     http.Header
+	// Body is the response body, or message.
+	Body []byte
+	// Err is the error of the response, if any.
+	Err error
+}
+
+// JSON returns the body decoded as JSON, for templates to access its values, e.g.
+// {{ .Previous.JSON.result }}.
+func (p *PreviousResponse) JSON() (any, error) {
+	var v any
+	if err := json.Unmarshal(p.Body, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode previous response: %w", err)
+	}
+	return v, nil
+}
+
+// TemplateFuncs returns the built-in functions available to a RequestTemplate:
+//   - now: the current time
+//   - unix, unixMilli: the current Unix time in seconds or milliseconds
+//   - nonce: 16 random bytes, hex encoded
+//   - uuid: a random version 4 UUID
+//   - add: the sum of two integers
+//   - hexToInt: parses a hex number, with or without 0x prefix, e.g. an Ethereum quantity
+//   - intToHex: formats an integer as a 0x prefixed hex number
+//   - toJSON: encodes a value as JSON
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"now":       time.Now,
+		"unix":      func() int64 { return time.Now().Unix() },
+		"unixMilli": func() int64 { return time.Now().UnixMilli() },
+		"nonce":     func() string { return hex.EncodeToString(randomBytes(16)) },
+		"uuid":      randomUUID,
+		"add":       func(a, b int64) int64 { return a + b },
+		"hexToInt":  hexToInt,
+		"intToHex":  func(i int64) string { return "0x" + strconv.FormatInt(i, 16) },
+		"toJSON": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+}
+
+// randomBytes returns n random bytes.
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	// crypto/rand.Read never returns an error
+	_, _ = rand.Read(b)
+	return b
+}
+
+// randomUUID returns a random version 4 UUID, RFC 9562.
+func randomUUID() string {
+	b := randomBytes(16)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// hexToInt parses a hex number, with or without 0x prefix. Accepts any value formatting to a
+// string, since values decoded from JSON are of type any.
+func hexToInt(v any) (int64, error) {
+	s := fmt.Sprint(v)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	i, err := strconv.ParseInt(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex number %q", fmt.Sprint(v))
+	}
+	return i, nil
+}
+
+// validate checks that the templates of the RequestTemplate parse.
+func (t *RequestTemplate) validate() error {
+	_, err := t.compile()
+	return err
+}
+
+// validateHost checks that the URL template keeps the host of the task's URL u when a TLSConfig or
+// TransportControl is set, their server name and address being those of u. A TLSConfig with its
+// own ServerName allows any host.
+func (t *RequestTemplate) validateHost(u *url.URL, c *TLSConfig, tc *TransportControl) error {
+	if t.URL == "" || (c == nil || c.ServerName != "") && tc == nil {
+		return nil
+	}
+	if host, ok := templateURLHost(t.URL); !ok || !strings.EqualFold(host, u.Host) {
+		return fmt.Errorf("URL template must keep the host %s with TLS or TransportControl set", u.Host)
+	}
+	return nil
+}
+
+// templateURLHost returns the host of a URL template, and false if it is rendered by an action.
+func templateURLHost(text string) (string, bool) {
+	scheme, rest, ok := strings.Cut(text, "://")
+	if !ok || strings.Contains(scheme, "{{") {
+		return "", false
+	}
+	host := rest
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host = rest[:i]
+	}
+	if strings.Contains(host, "{{") {
+		return "", false
+	}
+	// Drop any user info, as url.URL.Host does
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return host, true
+}
+
+// compile parses the templates of the RequestTemplate into a templateState.
+func (t *RequestTemplate) compile() (*templateState, error) {
+	funcs := TemplateFuncs()
+	for name, fn := range t.Funcs {
+		funcs[name] = fn
+	}
+	parse := func(name, text string) (*template.Template, error) {
+		tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", name, err)
+		}
+		return tmpl, nil
+	}
+
+	s := &templateState{header: make(map[string]*template.Template, len(t.Header))}
+	var err error
+	if t.URL != "" {
+		if s.url, err = parse("URL", t.URL); err != nil {
+			return nil, err
+		}
+	}
+	if t.Payload != "" {
+		if s.payload, err = parse("payload", t.Payload); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range t.Header {
+		if key == "" {
+			return nil, errors.New("empty header key in template")
+		}
+		tmpl, err := parse("header "+key, value)
+		if err != nil {
+			return nil, err
+		}
+		s.header[key] = tmpl
+	}
+	return s, nil
+}
+
+// templateState holds the parsed templates of a task, along with the execution count and the
+// previous response they are executed with. A nil templateState renders requests unchanged.
+type templateState struct {
+	url     *template.Template
+	header  map[string]*template.Template
+	payload *template.Template
+
+	mu       sync.Mutex
+	count    uint64
+	previous *PreviousResponse
+	vars     map[string]string
+}
+
+// renderedRequest is the URL, header and payload of a request, rendered by a templateState.
+type renderedRequest struct {
+	url     *url.URL
+	header  http.Header
+	payload []byte
+}
+
+// next returns the data of the next execution of the task.
+func (s *templateState) next(taskID, watcherID string) TemplateData {
+	if s == nil {
+		return TemplateData{}
+	}
+	s.mu.Lock()
+	s.count++
+	s.mu.Unlock()
+	return s.current(taskID, watcherID)
+}
+
+// current returns the data of the latest execution of the task, for rendering outside of an
+// execution, e.g. when connecting.
+func (s *templateState) current(taskID, watcherID string) TemplateData {
+	if s == nil {
+		return TemplateData{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return TemplateData{
+		TaskID:    taskID,
+		WatcherID: watcherID,
+		Count:     s.count,
+		Time:      time.Now(),
+		Previous:  s.previous,
+		Vars:      s.vars,
+	}
+}
+
+// setPrevious sets the response made available to the next execution.
+func (s *templateState) setPrevious(p *PreviousResponse) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.previous = p
+}
+
+// setVars sets the variables made available to the next execution.
+func (s *templateState) setVars(vars map[string]string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vars = vars
+}
+
+// render renders the request of the task from its URL, header and payload with the given data.
+// The returned URL and header are copies, safe to modify.
+func (s *templateState) render(
+	data TemplateData,
+	u *url.URL,
+	header http.Header,
+	payload []byte,
+) (renderedRequest, error) {
+	// Clone the URL to avoid downstream mutation
+	urlClone := *u
+	r := renderedRequest{url: &urlClone, header: header.Clone(), payload: payload}
+	if r.header == nil {
+		r.header = make(http.Header)
+	}
+	if s == nil {
+		return r, nil
+	}
+
+	execute := func(tmpl *template.Template) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("failed to render template: %w", err)
+		}
+		return buf.String(), nil
+	}
+	if s.url != nil {
+		rawURL, err := execute(s.url)
+		if err != nil {
+			return r, err
+		}
+		renderedURL, err := url.Parse(rawURL)
+		if err != nil {
+			return r, fmt.Errorf("invalid rendered URL: %w", err)
+		}
+		r.url = renderedURL
+	}
+	for key, tmpl := range s.header {
+		value, err := execute(tmpl)
+		if err != nil {
+			return r, err
+		}
+		r.header.Set(key, value)
+	}
+	if s.payload != nil {
+		payload, err := execute(s.payload)
+		if err != nil {
+			return r, err
+		}
+		r.payload = []byte(payload)
+	}
+	return r, nil
+}
@@ -0,0 +1,242 @@
+package wadjit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	funcs := TemplateFuncs()
+
+	i, err := hexToInt("0x1a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(26), i)
+	i, err = hexToInt("FF")
+	require.NoError(t, err)
+	assert.Equal(t, int64(255), i)
+	_, err = hexToInt("0xzz")
+	assert.Error(t, err)
+
+	assert.Equal(t, "0x1b", funcs["intToHex"].(func(int64) string)(27))
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), randomUUID())
+	nonce := funcs["nonce"].(func() string)
+	assert.Len(t, nonce(), 32)
+	assert.NotEqual(t, nonce(), nonce())
+}
+
+func TestRequestTemplateRender(t *testing.T) {
+	u, err := url.Parse("http://example.com/static")
+	require.NoError(t, err)
+	header := http.Header{"X-Static": {"static"}, "X-Count": {"static"}}
+
+	t.Run("nil template", func(t *testing.T) {
+		var s *templateState
+		rendered, err := s.render(s.next("task", "watcher"), u, header, []byte("payload"))
+		require.NoError(t, err)
+		assert.Equal(t, u.String(), rendered.url.String())
+		assert.Equal(t, header, rendered.header)
+		assert.Equal(t, "payload", string(rendered.payload))
+	})
+
+	t.Run("render", func(t *testing.T) {
+		s, err := (&RequestTemplate{
+			URL:     "http://example.com/{{ .TaskID }}/{{ .Count }}",
+			Header:  map[string]string{"X-Count": "{{ .Count }}", "X-Custom": "{{ shout .WatcherID }}"},
+			Payload: `{{ with .Previous }}{{ .JSON.result | hexToInt | add 1 | intToHex }}{{ else }}none{{ end }}`,
+			Funcs:   template.FuncMap{"shout": func(s string) string { return s + "!" }},
+		}).compile()
+		require.NoError(t, err)
+
+		rendered, err := s.render(s.next("task", "watcher"), u, header, []byte("static"))
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/task/1", rendered.url.String())
+		assert.Equal(t, "static", rendered.header.Get("X-Static"))
+		assert.Equal(t, "1", rendered.header.Get("X-Count"))
+		assert.Equal(t, "watcher!", rendered.header.Get("X-Custom"))
+		assert.Equal(t, "none", string(rendered.payload))
+		assert.Equal(t, "static", header.Get("X-Count"), "expected the header not to be modified")
+
+		s.setPrevious(&PreviousResponse{Body: []byte(`{"result":"0x10"}`)})
+		rendered, err = s.render(s.next("task", "watcher"), u, header, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/task/2", rendered.url.String())
+		assert.Equal(t, "0x11", string(rendered.payload))
+
+		// A previous response without the value fails the rendering
+		s.setPrevious(&PreviousResponse{Body: []byte(`{}`)})
+		_, err = s.render(s.next("task", "watcher"), u, header, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Error(t, (&RequestTemplate{URL: "{{ .TaskID"}).validate())
+		assert.Error(t, (&RequestTemplate{Payload: "{{ unknown }}"}).validate())
+		assert.Error(t, (&RequestTemplate{Header: map[string]string{"": "value"}}).validate())
+		assert.NoError(t, (&RequestTemplate{Header: map[string]string{"X-Empty": ""}}).validate())
+	})
+}
+
+func TestHTTPEndpointTemplate(t *testing.T) {
+	type request struct {
+		query   url.Values
+		nonce   string
+		payload string
+	}
+	requests := make(chan request, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		requests <- request{query: r.URL.Query(), nonce: r.Header.Get("X-Nonce"), payload: string(payload)}
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"result": "0x" + strconv.FormatInt(int64(count*16), 16)})
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	ep := NewHTTPEndpoint(u, http.MethodPost, WithTemplate(&RequestTemplate{
+		URL:     server.URL + "/?count={{ .Count }}",
+		Header:  map[string]string{"X-Nonce": "{{ nonce }}"},
+		Payload: `{{ with .Previous }}{{ .StatusCode }} {{ .JSON.result | hexToInt | add 1 | intToHex }}{{ end }}`,
+	}))
+	require.NoError(t, ep.Validate())
+	respCh := make(chan WatcherResponse, 3)
+	require.NoError(t, ep.Initialize("wid", respCh))
+
+	var nonces []string
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, ep.Task().Execute())
+		resp := <-respCh
+		require.NoError(t, resp.Err)
+		assert.Equal(t, strconv.Itoa(i), resp.URL.Query().Get("count"))
+
+		req := <-requests
+		assert.Equal(t, strconv.Itoa(i), req.query.Get("count"))
+		assert.Len(t, req.nonce, 32)
+		assert.NotContains(t, nonces, req.nonce)
+		nonces = append(nonces, req.nonce)
+		switch i {
+		case 1:
+			assert.Empty(t, req.payload)
+		case 2:
+			assert.Equal(t, "200 0x11", req.payload)
+		case 3:
+			assert.Equal(t, "200 0x21", req.payload)
+		}
+	}
+
+	t.Run("invalid template", func(t *testing.T) {
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithTemplate(&RequestTemplate{URL: "{{ .Count"}))
+		assert.Error(t, ep.Validate())
+	})
+
+	t.Run("render error", func(t *testing.T) {
+		ep := NewHTTPEndpoint(u, http.MethodGet, WithTemplate(&RequestTemplate{Payload: "{{ .Previous.Body }}"}))
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		assert.Error(t, ep.Task().Execute())
+		resp := <-respCh
+		assert.Error(t, resp.Err)
+	})
+}
+
+func TestWSEndpointTemplate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+	wsURL, err := url.Parse("ws" + server.URL[len("http"):] + "/ws")
+	require.NoError(t, err)
+
+	t.Run("one hit", func(t *testing.T) {
+		endpoint := NewWSEndpoint(wsURL, nil, OneHitText, nil, "an-id")
+		endpoint.Template = &RequestTemplate{
+			Payload: `{{ .Count }}{{ with .Previous }} after {{ printf "%s" .Body }}{{ end }}`,
+		}
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		for _, want := range []string{"1", "2 after 1", "3 after 2 after 1"} {
+			require.NoError(t, endpoint.Task().Execute())
+			resp := <-responseChan
+			require.NoError(t, resp.Err)
+			data, err := resp.Data()
+			require.NoError(t, err)
+			assert.Equal(t, want, string(data))
+		}
+	})
+
+	t.Run("persistent", func(t *testing.T) {
+		jsonRPC := jsonRPCServer()
+		defer jsonRPC.Close()
+		u, err := url.Parse("ws" + jsonRPC.URL[len("http"):] + "/ws")
+		require.NoError(t, err)
+
+		endpoint := NewWSEndpoint(u, nil, PersistentJSONRPC, nil, "an-id")
+		endpoint.Template = &RequestTemplate{
+			Payload: `{"jsonrpc":"2.0","id":{{ .Count }},"method":"count","params":[{{ .Count }}]}`,
+		}
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		for i := 1; i <= 2; i++ {
+			require.NoError(t, endpoint.Task().Execute())
+			resp := <-responseChan
+			require.NoError(t, resp.Err)
+			results := resp.Metadata().JSONRPCResults
+			require.Len(t, results, 1)
+			assert.EqualValues(t, i, results[0].ID)
+		}
+	})
+}
+
+func TestRequestTemplateValidateHost(t *testing.T) {
+	u, err := url.Parse("https://node.test:8545/rpc")
+	require.NoError(t, err)
+	tlsConfig := &TLSConfig{SkipVerify: true}
+	control := &TransportControl{AddrPort: netip.MustParseAddrPort("127.0.0.1:8545")}
+
+	for _, tc := range []struct {
+		url     string
+		tls     *TLSConfig
+		tc      *TransportControl
+		wantErr bool
+	}{
+		{url: "https://{{ .Vars.host }}/rpc"},
+		{url: "https://node.test:8545/rpc?n={{ .Count }}", tls: tlsConfig},
+		{url: "https://user@NODE.test:8545", tc: control},
+		{url: "https://{{ .Vars.host }}/rpc", tls: tlsConfig, wantErr: true},
+		{url: "{{ .Vars.url }}", tc: control, wantErr: true},
+		{url: "https://other.test:8545/rpc", tls: tlsConfig, wantErr: true},
+		{url: "https://{{ .Vars.host }}/rpc", tls: &TLSConfig{ServerName: "node.test"}},
+		{url: "https://{{ .Vars.host }}/rpc", tls: &TLSConfig{ServerName: "node.test"}, tc: control, wantErr: true},
+	} {
+		err := (&RequestTemplate{URL: tc.url}).validateHost(u, tc.tls, tc.tc)
+		if tc.wantErr {
+			assert.Error(t, err, tc.url)
+		} else {
+			assert.NoError(t, err, tc.url)
+		}
+	}
+
+	ep := NewHTTPEndpoint(u, http.MethodGet, WithTLSConfig(tlsConfig),
+		WithTemplate(&RequestTemplate{URL: "https://{{ .Vars.host }}/rpc"}))
+	assert.Error(t, ep.Validate())
+	ws := NewWSEndpoint(&url.URL{Scheme: "wss", Host: "node.test"}, nil, OneHitText, nil, "an-id")
+	ws.TransportControl = control
+	ws.Template = &RequestTemplate{URL: "wss://{{ .Vars.host }}"}
+	assert.Error(t, ws.Validate())
+}
@@ -9,16 +9,20 @@ import (
 
 // requestTimestamps stores the timestamps of a request's phases.
 type requestTimestamps struct {
-	start     time.Time
-	dnsStart  time.Time
-	dnsDone   time.Time
-	connStart time.Time
-	connDone  time.Time
-	tlsStart  time.Time
-	tlsDone   time.Time
-	wroteDone time.Time
-	firstByte time.Time
-	dataDone  time.Time
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connStart    time.Time
+	connDone     time.Time
+	proxyStart   time.Time
+	proxyDone    time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	upgradeStart time.Time
+	upgradeDone  time.Time
+	wroteDone    time.Time
+	firstByte    time.Time
+	dataDone     time.Time
 }
 
 // RequestTimes represents the timing information for a layer 7 request.
@@ -37,8 +41,10 @@ type RequestTimes struct {
 	// Optional durations, nil when not applicable
 	RequestTimeTotal *time.Duration // Total time taken for a request, including full data transfer
 	DNSLookup        *time.Duration // DNS lookup duration
-	TCPConnect       *time.Duration // TCP connection duration
+	TCPConnect       *time.Duration // TCP connection duration, to the proxy when one is used
+	ProxyConnect     *time.Duration // Proxy tunnel duration, from proxy connection to tunnel ready
 	TLSHandshake     *time.Duration // TLS handshake duration
+	Upgrade          *time.Duration // WebSocket upgrade duration, from handshake request to 101 response
 	ServerProcessing *time.Duration // Server processing duration
 	DataTransfer     *time.Duration // Data transfer duration
 }
@@ -63,9 +69,15 @@ func TimeDataFromTimestamps(t requestTimestamps) RequestTimes {
 	if !t.connStart.IsZero() && !t.connDone.IsZero() {
 		req.TCPConnect = ptr(t.connDone.Sub(t.connStart))
 	}
+	if !t.proxyStart.IsZero() && !t.proxyDone.IsZero() {
+		req.ProxyConnect = ptr(t.proxyDone.Sub(t.proxyStart))
+	}
 	if !t.tlsStart.IsZero() && !t.tlsDone.IsZero() {
 		req.TLSHandshake = ptr(t.tlsDone.Sub(t.tlsStart))
 	}
+	if !t.upgradeStart.IsZero() && !t.upgradeDone.IsZero() {
+		req.Upgrade = ptr(t.upgradeDone.Sub(t.upgradeStart))
+	}
 	if !t.wroteDone.IsZero() && !t.firstByte.IsZero() {
 		req.ServerProcessing = ptr(t.firstByte.Sub(t.wroteDone))
 	}
@@ -0,0 +1,240 @@
+package wadjit
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TLSConfig is the TLS configuration of an HTTPEndpoint or WSEndpoint. PEM data is given either as
+// bytes or as a file path, bytes take precedence when both are set.
+type TLSConfig struct {
+	// Client certificate and private key, for mutual TLS.
+	CertPEM  []byte
+	KeyPEM   []byte
+	CertFile string
+	KeyFile  string
+
+	// RootCAs to verify the server certificate against, replacing the system roots when set.
+	RootCAsPEM  []byte
+	RootCAsFile string
+
+	// ServerName overrides the name used for SNI and verification of the server certificate,
+	// which defaults to the host of the endpoint URL.
+	ServerName string
+
+	// MinVersion and MaxVersion bound the negotiated TLS version, e.g. tls.VersionTLS12. Zero
+	// values leave the crypto/tls defaults in place.
+	MinVersion uint16
+	MaxVersion uint16
+
+	// PinnedSPKI holds base64 encoded SHA-256 hashes of the server certificates' Subject Public
+	// Key Info, optionally prefixed with "sha256/". When set, a handshake fails with a
+	// *TLSPinError unless a certificate of the verified chains matches one of the pins, or the leaf
+	// certificate when verification is skipped.
+	PinnedSPKI []string
+
+	// SkipVerify disables validation of the server certificate. Pins are still checked, against
+	// the leaf certificate only.
+	// Use with caution – intended mainly for tests or trusted internal endpoints.
+	SkipVerify bool
+}
+
+// TLSPinError is the error of a handshake where no certificate of the server's verified chains,
+// or its leaf certificate when verification is skipped, matches the pinned SPKI hashes.
+type TLSPinError struct {
+	ServerName string
+	// Presented holds the base64 encoded SPKI hashes of the certificates checked against the pins.
+	Presented []string
+}
+
+// Error returns a string representation of the pin mismatch.
+func (e *TLSPinError) Error() string {
+	return fmt.Sprintf("no certificate presented by %q matches the pinned SPKI hashes, got [%s]",
+		e.ServerName, strings.Join(e.Presented, ", "))
+}
+
+// TLSInfo describes a negotiated TLS connection, and the certificate chain presented by the peer.
+type TLSInfo struct {
+	// Version is the negotiated TLS version, e.g. tls.VersionTLS13. See tls.VersionName.
+	Version uint16
+	// CipherSuite is the negotiated cipher suite. See tls.CipherSuiteName.
+	CipherSuite uint16
+	ServerName  string
+	// ALPN is the application protocol negotiated with the server, e.g. "h2", empty if none was.
+	ALPN string
+	// OCSPStapled is true when the server stapled an OCSP response to the handshake.
+	OCSPStapled bool
+	// Chain holds the certificates presented by the peer, leaf first.
+	Chain []CertificateInfo
+}
+
+// CertificateInfo describes a single certificate of a chain.
+type CertificateInfo struct {
+	Subject     string
+	Issuer      string
+	DNSNames    []string
+	IPAddresses []net.IP
+	NotBefore   time.Time
+	NotAfter    time.Time
+	IsCA        bool
+}
+
+// newTLSInfo returns the TLSInfo of a connection state, or nil if the handshake did not complete.
+func newTLSInfo(cs *tls.ConnectionState) *TLSInfo {
+	if cs == nil || !cs.HandshakeComplete {
+		return nil
+	}
+	info := &TLSInfo{
+		Version:     cs.Version,
+		CipherSuite: cs.CipherSuite,
+		ServerName:  cs.ServerName,
+		ALPN:        cs.NegotiatedProtocol,
+		OCSPStapled: len(cs.OCSPResponse) > 0,
+		Chain:       make([]CertificateInfo, 0, len(cs.PeerCertificates)),
+	}
+	for _, cert := range cs.PeerCertificates {
+		info.Chain = append(info.Chain, CertificateInfo{
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			DNSNames:    cert.DNSNames,
+			IPAddresses: cert.IPAddresses,
+			NotBefore:   cert.NotBefore,
+			NotAfter:    cert.NotAfter,
+			IsCA:        cert.IsCA,
+		})
+	}
+	return info
+}
+
+// spkiHash returns the base64 encoded SHA-256 hash of a certificate's Subject Public Key Info.
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// build returns the crypto/tls configuration for a connection to the given server name.
+func (c *TLSConfig) build(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         c.MinVersion,
+		MaxVersion:         c.MaxVersion,
+		InsecureSkipVerify: c.SkipVerify,
+	}
+	if c.ServerName != "" {
+		cfg.ServerName = c.ServerName
+	}
+
+	certPEM, err := pemData(c.CertPEM, c.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	keyPEM, err := pemData(c.KeyPEM, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client key: %w", err)
+	}
+	if certPEM != nil || keyPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	rootsPEM, err := pemData(c.RootCAsPEM, c.RootCAsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root CAs: %w", err)
+	}
+	if rootsPEM != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(rootsPEM) {
+			return nil, errors.New("no root CA certificates found in PEM data")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(c.PinnedSPKI) > 0 {
+		pins := make([]string, len(c.PinnedSPKI))
+		for i, pin := range c.PinnedSPKI {
+			pin = strings.TrimPrefix(pin, "sha256/")
+			if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("invalid SPKI pin %q", c.PinnedSPKI[i])
+			}
+			pins[i] = pin
+		}
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			// Only certificates of the verified chains count, as the server can append any other,
+			// e.g. the pinned intermediate to a chain of its own
+			var certs []*x509.Certificate
+			for _, chain := range cs.VerifiedChains {
+				certs = append(certs, chain...)
+			}
+			if len(cs.VerifiedChains) == 0 && len(cs.PeerCertificates) > 0 {
+				certs = cs.PeerCertificates[:1]
+			}
+			var presented []string
+			for _, cert := range certs {
+				hash := spkiHash(cert)
+				if slices.Contains(pins, hash) {
+					return nil
+				}
+				if !slices.Contains(presented, hash) {
+					presented = append(presented, hash)
+				}
+			}
+			return &TLSPinError{ServerName: cfg.ServerName, Presented: presented}
+		}
+	}
+
+	return cfg, nil
+}
+
+// validate checks that the TLSConfig can be built.
+func (c *TLSConfig) validate() error {
+	if (c.CertPEM == nil && c.CertFile == "") != (c.KeyPEM == nil && c.KeyFile == "") {
+		return errors.New("TLSConfig requires both a client certificate and a key")
+	}
+	if _, err := c.build(""); err != nil {
+		return fmt.Errorf("invalid TLSConfig: %w", err)
+	}
+	return nil
+}
+
+// pemData returns the PEM bytes if set, otherwise the contents of the file if set, otherwise nil.
+func pemData(data []byte, file string) ([]byte, error) {
+	if data != nil {
+		return data, nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+// clientTLSConfig returns the TLS configuration for a task connecting to the given host, combining
+// the task's TLSConfig and TransportControl. Returns nil when neither configures TLS, leaving the
+// defaults in place.
+func clientTLSConfig(c *TLSConfig, tc *TransportControl, host string) (*tls.Config, error) {
+	if c == nil {
+		if tc == nil {
+			return nil, nil
+		}
+		return tc.tlsConfig(host), nil
+	}
+	cfg, err := c.build(host)
+	if err != nil {
+		return nil, err
+	}
+	if tc != nil && tc.SkipTLSVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
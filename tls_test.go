@@ -0,0 +1,401 @@
+package wadjit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPKI holds a CA, and a server and client certificate issued by it.
+type testPKI struct {
+	caCert        *x509.Certificate
+	caKey         *ecdsa.PrivateKey
+	caPEM         []byte
+	caPool        *x509.CertPool
+	serverCert    tls.Certificate
+	serverSPKI    string
+	clientCertPEM []byte
+	clientKeyPEM  []byte
+}
+
+// newTestPKI generates a CA, a server certificate valid for 127.0.0.1 and a client certificate.
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "wadjit test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, template *x509.Certificate) ([]byte, []byte, *x509.Certificate) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template.SerialNumber = big.NewInt(serial)
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+			cert
+	}
+
+	serverCertPEM, serverKeyPEM, serverCert := issue(2, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:    []string{"wadjit.test"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	serverKeyPair, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	require.NoError(t, err)
+	clientCertPEM, clientKeyPEM, _ := issue(3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "wadjit client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return testPKI{
+		caCert:        caCert,
+		caKey:         caKey,
+		caPEM:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		caPool:        pool,
+		serverCert:    serverKeyPair,
+		serverSPKI:    spkiHash(serverCert),
+		clientCertPEM: clientCertPEM,
+		clientKeyPEM:  clientKeyPEM,
+	}
+}
+
+// mTLSServer starts a server with the echoHandler requiring client certificates issued by the CA.
+func mTLSServer(pki testPKI) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.serverCert},
+		ClientCAs:    pki.caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	return server
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	pki := newTestPKI(t)
+
+	cfg := &TLSConfig{CertPEM: pki.clientCertPEM}
+	assert.Error(t, cfg.validate(), "expected error for certificate without key")
+
+	cfg = &TLSConfig{CertPEM: pki.clientCertPEM, KeyPEM: pki.caPEM}
+	assert.Error(t, cfg.validate(), "expected error for mismatched key")
+
+	cfg = &TLSConfig{RootCAsPEM: []byte("not PEM")}
+	assert.Error(t, cfg.validate(), "expected error for invalid root CAs")
+
+	cfg = &TLSConfig{RootCAsFile: filepath.Join(t.TempDir(), "missing.pem")}
+	assert.Error(t, cfg.validate(), "expected error for missing root CAs file")
+
+	cfg = &TLSConfig{PinnedSPKI: []string{"not-a-pin"}}
+	assert.Error(t, cfg.validate(), "expected error for invalid pin")
+
+	cfg = &TLSConfig{
+		CertPEM:    pki.clientCertPEM,
+		KeyPEM:     pki.clientKeyPEM,
+		RootCAsPEM: pki.caPEM,
+		PinnedSPKI: []string{"sha256/" + pki.serverSPKI},
+		MinVersion: tls.VersionTLS12,
+	}
+	assert.NoError(t, cfg.validate())
+}
+
+func TestHTTPEndpointTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+	server := mTLSServer(pki)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	execute := func(t *testing.T, cfg *TLSConfig) WatcherResponse {
+		t.Helper()
+		endpoint := NewHTTPEndpoint(serverURL, http.MethodGet, WithTLSConfig(cfg))
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		endpoint.Task().Execute()
+
+		select {
+		case resp := <-responseChan:
+			return resp
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+		return WatcherResponse{}
+	}
+
+	t.Run("mutual TLS", func(t *testing.T) {
+		resp := execute(t, &TLSConfig{
+			CertPEM:    pki.clientCertPEM,
+			KeyPEM:     pki.clientKeyPEM,
+			RootCAsPEM: pki.caPEM,
+		})
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "GET request received on path /", string(data))
+	})
+
+	t.Run("files", func(t *testing.T) {
+		dir := t.TempDir()
+		files := map[string][]byte{"client.pem": pki.clientCertPEM, "client.key": pki.clientKeyPEM, "ca.pem": pki.caPEM}
+		for name, data := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+		}
+		resp := execute(t, &TLSConfig{
+			CertFile:    filepath.Join(dir, "client.pem"),
+			KeyFile:     filepath.Join(dir, "client.key"),
+			RootCAsFile: filepath.Join(dir, "ca.pem"),
+			ServerName:  "wadjit.test",
+		})
+		assert.NoError(t, resp.Err)
+	})
+
+	t.Run("missing client certificate", func(t *testing.T) {
+		resp := execute(t, &TLSConfig{RootCAsPEM: pki.caPEM})
+		assert.Error(t, resp.Err)
+	})
+
+	t.Run("unknown CA", func(t *testing.T) {
+		resp := execute(t, &TLSConfig{CertPEM: pki.clientCertPEM, KeyPEM: pki.clientKeyPEM})
+		assert.Error(t, resp.Err)
+	})
+
+	t.Run("version bounds", func(t *testing.T) {
+		resp := execute(t, &TLSConfig{
+			CertPEM:    pki.clientCertPEM,
+			KeyPEM:     pki.clientKeyPEM,
+			RootCAsPEM: pki.caPEM,
+			MaxVersion: tls.VersionTLS12,
+		})
+		require.NoError(t, resp.Err)
+	})
+
+	t.Run("pin match", func(t *testing.T) {
+		resp := execute(t, &TLSConfig{
+			CertPEM:    pki.clientCertPEM,
+			KeyPEM:     pki.clientKeyPEM,
+			SkipVerify: true,
+			PinnedSPKI: []string{pki.serverSPKI},
+		})
+		assert.NoError(t, resp.Err)
+	})
+
+	t.Run("pin mismatch", func(t *testing.T) {
+		other := newTestPKI(t)
+		resp := execute(t, &TLSConfig{
+			CertPEM:    pki.clientCertPEM,
+			KeyPEM:     pki.clientKeyPEM,
+			RootCAsPEM: pki.caPEM,
+			PinnedSPKI: []string{other.serverSPKI},
+		})
+		require.Error(t, resp.Err)
+		var pinErr *TLSPinError
+		require.True(t, errors.As(resp.Err, &pinErr), "expected a *TLSPinError, got %v", resp.Err)
+		assert.Equal(t, "127.0.0.1", pinErr.ServerName)
+		assert.Contains(t, pinErr.Presented, pki.serverSPKI)
+	})
+}
+
+func TestTLSConfigPinAppendedCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestPKI(t)
+
+	// The server presents an unpinned leaf, with the pinned certificate appended to its chain
+	cert := other.serverCert
+	cert.Certificate = append(slices.Clone(cert.Certificate), pki.serverCert.Certificate[0])
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	for _, cfg := range []*TLSConfig{
+		{RootCAsPEM: other.caPEM, PinnedSPKI: []string{pki.serverSPKI}},
+		{SkipVerify: true, PinnedSPKI: []string{pki.serverSPKI}},
+	} {
+		endpoint := NewHTTPEndpoint(serverURL, http.MethodGet, WithTLSConfig(cfg))
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		endpoint.Task().Execute()
+		resp := <-responseChan
+
+		var pinErr *TLSPinError
+		require.True(t, errors.As(resp.Err, &pinErr), "expected a *TLSPinError, got %v", resp.Err)
+		assert.NotContains(t, pinErr.Presented, pki.serverSPKI)
+		assert.Contains(t, pinErr.Presented, other.serverSPKI)
+	}
+}
+
+func TestWSEndpointTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+	server := mTLSServer(pki)
+	defer server.Close()
+
+	wsURL, err := url.Parse("wss" + server.URL[len("https"):] + "/ws")
+	require.NoError(t, err)
+
+	t.Run("mutual TLS", func(t *testing.T) {
+		endpoint := NewWSEndpoint(wsURL, nil, OneHitText, []byte("hello"), "an-id")
+		endpoint.TLS = &TLSConfig{
+			CertPEM:    pki.clientCertPEM,
+			KeyPEM:     pki.clientKeyPEM,
+			RootCAsPEM: pki.caPEM,
+		}
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		assert.NoError(t, endpoint.Task().Execute())
+		resp := <-responseChan
+		require.NoError(t, resp.Err)
+		data, err := resp.Data()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("pin mismatch", func(t *testing.T) {
+		other := newTestPKI(t)
+		endpoint := NewWSEndpoint(wsURL, nil, PersistentCorrelated, nil, "an-id")
+		endpoint.Correlator = JSONFieldCorrelator{RequestField: "id", ResponseField: "id"}
+		endpoint.TLS = &TLSConfig{
+			CertPEM:    pki.clientCertPEM,
+			KeyPEM:     pki.clientKeyPEM,
+			RootCAsPEM: pki.caPEM,
+			PinnedSPKI: []string{other.serverSPKI},
+		}
+		require.NoError(t, endpoint.Validate())
+		err := endpoint.Initialize("a-watcher-id", make(chan WatcherResponse, 1))
+		require.Error(t, err)
+		var pinErr *TLSPinError
+		assert.True(t, errors.As(err, &pinErr), "expected a *TLSPinError, got %v", err)
+	})
+}
+
+func TestTLSInfoMetadata(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.serverCert.OCSPStaple = []byte("staple")
+	server := mTLSServer(pki)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	cfg := &TLSConfig{
+		CertPEM:    pki.clientCertPEM,
+		KeyPEM:     pki.clientKeyPEM,
+		RootCAsPEM: pki.caPEM,
+	}
+
+	assertTLSInfo := func(t *testing.T, info *TLSInfo) {
+		t.Helper()
+		require.NotNil(t, info)
+		assert.Equal(t, uint16(tls.VersionTLS13), info.Version)
+		assert.NotZero(t, info.CipherSuite)
+		assert.True(t, info.OCSPStapled)
+		require.Len(t, info.Chain, 1)
+		assert.Equal(t, "CN=127.0.0.1", info.Chain[0].Subject)
+		assert.Equal(t, "CN=wadjit test CA", info.Chain[0].Issuer)
+		assert.Equal(t, []string{"wadjit.test"}, info.Chain[0].DNSNames)
+		assert.True(t, info.Chain[0].NotAfter.After(time.Now()))
+	}
+
+	t.Run("HTTP", func(t *testing.T) {
+		endpoint := NewHTTPEndpoint(serverURL, http.MethodGet, WithTLSConfig(cfg))
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+
+		// The second request reuses the connection, without a new handshake
+		for range 2 {
+			require.NoError(t, endpoint.Task().Execute())
+			resp := <-responseChan
+			require.NoError(t, resp.Err)
+			_, err := resp.Data()
+			require.NoError(t, err)
+			assertTLSInfo(t, resp.Metadata().TLS)
+		}
+	})
+
+	t.Run("HTTP plaintext", func(t *testing.T) {
+		plainServer := httptest.NewServer(http.HandlerFunc(echoHandler))
+		defer plainServer.Close()
+		plainURL, err := url.Parse(plainServer.URL)
+		require.NoError(t, err)
+
+		endpoint := NewHTTPEndpoint(plainURL, http.MethodGet)
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		require.NoError(t, endpoint.Task().Execute())
+		resp := <-responseChan
+		require.NoError(t, resp.Err)
+		assert.Nil(t, resp.Metadata().TLS)
+	})
+
+	t.Run("WS", func(t *testing.T) {
+		wsURL, err := url.Parse("wss" + server.URL[len("https"):] + "/ws")
+		require.NoError(t, err)
+		endpoint := NewWSEndpoint(wsURL, nil, PersistentCorrelated, []byte(`{"id":"1"}`), "an-id")
+		endpoint.Correlator = JSONFieldCorrelator{RequestField: "id", ResponseField: "id"}
+		endpoint.TLS = cfg
+		require.NoError(t, endpoint.Validate())
+		responseChan := make(chan WatcherResponse, 1)
+		require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+		defer endpoint.Close()
+
+		require.NoError(t, endpoint.Task().Execute())
+		select {
+		case resp := <-responseChan:
+			require.NoError(t, resp.Err)
+			assertTLSInfo(t, resp.Metadata().TLS)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for response")
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package wadjit
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ValueKind is an enum for the type an extracted value is parsed as.
+type ValueKind int
+
+const (
+	// ValueKindString keeps the extracted value as-is.
+	ValueKindString ValueKind = iota
+	// ValueKindNumber parses the extracted value as a decimal number, or as a hexadecimal integer
+	// when prefixed by 0x, e.g. the result of eth_blockNumber.
+	ValueKindNumber
+	// ValueKindBool parses the extracted value as a boolean, see strconv.ParseBool.
+	ValueKindBool
+)
+
+// String returns the name of the kind.
+func (k ValueKind) String() string {
+	switch k {
+	case ValueKindString:
+		return "string"
+	case ValueKindNumber:
+		return "number"
+	case ValueKindBool:
+		return "bool"
+	default:
+		return "ValueKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// ValueExtractor extracts a value from the responses of a task, parsed as its kind. The values
+// extracted are set in WatcherResponse.Values, and numbers exported as gauges through the
+// MetricsExporter of the Wadjit, if any. Values holding a secret of the task are kept as redacted
+// strings, see SecretConfig.
+type ValueExtractor struct {
+	Extractor Extractor
+	Kind      ValueKind
+}
+
+// StringValue returns a ValueExtractor keeping the value extracted by e as a string.
+func StringValue(e Extractor) ValueExtractor {
+	return ValueExtractor{Extractor: e, Kind: ValueKindString}
+}
+
+// NumberValue returns a ValueExtractor parsing the value extracted by e as a number.
+func NumberValue(e Extractor) ValueExtractor {
+	return ValueExtractor{Extractor: e, Kind: ValueKindNumber}
+}
+
+// BoolValue returns a ValueExtractor parsing the value extracted by e as a boolean.
+func BoolValue(e Extractor) ValueExtractor {
+	return ValueExtractor{Extractor: e, Kind: ValueKindBool}
+}
+
+// Value is a value extracted from a response by a ValueExtractor.
+type Value struct {
+	Kind ValueKind
+	// Raw is the value as extracted, before parsing.
+	Raw string
+	// Number is the parsed value of a number.
+	Number float64
+	// Bool is the parsed value of a boolean.
+	Bool bool
+}
+
+// String returns the value as extracted.
+func (v Value) String() string {
+	return v.Raw
+}
+
+// parseValue parses raw as a value of the given kind.
+func parseValue(raw string, kind ValueKind) (Value, error) {
+	v := Value{Kind: kind, Raw: raw}
+	s := strings.Trim(strings.TrimSpace(raw), `"`)
+	var err error
+	switch kind {
+	case ValueKindString:
+	case ValueKindNumber:
+		v.Number, err = parseNumber(s)
+	case ValueKindBool:
+		v.Bool, err = strconv.ParseBool(s)
+	default:
+		err = fmt.Errorf("unsupported value kind %s", kind)
+	}
+	if err != nil {
+		return Value{}, fmt.Errorf("failed to parse %q as %s: %w", raw, kind, err)
+	}
+	return v, nil
+}
+
+// parseNumber parses a decimal number, or a hexadecimal integer prefixed by 0x. Hexadecimal
+// integers beyond 64 bits are rounded to the nearest float64.
+func parseNumber(s string) (float64, error) {
+	hex, ok := strings.CutPrefix(strings.ToLower(s), "0x")
+	if !ok {
+		return strconv.ParseFloat(s, 64)
+	}
+	if n, err := strconv.ParseUint(hex, 16, 64); err == nil {
+		return float64(n), nil
+	}
+	n, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		return 0, errors.New("invalid hexadecimal integer")
+	}
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return f, nil
+}
+
+// extractValues extracts and parses the values of a response, failing at the first value that
+// cannot be. Returns nil if there are no extractors.
+func extractValues(extractors map[string]ValueExtractor, resp TaskResponse) (map[string]Value, error) {
+	if len(extractors) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]Value, len(extractors))
+	for name, extractor := range extractors {
+		raw, err := extractor.Extractor(resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract value %q: %w", name, err)
+		}
+		value, err := parseValue(raw, extractor.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract value %q: %w", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// validateValueExtractors checks that the value extractors of a task are ready for use.
+func validateValueExtractors(extractors map[string]ValueExtractor) error {
+	for name, extractor := range extractors {
+		if name == "" || extractor.Extractor == nil {
+			return fmt.Errorf("invalid value extractor %q", name)
+		}
+		if extractor.Kind < ValueKindString || extractor.Kind > ValueKindBool {
+			return fmt.Errorf("unsupported kind %s of value %q", extractor.Kind, name)
+		}
+	}
+	return nil
+}
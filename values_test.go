@@ -0,0 +1,169 @@
+package wadjit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValue(t *testing.T) {
+	cases := []struct {
+		raw     string
+		kind    ValueKind
+		number  float64
+		boolean bool
+		wantErr bool
+	}{
+		{raw: "42", kind: ValueKindNumber, number: 42},
+		{raw: "-1.5e3", kind: ValueKindNumber, number: -1500},
+		{raw: "0x1b4", kind: ValueKindNumber, number: 436},
+		{raw: `"0X1B4"`, kind: ValueKindNumber, number: 436},
+		{raw: "0x10000000000000000", kind: ValueKindNumber, number: 1 << 64},
+		{raw: "0xzz", kind: ValueKindNumber, wantErr: true},
+		{raw: "abc", kind: ValueKindNumber, wantErr: true},
+		{raw: "true", kind: ValueKindBool, boolean: true},
+		{raw: "0", kind: ValueKindBool, boolean: false},
+		{raw: "yes", kind: ValueKindBool, wantErr: true},
+		{raw: " as-is ", kind: ValueKindString},
+		{raw: "x", kind: ValueKind(9), wantErr: true},
+	}
+	for _, tc := range cases {
+		v, err := parseValue(tc.raw, tc.kind)
+		if tc.wantErr {
+			assert.Error(t, err, "expected error for %q as %s", tc.raw, tc.kind)
+			continue
+		}
+		require.NoError(t, err, "unexpected error for %q as %s", tc.raw, tc.kind)
+		assert.Equal(t, tc.kind, v.Kind)
+		assert.Equal(t, tc.raw, v.String())
+		assert.Equal(t, tc.number, v.Number, "number of %q", tc.raw)
+		assert.Equal(t, tc.boolean, v.Bool, "bool of %q", tc.raw)
+	}
+}
+
+// gaugeRecorder is a MetricsExporter recording the gauges set.
+type gaugeRecorder struct {
+	mu     sync.Mutex
+	gauges map[string]float64
+	labels map[string]map[string]string
+}
+
+func (g *gaugeRecorder) SetGauge(name string, labels map[string]string, value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[name] = value
+	g.labels[name] = labels
+}
+
+func (g *gaugeRecorder) gauge(name string) (float64, map[string]string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	value, ok := g.gauges[name]
+	return value, g.labels[name], ok
+}
+
+// blockNumberHandler answers eth_blockNumber requests with a fixed block number.
+func blockNumberHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("X-Syncing", "false")
+	_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1b4"}`))
+}
+
+func TestHTTPEndpointValues(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(blockNumberHandler))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	execute := func(values map[string]ValueExtractor) WatcherResponse {
+		ep := NewHTTPEndpoint(u, http.MethodPost, WithValues(values))
+		require.NoError(t, ep.Validate())
+		respCh := make(chan WatcherResponse, 1)
+		require.NoError(t, ep.Initialize("wid", respCh))
+		require.NoError(t, ep.Task().Execute())
+		return <-respCh
+	}
+
+	resp := execute(map[string]ValueExtractor{
+		"block_height": NumberValue(ExtractJSON("result")),
+		"syncing":      BoolValue(ExtractHeader("X-Syncing")),
+		"jsonrpc":      StringValue(ExtractRegex(regexp.MustCompile(`"jsonrpc":"([^"]+)"`))),
+	})
+	require.NoError(t, resp.Err)
+	assert.Equal(t, 436.0, resp.Values["block_height"].Number)
+	assert.Equal(t, "0x1b4", resp.Values["block_height"].Raw)
+	assert.False(t, resp.Values["syncing"].Bool)
+	assert.Equal(t, ValueKindBool, resp.Values["syncing"].Kind)
+	assert.Equal(t, "2.0", resp.Values["jsonrpc"].String())
+
+	resp = execute(map[string]ValueExtractor{"missing": NumberValue(ExtractJSON("error.code"))})
+	assert.ErrorContains(t, resp.Err, `failed to extract value "missing"`)
+	assert.Nil(t, resp.Values)
+
+	resp = execute(nil)
+	require.NoError(t, resp.Err)
+	assert.Nil(t, resp.Values)
+
+	ep := NewHTTPEndpoint(u, http.MethodPost, WithValues(map[string]ValueExtractor{"nil": {}}))
+	assert.Error(t, ep.Validate())
+}
+
+func TestWSEndpointValues(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+	wsURL, err := url.Parse("ws" + strings.TrimPrefix(server.URL, "http") + "/ws")
+	require.NoError(t, err)
+
+	endpoint := NewWSEndpoint(wsURL, nil, OneHitText, []byte(`{"height":"0x10"}`), "an-id")
+	endpoint.Values = map[string]ValueExtractor{"height": NumberValue(ExtractJSON("height"))}
+	require.NoError(t, endpoint.Validate())
+	responseChan := make(chan WatcherResponse, 1)
+	require.NoError(t, endpoint.Initialize("a-watcher-id", responseChan))
+
+	require.NoError(t, endpoint.Task().Execute())
+	resp := <-responseChan
+	require.NoError(t, resp.Err)
+	assert.Equal(t, 16.0, resp.Values["height"].Number)
+}
+
+func TestWadjitMetricsExporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(blockNumberHandler))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	recorder := &gaugeRecorder{gauges: make(map[string]float64), labels: make(map[string]map[string]string)}
+	w := New(WithMetricsExporter(recorder))
+	defer func() {
+		assert.NoError(t, w.Close(), "error closing Wadjit")
+	}()
+
+	ep := NewHTTPEndpoint(u, http.MethodPost, WithID("node-1"), WithValues(map[string]ValueExtractor{
+		"block_height": NumberValue(ExtractJSON("result")),
+		"version":      StringValue(ExtractJSON("jsonrpc")),
+	}))
+	watcher, err := NewWatcher("watcher-1", 10*time.Millisecond, WatcherTasksToSlice(ep))
+	require.NoError(t, err)
+	require.NoError(t, w.AddWatcher(watcher))
+
+	select {
+	case resp := <-w.Responses():
+		require.NoError(t, resp.Err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for response")
+	}
+
+	value, labels, ok := recorder.gauge("block_height")
+	require.True(t, ok, "expected block_height gauge")
+	assert.Equal(t, 436.0, value)
+	assert.Equal(t, map[string]string{"watcher_id": "watcher-1", "task_id": "node-1"}, labels)
+	_, _, ok = recorder.gauge("version")
+	assert.False(t, ok, "expected no gauge for a string value")
+}
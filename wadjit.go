@@ -17,6 +17,8 @@ type Wadjit struct {
 	respGatherChan chan WatcherResponse
 	respExportChan chan WatcherResponse
 
+	metrics MetricsExporter
+
 	ctx    context.Context
 	cancel context.CancelFunc
 
@@ -169,8 +171,12 @@ func (w *Wadjit) listenForResponses() {
 				return // Context cancelled
 			}
 
+			// Export the numbers extracted from the response
+			if w.metrics != nil {
+				exportValues(w.metrics, resp)
+			}
+
 			// Send the response to the external facing channel
-			// TODO: consider adding Watcher response metrics here
 			w.respExportChan <- resp
 		case <-w.ctx.Done():
 			return
@@ -178,9 +184,18 @@ func (w *Wadjit) listenForResponses() {
 	}
 }
 
+// WadjitOption is a functional option for the Wadjit struct.
+type WadjitOption func(*Wadjit)
+
+// WithMetricsExporter configures the Wadjit to export metrics of the responses through the
+// provided MetricsExporter.
+func WithMetricsExporter(m MetricsExporter) WadjitOption {
+	return func(w *Wadjit) { w.metrics = m }
+}
+
 // New creates, and returns a new Wadjit. Note: Unless sends on the response channel are consumed,
 // a block may occur.
-func New() *Wadjit {
+func New(opts ...WadjitOption) *Wadjit {
 	ctx, cancel := context.WithCancel(context.Background())
 	w := &Wadjit{
 		watchers:       sync.Map{},
@@ -191,6 +206,10 @@ func New() *Wadjit {
 		cancel:         cancel,
 	}
 
+	for _, opt := range opts {
+		opt(w)
+	}
+
 	w.closeWG.Add(1)
 	go func() {
 		w.listenForResponses()